@@ -0,0 +1,660 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"microkernel/metrics"
+	"microkernel/microkernel"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout 测试事件未指定超时时的默认超时
+const DefaultTimeout = 5 * time.Second
+
+// Options 管理接口的配置
+type Options struct {
+	// 访问管理接口的 Bearer token，必须设置
+	Token string
+	// 由配置构建的运行时，设置后支持替换服务、重载配置并显示服务类型和版本
+	Runtime *microkernel.Runtime
+	// 快照使用的加密器，为空时使用 Runtime 的加密器
+	Crypter microkernel.Crypter
+	// 测试事件的默认超时，0 表示使用 DefaultTimeout
+	Timeout time.Duration
+}
+
+// Server 运行时管理内核的 HTTP 接口，所有请求都需要 Authorization: Bearer <token>
+//
+//	GET    /v1/services                     服务列表
+//	GET    /v1/services/{name}              服务详情
+//	GET    /v1/services/{name}/state        服务当前状态（未加密）
+//	GET    /v1/services/{name}/impact       停止服务时受影响的服务
+//	POST   /v1/services/{name}/{action}     start、stop、restart、replace、persist
+//	GET    /v1/graph                        依赖图，format=dot 或 mermaid 时导出为文本
+//	POST   /v1/persist                      保存所有服务的状态
+//	POST   /v1/snapshot                     下载加密的内核快照
+//	POST   /v1/events                       发送测试事件并返回回复，stream=1 时以 NDJSON 返回流式回复
+//	GET    /v1/deadletters                  死信列表
+//	DELETE /v1/deadletters                  清空死信
+//	POST   /v1/deadletters/{id}/redeliver   重新投递死信
+//	POST   /v1/reload                       重载配置文件
+//	GET    /v1/metrics                      Prometheus 文本格式的指标
+//
+// 响应为 JSON，错误以 {"error": "..."} 返回
+type Server struct {
+	kernel  *microkernel.MicroKernel
+	runtime *microkernel.Runtime
+	crypter microkernel.Crypter
+	token   []byte
+	timeout time.Duration
+	mux     *http.ServeMux
+}
+
+// New 创建管理接口
+func New(kernel *microkernel.MicroKernel, opts Options) (*Server, error) {
+	if opts.Token == "" {
+		return nil, errors.New("admin: token required")
+	}
+	s := &Server{
+		kernel:  kernel,
+		runtime: opts.Runtime,
+		crypter: opts.Crypter,
+		token:   []byte(opts.Token),
+		timeout: opts.Timeout,
+		mux:     http.NewServeMux(),
+	}
+	if s.crypter == nil && s.runtime != nil {
+		s.crypter = s.runtime.Crypter
+	}
+	if s.timeout == 0 {
+		s.timeout = DefaultTimeout
+	}
+	s.mux.HandleFunc("GET /v1/services", s.listServices)
+	s.mux.HandleFunc("GET /v1/services/{name}", s.getService)
+	s.mux.HandleFunc("GET /v1/services/{name}/state", s.exportState)
+	s.mux.HandleFunc("GET /v1/services/{name}/impact", s.impact)
+	s.mux.HandleFunc("POST /v1/services/{name}/{action}", s.serviceAction)
+	s.mux.HandleFunc("GET /v1/graph", s.graph)
+	s.mux.HandleFunc("POST /v1/persist", s.persistAll)
+	s.mux.HandleFunc("POST /v1/snapshot", s.snapshot)
+	s.mux.HandleFunc("POST /v1/events", s.sendEvent)
+	s.mux.HandleFunc("GET /v1/deadletters", s.listDeadLetters)
+	s.mux.HandleFunc("DELETE /v1/deadletters", s.clearDeadLetters)
+	s.mux.HandleFunc("POST /v1/deadletters/{id}/redeliver", s.redeliver)
+	s.mux.HandleFunc("POST /v1/reload", s.reload)
+	s.mux.Handle("GET /v1/metrics", kernel.Metrics().Handler())
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 使用常量时间比较，避免通过时间差猜测 token
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), s.token) != 1 {
+		writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe 在 addr 上提供管理接口，ctx 取消时关闭
+// addr 为 unix:/path 时监听 Unix socket（权限 0600，启动前删除残留的 socket 文件），
+// 否则为 host:port，host 必须是回环地址
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	ln, err := Listen(addr)
+	if err != nil {
+		return err
+	}
+	srv := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Listen 按管理接口的地址规则监听
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			os.Remove(path)
+		}
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Chmod(path, 0o600); err != nil {
+			ln.Close()
+			return nil, err
+		}
+		return ln, nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("admin: %s is not a loopback address", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// ServiceView 服务的运行信息
+type ServiceView struct {
+	Name  string `json:"name"`
+	State string `json:"state"`
+	// 服务类型和版本，只有按类型从注册表创建的服务才有
+	Type         string         `json:"type,omitempty"`
+	Version      string         `json:"version,omitempty"`
+	StateVersion int            `json:"state_version"`
+	Dependencies []string       `json:"dependencies"`
+	Dependents   []string       `json:"dependents"`
+	Restart      string         `json:"restart"`
+	Restarts     int            `json:"restarts"`
+	Persist      string         `json:"persist"`
+	Streaming    bool           `json:"streaming"`
+	Exportable   bool           `json:"exportable"`
+	Reconfigures bool           `json:"reconfigures"`
+	EventTypes   []string       `json:"event_types,omitempty"`
+	Metrics      []MetricSample `json:"metrics,omitempty"`
+}
+
+// MetricSample 带有 service 标签的一个指标样本，直方图只给出次数和总和
+type MetricSample struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+	Count  uint64            `json:"count,omitempty"`
+	Sum    float64           `json:"sum,omitempty"`
+}
+
+func (s *Server) view(info microkernel.ServiceInfo, fams []metrics.Family) ServiceView {
+	v := ServiceView{
+		Name:         info.Name,
+		State:        info.State.String(),
+		StateVersion: info.StateVersion,
+		Dependencies: info.Dependencies,
+		Dependents:   info.Dependents,
+		Restart:      restartString(info.Restart),
+		Restarts:     info.Restarts,
+		Persist:      persistString(info.Persist),
+		Streaming:    info.Streaming,
+		Exportable:   info.Exportable,
+		Reconfigures: info.Reconfigures,
+		EventTypes:   info.EventTypes,
+		Metrics:      serviceMetrics(info.Name, fams),
+	}
+	if s.runtime != nil {
+		if t, ok := s.runtime.ServiceType(info.Name); ok {
+			v.Type, v.Version = t.Name, t.Version
+		}
+	}
+	return v
+}
+
+func restartString(p microkernel.RestartPolicy) string {
+	out := p.Mode.String()
+	if p.MaxRestarts > 0 {
+		out += fmt.Sprintf(" max=%d", p.MaxRestarts)
+	}
+	if p.Backoff > 0 {
+		out += " backoff=" + p.Backoff.String()
+	}
+	return out
+}
+
+func persistString(p microkernel.PersistPolicy) string {
+	out := p.Mode.String()
+	if p.Interval > 0 {
+		out += " interval=" + p.Interval.String()
+	}
+	if p.Debounce > 0 {
+		out += " debounce=" + p.Debounce.String()
+	}
+	if p.TrackDirty {
+		out += " dirty"
+	}
+	return out
+}
+
+// serviceMetrics 选出 service 标签为 name 的样本
+func serviceMetrics(name string, fams []metrics.Family) []MetricSample {
+	var out []MetricSample
+	for _, f := range fams {
+		for _, sample := range f.Samples {
+			if sample.Labels["service"] != name {
+				continue
+			}
+			m := MetricSample{Name: f.Name, Value: sample.Value}
+			for k, v := range sample.Labels {
+				if k == "service" {
+					continue
+				}
+				if m.Labels == nil {
+					m.Labels = make(map[string]string)
+				}
+				m.Labels[k] = v
+			}
+			if h := sample.Histogram; h != nil {
+				m.Count, m.Sum = h.Count, h.Sum
+			}
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func (s *Server) listServices(w http.ResponseWriter, r *http.Request) {
+	fams := s.kernel.Metrics().Gather()
+	infos := s.kernel.Services()
+	views := make([]ServiceView, 0, len(infos))
+	for _, info := range infos {
+		views = append(views, s.view(info, fams))
+	}
+	writeJSON(w, http.StatusOK, views)
+}
+
+func (s *Server) getService(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.kernel.ServiceInfo(r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("service not registered"))
+		return
+	}
+	writeJSON(w, http.StatusOK, s.view(info, s.kernel.Metrics().Gather()))
+}
+
+// StateView 服务的状态，JSON 编码的状态放在 State 中，其他编码的状态以 base64 放在 Data 中
+type StateView struct {
+	Service  string          `json:"service"`
+	Version  int             `json:"version"`
+	Encoding string          `json:"encoding"`
+	State    json.RawMessage `json:"state,omitempty"`
+	Data     []byte          `json:"data,omitempty"`
+}
+
+func (s *Server) exportState(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, ok := s.kernel.Service(name); !ok {
+		writeError(w, http.StatusNotFound, errors.New("service not registered"))
+		return
+	}
+	env, err := s.kernel.ExportState(name)
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	v := StateView{Service: env.Service, Version: env.Version, Encoding: env.Encoding}
+	if env.Encoding == microkernel.EncodingJSON && json.Valid(env.Data) {
+		v.State = env.Data
+	} else {
+		v.Data = env.Data
+	}
+	writeJSON(w, http.StatusOK, v)
+}
+
+// GraphView 服务依赖图
+type GraphView struct {
+	Services []GraphNode `json:"services"`
+	// 未注册的服务 -> 依赖它的服务
+	Missing map[string][]string `json:"missing,omitempty"`
+	// 全部循环依赖，每个循环回到起点
+	Cycles [][]string `json:"cycles,omitempty"`
+	// 有循环或缺失依赖时为空
+	StartOrder     []string `json:"start_order,omitempty"`
+	CriticalPath   []string `json:"critical_path,omitempty"`
+	CriticalPathMs float64  `json:"critical_path_ms"`
+}
+
+// GraphNode 依赖图中的一个服务
+type GraphNode struct {
+	Name         string   `json:"name"`
+	State        string   `json:"state"`
+	Dependencies []string `json:"dependencies"`
+	Dependents   []string `json:"dependents"`
+	// 最近一次启动的耗时
+	StartMs float64 `json:"start_ms,omitempty"`
+}
+
+func (s *Server) graph(w http.ResponseWriter, r *http.Request) {
+	g := s.kernel.Graph()
+	switch format := r.URL.Query().Get("format"); format {
+	case "dot":
+		writeText(w, "text/vnd.graphviz", g.DOT())
+		return
+	case "mermaid":
+		writeText(w, "text/plain; charset=utf-8", g.Mermaid())
+		return
+	case "", "json":
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown format %q", format))
+		return
+	}
+	states := make(map[string]string)
+	for _, info := range s.kernel.Services() {
+		states[info.Name] = info.State.String()
+	}
+	v := GraphView{Services: make([]GraphNode, 0, len(g.Services)), Cycles: g.Cycles()}
+	if len(g.Missing) > 0 {
+		v.Missing = g.Missing
+	}
+	for _, name := range g.Services {
+		v.Services = append(v.Services, GraphNode{
+			Name:         name,
+			State:        states[name],
+			Dependencies: g.Dependencies[name],
+			Dependents:   g.Dependents[name],
+			StartMs:      milliseconds(g.StartDurations[name]),
+		})
+	}
+	v.StartOrder, _ = g.StartOrder()
+	path, d := g.CriticalPath()
+	v.CriticalPath, v.CriticalPathMs = path, milliseconds(d)
+	writeJSON(w, http.StatusOK, v)
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// ImpactView 停止服务时受影响的服务，按停止顺序排列
+type ImpactView struct {
+	Service string   `json:"service"`
+	Impact  []string `json:"impact"`
+}
+
+func (s *Server) impact(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if _, ok := s.kernel.Service(name); !ok {
+		writeError(w, http.StatusNotFound, errors.New("service not registered"))
+		return
+	}
+	writeJSON(w, http.StatusOK, ImpactView{Service: name, Impact: s.kernel.Graph().Impact(name)})
+}
+
+// ReplaceRequest 替换服务的请求体，字段为空时保留配置中的原值
+type ReplaceRequest struct {
+	Type   string         `json:"type"`
+	Params map[string]any `json:"params"`
+}
+
+// SaveResult 一次状态保存的结果
+type SaveResult struct {
+	Service   string `json:"service"`
+	Bytes     int    `json:"bytes"`
+	LatencyMs int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+func saveResult(r microkernel.SaveReport) SaveResult {
+	res := SaveResult{Service: r.Service, Bytes: r.Bytes, LatencyMs: r.Latency.Milliseconds()}
+	if r.Err != nil {
+		res.Error = r.Err.Error()
+	}
+	return res
+}
+
+func (s *Server) serviceAction(w http.ResponseWriter, r *http.Request) {
+	name, action := r.PathValue("name"), r.PathValue("action")
+	info, ok := s.kernel.ServiceInfo(name)
+	if !ok && action != "replace" {
+		writeError(w, http.StatusNotFound, errors.New("service not registered"))
+		return
+	}
+	var err error
+	switch action {
+	case "start":
+		if info.State == microkernel.Running {
+			writeError(w, http.StatusConflict, errors.New("service already started"))
+			return
+		}
+		err = s.kernel.StartService(name)
+	case "stop":
+		if info.State != microkernel.Running {
+			writeError(w, http.StatusConflict, errors.New("service not running"))
+			return
+		}
+		err = s.kernel.StopService(name)
+	case "restart":
+		err = s.kernel.RestartService(name)
+	case "persist":
+		report, err := s.kernel.PersistService(name)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, saveResult(report))
+		return
+	case "replace":
+		s.replace(w, r, name)
+		return
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", action))
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	info, _ = s.kernel.ServiceInfo(name)
+	writeJSON(w, http.StatusOK, s.view(info, nil))
+}
+
+// replace 修改服务声明的类型或参数并按重载规则应用
+func (s *Server) replace(w http.ResponseWriter, r *http.Request, name string) {
+	if s.runtime == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("kernel was not built from a config"))
+		return
+	}
+	var req ReplaceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	report, err := s.runtime.UpdateService(name, req.Type, req.Params)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reloadResult(report))
+}
+
+func (s *Server) persistAll(w http.ResponseWriter, r *http.Request) {
+	reports := s.kernel.PersistAll()
+	results := make([]SaveResult, 0, len(reports))
+	for _, report := range reports {
+		results = append(results, saveResult(report))
+	}
+	writeJSON(w, http.StatusOK, results)
+}
+
+func (s *Server) snapshot(w http.ResponseWriter, r *http.Request) {
+	if s.crypter == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("no crypter for snapshots"))
+		return
+	}
+	// 快照先写入内存，失败时还能返回错误状态码
+	var buf bytes.Buffer
+	if err := s.kernel.Snapshot(&buf, s.crypter); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="kernel.snapshot"`)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	buf.WriteTo(w)
+}
+
+// EventRequest 测试事件
+type EventRequest struct {
+	To      string `json:"to"`
+	Type    string `json:"type"`
+	Content string `json:"content"`
+	// 发送方，默认为 admin
+	From      string `json:"from"`
+	TimeoutMs int    `json:"timeout_ms"`
+}
+
+// ReplyView 事件的回复
+type ReplyView struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+}
+
+func replyView(r microkernel.Reply) ReplyView {
+	return ReplyView{Code: r.Code, Message: r.Message, Data: r.Data}
+}
+
+func (s *Server) sendEvent(w http.ResponseWriter, r *http.Request) {
+	var req EventRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if req.To == "" || req.Type == "" {
+		writeError(w, http.StatusBadRequest, errors.New("to and type are required"))
+		return
+	}
+	if req.From == "" {
+		req.From = "admin"
+	}
+	timeout := s.timeout
+	if req.TimeoutMs > 0 {
+		timeout = time.Duration(req.TimeoutMs) * time.Millisecond
+	}
+	evt := microkernel.Event{
+		From:      req.From,
+		To:        req.To,
+		Type:      req.Type,
+		Content:   req.Content,
+		TimeoutMs: int(timeout / time.Millisecond),
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	if r.URL.Query().Get("stream") == "1" {
+		s.stream(ctx, w, evt)
+		return
+	}
+	replyCh := make(chan microkernel.Reply, 1)
+	evt.ReplyCh = replyCh
+	s.kernel.Push(evt)
+	select {
+	case reply := <-replyCh:
+		writeJSON(w, http.StatusOK, replyView(reply))
+	case <-ctx.Done():
+		writeError(w, http.StatusGatewayTimeout, errors.New("timeout"))
+	}
+}
+
+// stream 以分块传输的 NDJSON 输出流式回复，每行一条回复
+func (s *Server) stream(ctx context.Context, w http.ResponseWriter, evt microkernel.Event) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+	replies, err := s.kernel.Stream(ctx, evt)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	enc := json.NewEncoder(w)
+	for reply := range replies {
+		enc.Encode(replyView(reply))
+		flusher.Flush()
+	}
+}
+
+func (s *Server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	letters := s.kernel.DeadLetters()
+	if letters == nil {
+		letters = []microkernel.DeadLetter{}
+	}
+	writeJSON(w, http.StatusOK, letters)
+}
+
+func (s *Server) clearDeadLetters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]int{"cleared": s.kernel.ClearDeadLetters()})
+}
+
+func (s *Server) redeliver(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.kernel.Redeliver(id); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]uint64{"redelivered": id})
+}
+
+// ReloadResult 重载或替换的结果
+type ReloadResult struct {
+	Added        []string `json:"added,omitempty"`
+	Removed      []string `json:"removed,omitempty"`
+	Replaced     []string `json:"replaced,omitempty"`
+	Reconfigured []string `json:"reconfigured,omitempty"`
+	Updated      []string `json:"updated,omitempty"`
+	Kernel       bool     `json:"kernel,omitempty"`
+	Summary      string   `json:"summary"`
+}
+
+func reloadResult(r microkernel.ReloadReport) ReloadResult {
+	return ReloadResult{
+		Added:        r.Added,
+		Removed:      r.Removed,
+		Replaced:     r.Replaced,
+		Reconfigured: r.Reconfigured,
+		Updated:      r.Updated,
+		Kernel:       r.Kernel,
+		Summary:      r.String(),
+	}
+}
+
+func (s *Server) reload(w http.ResponseWriter, r *http.Request) {
+	if s.runtime == nil {
+		writeError(w, http.StatusNotImplemented, errors.New("kernel was not built from a config"))
+		return
+	}
+	report, err := s.runtime.Reload()
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reloadResult(report))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeText(w http.ResponseWriter, contentType, text string) {
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, text)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"microkernel/admin"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// client 管理接口的 HTTP 客户端
+type client struct {
+	http  *http.Client
+	base  string
+	token string
+}
+
+// newClient addr 与内核的 -admin 参数格式相同：unix:/path 或 host:port
+func newClient(addr, token string) *client {
+	c := &client{http: &http.Client{}, base: "http://" + addr, token: token}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// Unix socket 的请求 URL 中的主机名不起作用
+		c.base = "http://admin"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+	}
+	return c
+}
+
+// apiError 管理接口返回的错误
+type apiError struct {
+	Status int
+	Msg    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s (HTTP %d)", e.Msg, e.Status)
+}
+
+// do 发送请求，body 不为 nil 时以 JSON 编码；返回状态码不是 2xx 时返回 *apiError
+func (c *client) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var e struct {
+			Error string `json:"error"`
+		}
+		data, _ := io.ReadAll(resp.Body)
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(data))
+		}
+		return nil, &apiError{Status: resp.StatusCode, Msg: e.Error}
+	}
+	return resp, nil
+}
+
+// call 发送请求并把 JSON 响应解码到 out
+func (c *client) call(ctx context.Context, method, path string, body, out any) error {
+	resp, err := c.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *client) services(ctx context.Context) ([]admin.ServiceView, error) {
+	var views []admin.ServiceView
+	return views, c.call(ctx, http.MethodGet, "/v1/services", nil, &views)
+}
+
+func (c *client) service(ctx context.Context, name string) (admin.ServiceView, error) {
+	var v admin.ServiceView
+	return v, c.call(ctx, http.MethodGet, "/v1/services/"+name, nil, &v)
+}
+
+// send 发送事件并等待回复
+func (c *client) send(ctx context.Context, req admin.EventRequest) (admin.ReplyView, error) {
+	var reply admin.ReplyView
+	return reply, c.call(ctx, http.MethodPost, "/v1/events", req, &reply)
+}
+
+// stream 发送事件并逐条处理流式回复，直到服务结束或 ctx 取消
+func (c *client) stream(ctx context.Context, req admin.EventRequest, fn func(admin.ReplyView) error) error {
+	resp, err := c.do(ctx, http.MethodPost, "/v1/events?stream=1", req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var reply admin.ReplyView
+		if err := json.Unmarshal(sc.Bytes(), &reply); err != nil {
+			return err
+		}
+		if err := fn(reply); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err != nil && !errors.Is(ctx.Err(), context.Canceled) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,755 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"microkernel/admin"
+	"microkernel/logger"
+	"microkernel/logstore"
+	"microkernel/microkernel"
+	"microkernel/service"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// env 命令的执行环境
+type env struct {
+	client *client
+	out    io.Writer
+	// 以 JSON 输出，供脚本使用
+	json bool
+	// 日志服务的名称
+	logger string
+}
+
+// command 一个子命令，REPL 和命令行共用
+type command struct {
+	name string
+	args string
+	help string
+	// complete 返回下一个位置参数的候选，prev 为已输入的位置参数
+	complete func(ctx context.Context, e *env, prev []string) []string
+	run      func(ctx context.Context, e *env, args []string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "services", help: "list services", run: cmdServices},
+		{name: "status", args: "SERVICE", help: "show a service in detail", complete: completeService, run: cmdStatus},
+		{name: "start", args: "SERVICE", help: "start a service", complete: completeService, run: lifecycle("start")},
+		{name: "stop", args: "SERVICE", help: "stop a service", complete: completeService, run: lifecycle("stop")},
+		{name: "restart", args: "SERVICE", help: "restart a service", complete: completeService, run: lifecycle("restart")},
+		{name: "replace", args: "[-type TYPE] SERVICE [KEY=VALUE ...]", help: "replace a service with a new type or params", complete: completeService, run: cmdReplace},
+		{name: "send", args: "[-stream] [-timeout D] [-from NAME] SERVICE TYPE [CONTENT ...]", help: "send an event and print the reply", complete: completeSend, run: cmdSend},
+		{name: "graph", args: "[-format tree|dot|mermaid]", help: "show the service dependency graph", run: cmdGraph},
+		{name: "impact", args: "SERVICE", help: "list services affected by stopping a service", complete: completeService, run: cmdImpact},
+		{name: "state", args: "dump SERVICE | save [SERVICE] | snapshot FILE", help: "dump, save or snapshot service state", complete: completeState, run: cmdState},
+		{name: "logs", args: "[-f] [-n N] [-level L] [-service S] [TEXT]", help: "query or follow the log store", run: cmdLogs},
+		{name: "deadletters", args: "[clear | redeliver ID]", help: "list, clear or redeliver dead letters", complete: completeWords("clear", "redeliver"), run: cmdDeadLetters},
+		{name: "reload", help: "reload the kernel config file", run: cmdReload},
+		{name: "metrics", help: "print metrics in Prometheus text format", run: cmdMetrics},
+		{name: "help", args: "[COMMAND]", help: "show help", complete: completeCommand, run: cmdHelp},
+	}
+}
+
+func lookupCommand(name string) (*command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// errUsage 参数错误，调用方打印命令的用法
+var errUsage = errors.New("usage")
+
+// runCommand 执行命令，参数错误时打印用法
+func runCommand(ctx context.Context, e *env, args []string) error {
+	c, ok := lookupCommand(args[0])
+	if !ok {
+		return fmt.Errorf("unknown command %q, try help", args[0])
+	}
+	err := c.run(ctx, e, args[1:])
+	if errors.Is(err, errUsage) {
+		// 带有详细信息的参数错误形如 "usage: flag provided but not defined: -x"
+		if detail := strings.TrimPrefix(err.Error(), errUsage.Error()+": "); detail != err.Error() {
+			return fmt.Errorf("%s\nusage: %s %s", detail, c.name, c.args)
+		}
+		return fmt.Errorf("usage: %s %s", c.name, c.args)
+	}
+	return err
+}
+
+// newFlagSet 子命令的参数，解析错误时返回 errUsage 而不是退出，REPL 中继续运行
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return fs
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return errUsage
+		}
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	return nil
+}
+
+func printJSON(w io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+func orDash(list ...string) string {
+	if len(list) == 0 || list[0] == "" {
+		return "-"
+	}
+	return strings.Join(list, ",")
+}
+
+// metricValue 服务指标中 name 的样本之和
+func metricValue(v admin.ServiceView, name string) float64 {
+	var sum float64
+	for _, m := range v.Metrics {
+		if m.Name == name {
+			sum += m.Value
+		}
+	}
+	return sum
+}
+
+func cmdServices(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	views, err := e.client.services(ctx)
+	if err != nil {
+		return err
+	}
+	if e.json {
+		return printJSON(e.out, views)
+	}
+	w := tabwriter.NewWriter(e.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATE\tTYPE\tVERSION\tDEPENDS ON\tRESTARTS\tEVENTS")
+	for _, v := range views {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%g\n", v.Name, v.State, orDash(v.Type), orDash(v.Version),
+			orDash(v.Dependencies...), v.Restarts, metricValue(v, "microkernel_events_handled_total"))
+	}
+	return w.Flush()
+}
+
+func cmdStatus(ctx context.Context, e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	v, err := e.client.service(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if e.json {
+		return printJSON(e.out, v)
+	}
+	printService(e.out, v)
+	return nil
+}
+
+func printService(out io.Writer, v admin.ServiceView) {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	var features []string
+	for _, f := range []struct {
+		name string
+		on   bool
+	}{{"streaming", v.Streaming}, {"exportable", v.Exportable}, {"reconfigurable", v.Reconfigures}} {
+		if f.on {
+			features = append(features, f.name)
+		}
+	}
+	fmt.Fprintf(w, "Name:\t%s\n", v.Name)
+	fmt.Fprintf(w, "State:\t%s\n", v.State)
+	fmt.Fprintf(w, "Type:\t%s %s\n", orDash(v.Type), v.Version)
+	fmt.Fprintf(w, "State version:\t%d\n", v.StateVersion)
+	fmt.Fprintf(w, "Depends on:\t%s\n", orDash(v.Dependencies...))
+	fmt.Fprintf(w, "Dependents:\t%s\n", orDash(v.Dependents...))
+	fmt.Fprintf(w, "Restart:\t%s (%d restarts)\n", v.Restart, v.Restarts)
+	fmt.Fprintf(w, "Persist:\t%s\n", v.Persist)
+	fmt.Fprintf(w, "Features:\t%s\n", orDash(strings.Join(features, ", ")))
+	fmt.Fprintf(w, "Event types:\t%s\n", orDash(v.EventTypes...))
+	w.Flush()
+	if len(v.Metrics) == 0 {
+		return
+	}
+	fmt.Fprintln(out, "Metrics:")
+	w = tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for _, m := range v.Metrics {
+		name := m.Name
+		if len(m.Labels) > 0 {
+			keys := make([]string, 0, len(m.Labels))
+			for k := range m.Labels {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			pairs := make([]string, len(keys))
+			for i, k := range keys {
+				pairs[i] = fmt.Sprintf("%s=%q", k, m.Labels[k])
+			}
+			name += "{" + strings.Join(pairs, ",") + "}"
+		}
+		if m.Count > 0 || strings.HasSuffix(m.Name, "_seconds") || strings.HasSuffix(m.Name, "_bytes") {
+			fmt.Fprintf(w, "  %s\tcount=%d sum=%g\n", name, m.Count, m.Sum)
+		} else {
+			fmt.Fprintf(w, "  %s\t%g\n", name, m.Value)
+		}
+	}
+	w.Flush()
+}
+
+// lifecycle 启动、停止或重启服务，输出服务的新状态
+func lifecycle(action string) func(context.Context, *env, []string) error {
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) != 1 {
+			return errUsage
+		}
+		var v admin.ServiceView
+		if err := e.client.call(ctx, http.MethodPost, "/v1/services/"+args[0]+"/"+action, nil, &v); err != nil {
+			return err
+		}
+		if e.json {
+			return printJSON(e.out, v)
+		}
+		_, err := fmt.Fprintf(e.out, "%s: %s\n", v.Name, v.State)
+		return err
+	}
+}
+
+func cmdReplace(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("replace")
+	typ := fs.String("type", "", "new service type")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 {
+		return errUsage
+	}
+	req := admin.ReplaceRequest{Type: *typ}
+	for _, kv := range fs.Args()[1:] {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("%w: param %q is not KEY=VALUE", errUsage, kv)
+		}
+		if req.Params == nil {
+			req.Params = make(map[string]any)
+		}
+		req.Params[key] = paramValue(value)
+	}
+	var res admin.ReloadResult
+	if err := e.client.call(ctx, http.MethodPost, "/v1/services/"+fs.Arg(0)+"/replace", req, &res); err != nil {
+		return err
+	}
+	if e.json {
+		return printJSON(e.out, res)
+	}
+	_, err := fmt.Fprintln(e.out, res.Summary)
+	return err
+}
+
+// paramValue 参数值按 JSON 解析（数字、布尔、数组等），失败时作为字符串
+func paramValue(s string) any {
+	var v any
+	if err := json.Unmarshal([]byte(s), &v); err == nil {
+		return v
+	}
+	return s
+}
+
+func cmdSend(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("send")
+	stream := fs.Bool("stream", false, "print streamed replies")
+	timeout := fs.Duration("timeout", 0, "reply timeout")
+	from := fs.String("from", "", "sender name")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return errUsage
+	}
+	req := admin.EventRequest{
+		To:        fs.Arg(0),
+		Type:      fs.Arg(1),
+		Content:   strings.Join(fs.Args()[2:], " "),
+		From:      *from,
+		TimeoutMs: int(*timeout / time.Millisecond),
+	}
+	if *stream {
+		return e.client.stream(ctx, req, func(r admin.ReplyView) error {
+			return printReply(e, r)
+		})
+	}
+	reply, err := e.client.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	return printReply(e, reply)
+}
+
+func printReply(e *env, r admin.ReplyView) error {
+	if e.json {
+		return json.NewEncoder(e.out).Encode(r)
+	}
+	fmt.Fprintf(e.out, "[%d] %s\n", r.Code, r.Message)
+	if r.Data != "" {
+		fmt.Fprintln(e.out, r.Data)
+	}
+	return nil
+}
+
+func cmdGraph(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("graph")
+	format := fs.String("format", "tree", "tree, dot or mermaid")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+	switch *format {
+	case "dot", "mermaid":
+		resp, err := e.client.do(ctx, http.MethodGet, "/v1/graph?format="+*format, nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.Copy(e.out, resp.Body)
+		return err
+	case "tree":
+	default:
+		return fmt.Errorf("%w: unknown format %q", errUsage, *format)
+	}
+	var g admin.GraphView
+	if err := e.client.call(ctx, http.MethodGet, "/v1/graph", nil, &g); err != nil {
+		return err
+	}
+	if e.json {
+		return printJSON(e.out, g)
+	}
+	printTree(e.out, g)
+	for _, c := range g.Cycles {
+		fmt.Fprintf(e.out, "cycle: %s\n", strings.Join(c, " -> "))
+	}
+	missing := make([]string, 0, len(g.Missing))
+	for dep := range g.Missing {
+		missing = append(missing, dep)
+	}
+	sort.Strings(missing)
+	for _, dep := range missing {
+		fmt.Fprintf(e.out, "missing: %s (required by %s)\n", dep, strings.Join(g.Missing[dep], ", "))
+	}
+	if len(g.CriticalPath) > 0 {
+		fmt.Fprintf(e.out, "critical path: %s (%.3fms)\n", strings.Join(g.CriticalPath, " -> "), g.CriticalPathMs)
+	}
+	return nil
+}
+
+// printTree 从没有依赖的服务开始，按被依赖关系输出树，依赖方在下层
+// 有多个依赖的服务在每个依赖下都出现，已展开过的只输出名称
+func printTree(out io.Writer, g admin.GraphView) {
+	byName := make(map[string]admin.GraphNode, len(g.Services))
+	for _, n := range g.Services {
+		byName[n.Name] = n
+	}
+	expanded := make(map[string]bool)
+	var walk func(name, prefix string, last, root bool)
+	walk = func(name, prefix string, last, root bool) {
+		branch, next := "├── ", prefix+"│   "
+		if last {
+			branch, next = "└── ", prefix+"    "
+		}
+		if root {
+			branch, next = "", ""
+		}
+		n := byName[name]
+		if expanded[name] {
+			fmt.Fprintf(out, "%s%s%s ...\n", prefix, branch, name)
+			return
+		}
+		expanded[name] = true
+		fmt.Fprintf(out, "%s%s%s (%s)\n", prefix, branch, name, n.State)
+		for i, dep := range n.Dependents {
+			walk(dep, next, i == len(n.Dependents)-1, false)
+		}
+	}
+	// 只依赖缺失服务的服务也作为根
+	for _, n := range g.Services {
+		root := true
+		for _, dep := range n.Dependencies {
+			if _, ok := byName[dep]; ok {
+				root = false
+			}
+		}
+		if root {
+			walk(n.Name, "", true, true)
+		}
+	}
+	// 循环依赖中的服务没有根，单独列出
+	for _, n := range g.Services {
+		if !expanded[n.Name] {
+			walk(n.Name, "", true, true)
+		}
+	}
+}
+
+func cmdImpact(ctx context.Context, e *env, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	var v admin.ImpactView
+	if err := e.client.call(ctx, http.MethodGet, "/v1/services/"+args[0]+"/impact", nil, &v); err != nil {
+		return err
+	}
+	if e.json {
+		return printJSON(e.out, v)
+	}
+	if len(v.Impact) == 0 {
+		_, err := fmt.Fprintf(e.out, "no services depend on %s\n", v.Service)
+		return err
+	}
+	_, err := fmt.Fprintf(e.out, "stopping %s affects: %s\n", v.Service, strings.Join(v.Impact, ", "))
+	return err
+}
+
+func cmdState(ctx context.Context, e *env, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	switch sub, args := args[0], args[1:]; {
+	case sub == "dump" && len(args) == 1:
+		return stateDump(ctx, e, args[0])
+	case sub == "save" && len(args) <= 1:
+		return stateSave(ctx, e, args)
+	case sub == "snapshot" && len(args) == 1:
+		return stateSnapshot(ctx, e, args[0])
+	}
+	return errUsage
+}
+
+// stateDump 输出服务当前的状态，JSON 编码的状态格式化输出，其他编码原样输出
+func stateDump(ctx context.Context, e *env, name string) error {
+	var v admin.StateView
+	if err := e.client.call(ctx, http.MethodGet, "/v1/services/"+name+"/state", nil, &v); err != nil {
+		return err
+	}
+	if e.json {
+		return printJSON(e.out, v)
+	}
+	if v.State == nil {
+		_, err := e.out.Write(v.Data)
+		return err
+	}
+	return printJSON(e.out, v.State)
+}
+
+func stateSave(ctx context.Context, e *env, args []string) error {
+	var results []admin.SaveResult
+	if len(args) == 1 {
+		var r admin.SaveResult
+		if err := e.client.call(ctx, http.MethodPost, "/v1/services/"+args[0]+"/persist", nil, &r); err != nil {
+			return err
+		}
+		results = append(results, r)
+	} else if err := e.client.call(ctx, http.MethodPost, "/v1/persist", nil, &results); err != nil {
+		return err
+	}
+	if e.json {
+		return printJSON(e.out, results)
+	}
+	w := tabwriter.NewWriter(e.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tBYTES\tLATENCY\tERROR")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%d\t%dms\t%s\n", r.Service, r.Bytes, r.LatencyMs, orDash(r.Error))
+	}
+	return w.Flush()
+}
+
+// stateSnapshot 把加密的内核快照写入文件
+func stateSnapshot(ctx context.Context, e *env, path string) error {
+	resp, err := e.client.do(ctx, http.MethodPost, "/v1/snapshot", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, resp.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if e.json {
+		return printJSON(e.out, map[string]any{"file": path, "bytes": n})
+	}
+	_, err = fmt.Fprintf(e.out, "snapshot written to %s (%d bytes)\n", path, n)
+	return err
+}
+
+func cmdLogs(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("logs")
+	follow := fs.Bool("f", false, "follow new entries")
+	n := fs.Int("n", 20, "number of entries")
+	level := fs.String("level", "TRACE", "minimum level")
+	svc := fs.String("service", "", "only entries of this service")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	q := service.LogQuery{Query: logstore.Query{Text: strings.Join(fs.Args(), " "), Limit: *n}}
+	if err := q.Level.UnmarshalText([]byte(*level)); err != nil {
+		return err
+	}
+	if *svc != "" {
+		q.Services = []string{*svc}
+	}
+	req := admin.EventRequest{To: e.logger, Type: service.EventLogQuery}
+	if *follow {
+		q.Limit, q.Tail = 0, *n
+		req.Type = service.EventLogFollow
+		// 跟踪直到用户中断
+		req.TimeoutMs = int((24 * time.Hour) / time.Millisecond)
+	}
+	content, err := json.Marshal(q)
+	if err != nil {
+		return err
+	}
+	req.Content = string(content)
+
+	if *follow {
+		return e.client.stream(ctx, req, func(r admin.ReplyView) error {
+			if r.Code != 0 {
+				return fmt.Errorf("%s (code %d)", r.Message, r.Code)
+			}
+			if e.json {
+				_, err := fmt.Fprintln(e.out, r.Data)
+				return err
+			}
+			var entry logstore.Entry
+			if err := json.Unmarshal([]byte(r.Data), &entry); err != nil {
+				return err
+			}
+			printEntry(e.out, entry)
+			return nil
+		})
+	}
+	reply, err := e.client.send(ctx, req)
+	if err != nil {
+		return err
+	}
+	if reply.Code != 0 {
+		return fmt.Errorf("%s (code %d)", reply.Message, reply.Code)
+	}
+	var entries []logstore.Entry
+	if err := json.Unmarshal([]byte(reply.Data), &entries); err != nil {
+		return err
+	}
+	if e.json {
+		return printJSON(e.out, entries)
+	}
+	for _, entry := range entries {
+		printEntry(e.out, entry)
+	}
+	return nil
+}
+
+// printEntry 按日志器文本格式输出一条存储的日志
+func printEntry(out io.Writer, entry logstore.Entry) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] [%s] [%s] %s", entry.Time.Local().Format("2006-01-02 15:04:05.000"), entry.Level, entry.Service, entry.Msg)
+	keys := make([]string, 0, len(entry.Fields))
+	for k := range entry.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := fmt.Sprint(entry.Fields[k])
+		if strings.ContainsAny(v, " \"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	fmt.Fprintln(out, b.String())
+}
+
+// logLevelNames 日志级别，用于补全 -level 的值
+var logLevelNames = []string{logger.TRACE.String(), logger.DEBUG.String(), logger.INFO.String(), logger.WARN.String(), logger.ERROR.String()}
+
+func cmdDeadLetters(ctx context.Context, e *env, args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "clear":
+		var res map[string]int
+		if err := e.client.call(ctx, http.MethodDelete, "/v1/deadletters", nil, &res); err != nil {
+			return err
+		}
+		if e.json {
+			return printJSON(e.out, res)
+		}
+		_, err := fmt.Fprintf(e.out, "%d dead letters cleared\n", res["cleared"])
+		return err
+	case len(args) == 2 && args[0] == "redeliver":
+		if err := e.client.call(ctx, http.MethodPost, "/v1/deadletters/"+args[1]+"/redeliver", nil, nil); err != nil {
+			return err
+		}
+		if e.json {
+			return printJSON(e.out, map[string]string{"redelivered": args[1]})
+		}
+		_, err := fmt.Fprintf(e.out, "dead letter %s redelivered\n", args[1])
+		return err
+	case len(args) != 0:
+		return errUsage
+	}
+	var letters []microkernel.DeadLetter
+	if err := e.client.call(ctx, http.MethodGet, "/v1/deadletters", nil, &letters); err != nil {
+		return err
+	}
+	if e.json {
+		return printJSON(e.out, letters)
+	}
+	w := tabwriter.NewWriter(e.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tREASON\tCODE\tFROM\tTO\tTYPE\tERROR")
+	for _, d := range letters {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", d.ID, d.Time.Local().Format("15:04:05"), d.Reason, d.Code,
+			d.Event.From, d.Event.To, d.Event.Type, orDash(d.Error))
+	}
+	return w.Flush()
+}
+
+func cmdReload(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	var res admin.ReloadResult
+	if err := e.client.call(ctx, http.MethodPost, "/v1/reload", nil, &res); err != nil {
+		return err
+	}
+	if e.json {
+		return printJSON(e.out, res)
+	}
+	_, err := fmt.Fprintln(e.out, res.Summary)
+	return err
+}
+
+func cmdMetrics(ctx context.Context, e *env, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	resp, err := e.client.do(ctx, http.MethodGet, "/v1/metrics", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(e.out, resp.Body)
+	return err
+}
+
+func cmdHelp(ctx context.Context, e *env, args []string) error {
+	if len(args) == 1 {
+		c, ok := lookupCommand(args[0])
+		if !ok {
+			return fmt.Errorf("unknown command %q", args[0])
+		}
+		_, err := fmt.Fprintf(e.out, "%s %s\n    %s\n", c.name, c.args, c.help)
+		return err
+	}
+	w := tabwriter.NewWriter(e.out, 0, 4, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", c.name, c.args, c.help)
+	}
+	return w.Flush()
+}
+
+// 参数补全
+
+func completeService(ctx context.Context, e *env, prev []string) []string {
+	if len(prev) > 0 {
+		return nil
+	}
+	return serviceNames(ctx, e)
+}
+
+func serviceNames(ctx context.Context, e *env) []string {
+	views, err := e.client.services(ctx)
+	if err != nil {
+		return nil
+	}
+	names := make([]string, len(views))
+	for i, v := range views {
+		names[i] = v.Name
+	}
+	return names
+}
+
+// completeSend 第一个参数补全服务名称，第二个参数补全该服务声明的事件类型
+func completeSend(ctx context.Context, e *env, prev []string) []string {
+	switch len(prev) {
+	case 0:
+		return serviceNames(ctx, e)
+	case 1:
+		v, err := e.client.service(ctx, prev[0])
+		if err != nil {
+			return nil
+		}
+		return v.EventTypes
+	}
+	return nil
+}
+
+func completeState(ctx context.Context, e *env, prev []string) []string {
+	switch {
+	case len(prev) == 0:
+		return []string{"dump", "save", "snapshot"}
+	case len(prev) == 1 && prev[0] != "snapshot":
+		return serviceNames(ctx, e)
+	}
+	return nil
+}
+
+func completeWords(words ...string) func(context.Context, *env, []string) []string {
+	return func(_ context.Context, _ *env, prev []string) []string {
+		if len(prev) > 0 {
+			return nil
+		}
+		return words
+	}
+}
+
+func completeCommand(_ context.Context, _ *env, prev []string) []string {
+	if len(prev) > 0 {
+		return nil
+	}
+	return commandNames()
+}
+
+func commandNames() []string {
+	names := make([]string, len(commands))
+	for i, c := range commands {
+		names[i] = c.name
+	}
+	return names
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// editor 终端行编辑器：左右移动、历史、Ctrl-A/E/U/K/W 和 Tab 补全
+// 只在读取一行时切换到原始模式，命令执行期间终端保持正常模式
+type editor struct {
+	in       *bufio.Reader
+	out      io.Writer
+	fd       int
+	history  []string
+	complete func(line string) (start int, candidates []string)
+}
+
+func newEditor(in *os.File, out io.Writer, complete func(string) (int, []string)) *editor {
+	return &editor{in: bufio.NewReader(in), out: out, fd: int(in.Fd()), complete: complete}
+}
+
+// supported 输入是否为支持原始模式的终端
+func (ed *editor) supported() bool {
+	restore, err := makeRaw(ed.fd)
+	if err != nil {
+		return false
+	}
+	restore()
+	return true
+}
+
+// 控制字符
+const (
+	keyCtrlA     = 1
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyBackspace = 8
+	keyTab       = 9
+	keyCtrlK     = 11
+	keyEnter     = 13
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyDelete    = 127
+)
+
+func (ed *editor) readLine(prompt string) (string, error) {
+	restore, err := makeRaw(ed.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	var buf []rune
+	pos := 0
+	hist := len(ed.history)
+	redraw := func() {
+		fmt.Fprintf(ed.out, "\r\x1b[K%s%s", prompt, string(buf))
+		if back := len(buf) - pos; back > 0 {
+			fmt.Fprintf(ed.out, "\x1b[%dD", back)
+		}
+	}
+	setLine := func(s string) {
+		buf = []rune(s)
+		pos = len(buf)
+	}
+	fmt.Fprint(ed.out, prompt)
+	for {
+		r, _, err := ed.in.ReadRune()
+		if err != nil {
+			fmt.Fprint(ed.out, "\n")
+			return "", err
+		}
+		switch r {
+		case keyEnter, '\n':
+			fmt.Fprint(ed.out, "\n")
+			line := string(buf)
+			if strings.TrimSpace(line) != "" && (len(ed.history) == 0 || ed.history[len(ed.history)-1] != line) {
+				ed.history = append(ed.history, line)
+			}
+			return line, nil
+		case keyCtrlC:
+			// 放弃当前输入
+			fmt.Fprint(ed.out, "^C\n")
+			buf, pos, hist = nil, 0, len(ed.history)
+		case keyCtrlD:
+			if len(buf) == 0 {
+				fmt.Fprint(ed.out, "\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case keyDelete, keyBackspace:
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case keyCtrlA:
+			pos = 0
+		case keyCtrlE:
+			pos = len(buf)
+		case keyCtrlU:
+			buf, pos = append([]rune{}, buf[pos:]...), 0
+		case keyCtrlK:
+			buf = buf[:pos]
+		case keyCtrlW:
+			i := pos
+			for i > 0 && buf[i-1] == ' ' {
+				i--
+			}
+			for i > 0 && buf[i-1] != ' ' {
+				i--
+			}
+			buf, pos = append(buf[:i], buf[pos:]...), i
+		case keyTab:
+			ed.tab(&buf, &pos)
+		case keyEscape:
+			switch ed.escape() {
+			case 'A':
+				if hist > 0 {
+					hist--
+					setLine(ed.history[hist])
+				}
+			case 'B':
+				if hist < len(ed.history) {
+					hist++
+					if hist == len(ed.history) {
+						setLine("")
+					} else {
+						setLine(ed.history[hist])
+					}
+				}
+			case 'C':
+				if pos < len(buf) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(buf)
+			case '3':
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r < ' ' {
+				continue
+			}
+			buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+			pos++
+		}
+		redraw()
+	}
+}
+
+// escape 读取 ESC 之后的控制序列，返回方向键的字母；Delete 键（ESC [ 3 ~）返回 '3'
+func (ed *editor) escape() rune {
+	r, _, err := ed.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+	r, _, err = ed.in.ReadRune()
+	if err != nil {
+		return 0
+	}
+	if r >= '0' && r <= '9' {
+		// 读到序列结尾的 ~
+		for {
+			c, _, err := ed.in.ReadRune()
+			if err != nil || c == '~' {
+				break
+			}
+		}
+	}
+	return r
+}
+
+// tab 补全光标前的单词：唯一候选直接补全，多个候选补全公共前缀，没有可补全的部分时列出候选
+func (ed *editor) tab(buf *[]rune, pos *int) {
+	before := string((*buf)[:*pos])
+	start, candidates := ed.complete(before)
+	if len(candidates) == 0 {
+		return
+	}
+	word := before[start:]
+	insert := commonPrefix(candidates)[len(word):]
+	if len(candidates) == 1 {
+		insert += " "
+	}
+	if insert == "" {
+		fmt.Fprintf(ed.out, "\n%s\n", strings.Join(candidates, "  "))
+		return
+	}
+	ins := []rune(insert)
+	rest := append([]rune{}, (*buf)[*pos:]...)
+	*buf = append(append((*buf)[:*pos], ins...), rest...)
+	*pos += len(ins)
+}
+
+func commonPrefix(list []string) string {
+	prefix := list[0]
+	for _, s := range list[1:] {
+		for !strings.HasPrefix(s, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
@@ -0,0 +1,102 @@
+// mkctl 通过管理接口查看和控制运行中的内核
+//
+//	mkctl [-addr unix:./admin.sock] [-token TOKEN] [-o table|json] [命令] [参数]
+//
+//	services                           列出服务、状态、类型和版本
+//	status      SERVICE                服务详情和指标
+//	start|stop|restart SERVICE         启动、停止或重启服务
+//	replace     [-type T] SERVICE [KEY=VALUE ...]
+//	                                   修改服务的类型或参数并热替换
+//	send        [-stream] SERVICE TYPE [CONTENT ...]
+//	                                   发送事件并输出回复
+//	graph       [-format tree|dot|mermaid]
+//	                                   服务依赖图，可导出为 Graphviz DOT 或 Mermaid
+//	impact      SERVICE                停止服务时受影响的服务
+//	state       dump SERVICE | save [SERVICE] | snapshot FILE
+//	                                   查看或保存状态、下载加密快照
+//	logs        [-f] [-n N] [-level L] [-service S] [TEXT]
+//	                                   查询或跟踪日志服务存储的日志
+//	deadletters [clear | redeliver ID] 死信
+//	reload                             重载配置文件
+//	metrics                            Prometheus 文本格式的指标
+//
+// 不带命令时进入交互模式，Tab 补全命令、服务名称和事件类型。
+// token 依次取 -token、环境变量 MICROKERNEL_ADMIN_TOKEN 和 -token-file 文件。
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+)
+
+func main() {
+	addr := flag.String("addr", "unix:./admin.sock", "admin API address: unix:/path or host:port")
+	token := flag.String("token", "", "admin token")
+	tokenFile := flag.String("token-file", "./admin.token", "file containing the admin token")
+	output := flag.String("o", "table", "output format: table or json")
+	loggerName := flag.String("logger", "logger", "name of the log service")
+	flag.Usage = usage
+	flag.Parse()
+	if *output != "table" && *output != "json" {
+		usage()
+		os.Exit(2)
+	}
+
+	tok, err := resolveToken(*token, *tokenFile)
+	if err != nil {
+		fatal(err)
+	}
+	e := &env{
+		client: newClient(*addr, tok),
+		out:    os.Stdout,
+		json:   *output == "json",
+		logger: *loggerName,
+	}
+	if flag.NArg() == 0 {
+		if err := repl(e); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	// Ctrl-C 结束 logs -f 等长时间运行的命令
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := runCommand(ctx, e, flag.Args()); err != nil && ctx.Err() == nil {
+		fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: mkctl [-addr ADDR] [-token TOKEN] [-o table|json] [command [args]]")
+	flag.PrintDefaults()
+	fmt.Fprintln(os.Stderr, "\ncommands:")
+	e := &env{out: os.Stderr}
+	cmdHelp(context.Background(), e, nil)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "mkctl:", err)
+	os.Exit(1)
+}
+
+func resolveToken(token, file string) (string, error) {
+	if token != "" {
+		return token, nil
+	}
+	if token := os.Getenv("MICROKERNEL_ADMIN_TOKEN"); token != "" {
+		return token, nil
+	}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("no admin token: use -token, MICROKERNEL_ADMIN_TOKEN or %s", file)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
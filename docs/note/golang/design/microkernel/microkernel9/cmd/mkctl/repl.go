@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+const prompt = "mkctl> "
+
+// replCommands 只在交互模式中可用的命令
+var replCommands = []string{"output", "exit", "quit"}
+
+// valueFlags 带值的子命令参数，补全时跳过参数值
+var valueFlags = map[string]bool{"-type": true, "-timeout": true, "-from": true, "-n": true, "-level": true, "-service": true, "-format": true}
+
+// lineReader 读取一行命令
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// repl 交互模式：终端支持时使用带补全和历史的行编辑器，否则逐行读取标准输入
+func repl(e *env) error {
+	var r lineReader
+	ed := newEditor(os.Stdin, os.Stdout, e.completeLine)
+	if ed.supported() {
+		r = ed
+		fmt.Fprintln(e.out, "mkctl interactive mode, Tab completes, help lists commands, Ctrl-D exits")
+	} else {
+		r = &plainReader{sc: bufio.NewScanner(os.Stdin)}
+	}
+	for {
+		line, err := r.readLine(prompt)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		switch args[0] {
+		case "exit", "quit":
+			return nil
+		case "output":
+			if len(args) != 2 || (args[1] != "json" && args[1] != "table") {
+				fmt.Fprintln(os.Stderr, "usage: output json|table")
+				continue
+			}
+			e.json = args[1] == "json"
+			continue
+		}
+		// 命令执行期间 Ctrl-C 只中断当前命令
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		err = runCommand(ctx, e, args)
+		interrupted := ctx.Err() != nil
+		stop()
+		if err != nil && !interrupted {
+			fmt.Fprintln(os.Stderr, "error:", err)
+		}
+	}
+}
+
+// plainReader 标准输入不是终端时逐行读取，提示符输出到标准错误，不影响脚本捕获输出
+type plainReader struct {
+	sc *bufio.Scanner
+}
+
+func (p *plainReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if !p.sc.Scan() {
+		if err := p.sc.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+	return p.sc.Text(), nil
+}
+
+// completeLine 补全光标前的输入，返回当前单词的起始位置和候选
+func (e *env) completeLine(line string) (int, []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := strings.LastIndexAny(line, " \t") + 1
+	word := line[start:]
+	words := strings.Fields(line[:start])
+
+	var candidates []string
+	switch {
+	case len(words) == 0:
+		candidates = append(commandNames(), replCommands...)
+	case words[0] == "output":
+		if len(words) == 1 {
+			candidates = []string{"json", "table"}
+		}
+	case strings.HasPrefix(word, "-"):
+		// 不补全参数名
+	case valueFlags[words[len(words)-1]]:
+		switch words[len(words)-1] {
+		case "-level":
+			candidates = logLevelNames
+		case "-service":
+			candidates = serviceNames(ctx, e)
+		case "-format":
+			candidates = []string{"tree", "dot", "mermaid"}
+		}
+	default:
+		c, ok := lookupCommand(words[0])
+		if ok && c.complete != nil {
+			candidates = c.complete(ctx, e, positional(words[1:]))
+		}
+	}
+	var matched []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, word) {
+			matched = append(matched, c)
+		}
+	}
+	return start, matched
+}
+
+// positional 去掉参数及其值，返回位置参数
+func positional(words []string) []string {
+	var out []string
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if !strings.HasPrefix(w, "-") {
+			out = append(out, w)
+			continue
+		}
+		if valueFlags[w] {
+			i++
+		}
+	}
+	return out
+}
+
+// splitArgs 按空白拆分命令行，支持单引号、双引号和反斜杠转义
+func splitArgs(line string) ([]string, error) {
+	var (
+		args    []string
+		cur     strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range line {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unterminated quote")
+	}
+	if inWord {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// makeRaw 其他平台不支持原始模式，交互模式逐行读取，没有补全
+func makeRaw(fd int) (func() error, error) {
+	return nil, errors.New("raw terminal mode is only supported on linux")
+}
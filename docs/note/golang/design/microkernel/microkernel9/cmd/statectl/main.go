@@ -0,0 +1,466 @@
+// statectl 离线检查和修复状态目录
+//
+//	statectl [-config ./kernel.toml] [-dir DIR] [-key SPEC] <命令> [参数]
+//
+//	ls                              列出状态文件、代、格式、版本和密钥 ID
+//	show   [-gen N] NAME            解密并打印状态
+//	verify                          校验所有状态文件，有失败时退出码为 1
+//	export [-gen N] [-o FILE] NAME  导出为明文 JSON，可编辑后重新导入
+//	import [-raw -version N] NAME FILE
+//	                                重新加密导入（作为新的一代保存，旧状态保留为 .state.1）
+//	diff   NAME [GEN_A GEN_B]       比较两代状态，默认比较 1 和 0
+//	migrate                         把旧格式的状态文件重新加密为绑定服务和存储的格式，并开启严格模式
+//	pubkey                          以 [key] source 或 -key 为 X25519 私钥，输出 base64 公钥，用于只写节点的 [key] public_key
+//
+// 状态目录和密钥按内核的配置文件打开（[store] 和 [key]，包括密钥环和信封加密），
+// 配置文件不存在时与内核的默认值相同（./state，MICROKERNEL_KEY 或 ./state.key）。
+// -dir 覆盖 store.path，-key 改用单个 AES 密钥，格式见 microkernel.ParseKeySource。
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"microkernel/microkernel"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+func main() {
+	os.Exit(run())
+}
+
+// errUsage 参数错误，用法已经打印，退出码为 2
+var errUsage = errors.New("usage")
+
+// run 执行命令并返回退出码，退出前执行全部 defer，内存中的密钥一定会被清除
+func run() int {
+	configFile := flag.String("config", "./kernel.toml", "kernel config file")
+	dir := flag.String("dir", "", "state directory, overrides store.path")
+	keySpec := flag.String("key", "", "key source, overrides [key] (env:NAME, file:PATH, passphrase:ENV,salt=PATH, agent:SOCK#NAME)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() == 0 {
+		usage()
+		return 2
+	}
+
+	cmd, args := flag.Arg(0), flag.Args()[1:]
+	cfg, err := loadConfig(*configFile, *dir, *keySpec)
+	if err != nil {
+		return fail(err)
+	}
+	if cmd == "pubkey" {
+		return fail(cmdPubkey(cfg.Key.Source))
+	}
+	rt, err := cfg.OpenStore()
+	if err != nil {
+		return fail(err)
+	}
+	defer rt.Close()
+	store, ok := rt.Store.(*microkernel.FileStateStore)
+	if !ok {
+		return fail(fmt.Errorf("%s: statectl works on the file backend, not %s", cfg.File, cfg.Store.Backend))
+	}
+
+	switch cmd {
+	case "ls":
+		err = cmdList(store)
+	case "show":
+		err = cmdShow(store, args)
+	case "verify":
+		err = cmdVerify(store)
+	case "export":
+		err = cmdExport(store, args)
+	case "import":
+		err = cmdImport(store, args)
+	case "diff":
+		var changed bool
+		changed, err = cmdDiff(store, args)
+		if err == nil && changed {
+			// 与 diff(1) 相同，有差异时退出码为 1
+			return 1
+		}
+	case "migrate":
+		err = cmdMigrate(store)
+	default:
+		usage()
+		return 2
+	}
+	return fail(err)
+}
+
+// loadConfig 读取内核配置中的 [store] 和 [key]，应用命令行的覆盖
+// 不校验服务声明的类型（statectl 不链接服务实现），密钥在覆盖之后打开存储时才读取
+func loadConfig(path, dir, keySpec string) (*microkernel.Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !flagSet("config") {
+		data, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := microkernel.ParseConfigWith(path, data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if dir != "" {
+		cfg.Store.Path = dir
+	}
+	if keySpec != "" {
+		cfg.Key = microkernel.KeyConfig{Mode: microkernel.KeyAES, Source: keySpec}
+	}
+	return cfg, nil
+}
+
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) { set = set || f.Name == name })
+	return set
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: statectl [-config FILE] [-dir DIR] [-key SPEC] ls|show|verify|export|import|diff|migrate|pubkey [args]")
+	flag.PrintDefaults()
+}
+
+// fail 打印错误并返回退出码，err 为 nil 时返回 0
+func fail(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage):
+		return 2
+	}
+	fmt.Fprintln(os.Stderr, "statectl:", err)
+	return 1
+}
+
+func cmdList(store *microkernel.FileStateStore) error {
+	names, err := store.List()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tGEN\tFORMAT\tVERSION\tKEY\tSIZE\tMODIFIED")
+	for _, name := range names {
+		for _, gen := range store.Generations(name) {
+			info, err := store.Stat(name, gen)
+			if err != nil {
+				fmt.Fprintf(w, "%s\t%d\terror: %v\n", name, gen, err)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\t%d\t%s\n", name, gen, info.Format, info.Version,
+				orDash(info.KeyID), info.Size, info.ModTime.Format("2006-01-02 15:04:05"))
+		}
+	}
+	return w.Flush()
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func cmdShow(store *microkernel.FileStateStore, args []string) error {
+	fs := flag.NewFlagSet("show", flag.ContinueOnError)
+	gen := fs.Int("gen", 0, "generation")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: statectl show [-gen N] NAME")
+	}
+	out, err := render(store, fs.Arg(0), *gen)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
+
+func cmdVerify(store *microkernel.FileStateStore) error {
+	names, err := store.List()
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, name := range names {
+		for _, gen := range store.Generations(name) {
+			if err := verify(store, name, gen); err != nil {
+				failed++
+				fmt.Printf("FAIL %s generation %d: %v\n", name, gen, err)
+				continue
+			}
+			fmt.Printf("OK   %s generation %d\n", name, gen)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d state file(s) failed verification", failed)
+	}
+	return nil
+}
+
+// verify 完整解密一个状态文件，流式状态读到最后一块
+func verify(store *microkernel.FileStateStore, name string, gen int) error {
+	_, err := store.LoadGeneration(name, gen)
+	if !errors.Is(err, microkernel.ErrNotEnvelopeState) {
+		return err
+	}
+	r, _, err := store.LoadStreamGeneration(name, gen)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(io.Discard, r)
+	return err
+}
+
+// exportDoc 明文导出格式，JSON 编码的状态展开为 state 字段便于编辑，其他编码保留为 base64 的 data
+type exportDoc struct {
+	Service  string          `json:"service"`
+	Version  int             `json:"version"`
+	Encoding string          `json:"encoding"`
+	State    json.RawMessage `json:"state,omitempty"`
+	Data     []byte          `json:"data,omitempty"`
+}
+
+func load(store *microkernel.FileStateStore, name string, gen int) (*exportDoc, error) {
+	raw, err := store.LoadGeneration(name, gen)
+	if errors.Is(err, microkernel.ErrNotEnvelopeState) {
+		r, version, err := store.LoadStreamGeneration(name, gen)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return &exportDoc{Service: name, Version: version, Encoding: microkernel.EncodingStream, Data: data}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	env, err := microkernel.DecodeEnvelope(name, raw)
+	if err != nil {
+		return nil, err
+	}
+	doc := &exportDoc{Service: env.Service, Version: env.Version, Encoding: env.Encoding}
+	if env.Encoding == microkernel.EncodingJSON && json.Valid(env.Data) {
+		doc.State = env.Data
+	} else {
+		doc.Data = env.Data
+	}
+	return doc, nil
+}
+
+// render 以缩进 JSON 输出一代状态
+func render(store *microkernel.FileStateStore, name string, gen int) ([]byte, error) {
+	doc, err := load(store, name, gen)
+	if err != nil {
+		return nil, err
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func cmdExport(store *microkernel.FileStateStore, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	gen := fs.Int("gen", 0, "generation")
+	output := fs.String("o", "", "output file (default stdout)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: statectl export [-gen N] [-o FILE] NAME")
+	}
+	out, err := render(store, fs.Arg(0), *gen)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		_, err = os.Stdout.Write(out)
+		return err
+	}
+	// 导出的是明文，只允许属主读取
+	return os.WriteFile(*output, out, 0600)
+}
+
+func cmdImport(store *microkernel.FileStateStore, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	raw := fs.Bool("raw", false, "import FILE as raw bytes of a streamed state")
+	version := fs.Int("version", 1, "schema version for -raw")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 2 {
+		return errors.New("usage: statectl import [-raw -version N] NAME FILE")
+	}
+	name, file := fs.Arg(0), fs.Arg(1)
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if *raw {
+		return store.SaveStream(name, *version, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+	}
+	var doc exportDoc
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	if doc.Service != name {
+		return fmt.Errorf("%s contains state of %s, not %s", file, doc.Service, name)
+	}
+	if doc.Encoding == microkernel.EncodingStream {
+		return store.SaveStream(name, doc.Version, func(w io.Writer) error {
+			_, err := w.Write(doc.Data)
+			return err
+		})
+	}
+	env := &microkernel.StateEnvelope{Service: doc.Service, Version: doc.Version, Encoding: doc.Encoding, Data: doc.Data}
+	if doc.State != nil {
+		var buf bytes.Buffer
+		if err := json.Compact(&buf, doc.State); err != nil {
+			return err
+		}
+		env.Data = buf.Bytes()
+	}
+	if err := store.Save(name, env); err != nil {
+		return err
+	}
+	fmt.Printf("Imported %s (version %d, %d bytes)\n", name, env.Version, len(env.Data))
+	return nil
+}
+
+// cmdDiff 逐行比较两代状态，返回是否有差异
+func cmdDiff(store *microkernel.FileStateStore, args []string) (bool, error) {
+	if len(args) != 1 && len(args) != 3 {
+		return false, errors.New("usage: statectl diff NAME [GEN_A GEN_B]")
+	}
+	genA, genB := 1, 0
+	if len(args) == 3 {
+		var err error
+		if genA, err = strconv.Atoi(args[1]); err != nil {
+			return false, err
+		}
+		if genB, err = strconv.Atoi(args[2]); err != nil {
+			return false, err
+		}
+	}
+	a, err := render(store, args[0], genA)
+	if err != nil {
+		return false, fmt.Errorf("generation %d: %w", genA, err)
+	}
+	b, err := render(store, args[0], genB)
+	if err != nil {
+		return false, fmt.Errorf("generation %d: %w", genB, err)
+	}
+	fmt.Printf("--- %s generation %d\n+++ %s generation %d\n", args[0], genA, args[0], genB)
+	if !printDiff(lines(a), lines(b)) {
+		fmt.Println("(no differences)")
+		return false, nil
+	}
+	return true, nil
+}
+
+// cmdMigrate 使用 Rotate 重写全部状态文件，成功后存储只接受绑定了附加认证数据的密文
+func cmdMigrate(store *microkernel.FileStateStore) error {
+	r, err := store.Rotate(context.Background())
+	if err != nil {
+		return err
+	}
+	for p := range r.Progress() {
+		status := "rewritten"
+		switch {
+		case p.Err != nil:
+			status = "FAIL " + p.Err.Error()
+		case p.Skipped:
+			status = "up to date"
+		}
+		fmt.Printf("[%d/%d] %s generation %d: %s\n", p.Done, p.Total, p.Service, p.Generation, status)
+	}
+	if err := r.Wait(); err != nil {
+		return err
+	}
+	fmt.Println("strict mode enabled")
+	return nil
+}
+
+// cmdPubkey 输出 X25519 私钥对应的公钥，私钥来源为 [key] source 或 -key
+func cmdPubkey(spec string) error {
+	if spec == "" {
+		return errors.New("pubkey needs a key source: set [key] source or -key")
+	}
+	provider, err := microkernel.ParseKeySource(spec)
+	if err != nil {
+		return err
+	}
+	key, err := provider.Key()
+	if err != nil {
+		return err
+	}
+	defer microkernel.Zero(key)
+	priv, err := ecdh.X25519().NewPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(priv.PublicKey().Bytes()))
+	return nil
+}
+
+func lines(b []byte) []string {
+	return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+}
+
+// printDiff 基于最长公共子序列的逐行比较，返回是否有差异
+func printDiff(a, b []string) bool {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	changed := false
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Println(" ", a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Println("-", a[i])
+			i++
+			changed = true
+		default:
+			fmt.Println("+", b[j])
+			j++
+			changed = true
+		}
+	}
+	return changed
+}
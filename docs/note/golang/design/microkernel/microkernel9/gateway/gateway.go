@@ -0,0 +1,280 @@
+package gateway
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"microkernel/microkernel"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Route 对外暴露一个服务及其访问规则
+type Route struct {
+	// 服务名称
+	Service string
+	// 允许的事件类型，为空表示全部允许
+	Types []string
+	// 允许的 Bearer token，为空表示不校验
+	Tokens []string
+	// 允许访问的来源网段（CIDR），为空表示不限制
+	AllowCIDRs []string
+	// 是否允许流式回复
+	Stream bool
+	// 单次调用超时，0 表示使用默认值
+	Timeout time.Duration
+	// 请求体的最大字节数，超过时返回 413，0 表示使用默认值
+	MaxBody int64
+}
+
+type route struct {
+	Route
+	nets []*net.IPNet
+}
+
+// DefaultTimeout 路由未设置超时时的默认超时
+const DefaultTimeout = 5 * time.Second
+
+// DefaultMaxBody 路由未设置请求体上限时的默认上限
+const DefaultMaxBody = 1 << 20
+
+// Gateway HTTP 网关，把请求映射为内核事件
+//
+//	POST /services/{name}/{type}
+//
+// 请求体作为 Event.Content，调用结果以 JSON 返回，Reply.Code 映射为 HTTP 状态码。
+// 请求头 Accept: text/event-stream 时以 SSE 返回流式回复，
+// 查询参数 stream=1 时以分块传输的 NDJSON 返回流式回复
+type Gateway struct {
+	kernel *microkernel.MicroKernel
+	routes map[string]*route
+	mux    *http.ServeMux
+}
+
+// New 创建网关，只有配置了路由的服务才会对外暴露
+func New(kernel *microkernel.MicroKernel, routes ...Route) (*Gateway, error) {
+	g := &Gateway{
+		kernel: kernel,
+		routes: make(map[string]*route),
+		mux:    http.NewServeMux(),
+	}
+	for _, r := range routes {
+		if r.Service == "" {
+			return nil, fmt.Errorf("gateway: route without service")
+		}
+		if _, ok := g.routes[r.Service]; ok {
+			return nil, fmt.Errorf("gateway: duplicate route for %s", r.Service)
+		}
+		rt := &route{Route: r}
+		for _, cidr := range r.AllowCIDRs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("gateway: route %s: %w", r.Service, err)
+			}
+			rt.nets = append(rt.nets, n)
+		}
+		if rt.Timeout == 0 {
+			rt.Timeout = DefaultTimeout
+		}
+		if rt.MaxBody == 0 {
+			rt.MaxBody = DefaultMaxBody
+		}
+		g.routes[r.Service] = rt
+	}
+	g.mux.HandleFunc("POST /services/{name}/{type}", g.handle)
+	return g, nil
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// StatusFromCode 把 Reply.Code 转换为 HTTP 状态码
+//   - 0 为成功
+//   - 408（服务处理超时）对网关来说是上游超时，返回 504
+//   - 其他合法的 4xx/5xx 直接使用
+//   - 其余非 0 错误码统一返回 500
+func StatusFromCode(code int) int {
+	switch {
+	case code == 0:
+		return http.StatusOK
+	case code == 408:
+		return http.StatusGatewayTimeout
+	case code >= 400 && code <= 599:
+		return code
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+type replyBody struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data"`
+}
+
+func (g *Gateway) handle(w http.ResponseWriter, r *http.Request) {
+	name, typ := r.PathValue("name"), r.PathValue("type")
+	rt, ok := g.routes[name]
+	if !ok {
+		writeReply(w, http.StatusNotFound, microkernel.Reply{Code: 404, Message: "service not exposed"})
+		return
+	}
+	if status, msg := rt.authorize(r, typ); status != http.StatusOK {
+		writeReply(w, status, microkernel.Reply{Code: status, Message: msg})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, rt.MaxBody))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.As(err, new(*http.MaxBytesError)) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		writeReply(w, status, microkernel.Reply{Code: status, Message: err.Error()})
+		return
+	}
+	evt := microkernel.Event{
+		From:      "gateway",
+		To:        name,
+		Type:      typ,
+		Content:   string(body),
+		TimeoutMs: int(rt.Timeout / time.Millisecond),
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), rt.Timeout)
+	defer cancel()
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse || r.URL.Query().Get("stream") == "1" {
+		if !rt.Stream {
+			writeReply(w, http.StatusNotAcceptable, microkernel.Reply{Code: 406, Message: "streaming not allowed"})
+			return
+		}
+		g.stream(ctx, w, evt, sse)
+		return
+	}
+
+	// 通过内核事件总线调用服务
+	replyCh := make(chan microkernel.Reply, 1)
+	evt.ReplyCh = replyCh
+	if err := g.kernel.PushContext(ctx, evt); err != nil {
+		// 队列已满，等到超时或客户端断开
+		writeReply(w, http.StatusGatewayTimeout, microkernel.Reply{Code: 408, Message: "timeout"})
+		return
+	}
+	select {
+	case reply := <-replyCh:
+		writeReply(w, StatusFromCode(reply.Code), reply)
+	case <-ctx.Done():
+		writeReply(w, http.StatusGatewayTimeout, microkernel.Reply{Code: 408, Message: "timeout"})
+	}
+}
+
+// stream 以 SSE 或分块 NDJSON 输出流式回复
+// 响应头发出后无法再修改状态码，错误回复以 error 事件/带错误码的记录输出
+func (g *Gateway) stream(ctx context.Context, w http.ResponseWriter, evt microkernel.Event, sse bool) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeReply(w, http.StatusInternalServerError, microkernel.Reply{Code: 500, Message: "streaming unsupported"})
+		return
+	}
+	replies, err := g.kernel.Stream(ctx, evt)
+	if err != nil {
+		writeReply(w, http.StatusNotFound, microkernel.Reply{Code: 404, Message: err.Error()})
+		return
+	}
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for reply := range replies {
+		data, _ := json.Marshal(toBody(reply))
+		if sse {
+			event := "message"
+			if reply.Code != 0 {
+				event = "error"
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+		} else {
+			fmt.Fprintf(w, "%s\n", data)
+		}
+		flusher.Flush()
+	}
+	if sse {
+		fmt.Fprint(w, "event: end\ndata: {}\n\n")
+		flusher.Flush()
+	}
+}
+
+// authorize 按路由规则校验请求，返回 HTTP 状态码和错误信息
+func (rt *route) authorize(r *http.Request, typ string) (int, string) {
+	if len(rt.nets) > 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		allowed := false
+		for _, n := range rt.nets {
+			if ip != nil && n.Contains(ip) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return http.StatusForbidden, "source address not allowed"
+		}
+	}
+	if len(rt.Tokens) > 0 {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !containsToken(rt.Tokens, token) {
+			return http.StatusUnauthorized, "invalid token"
+		}
+	}
+	if len(rt.Types) > 0 && !contains(rt.Types, typ) {
+		return http.StatusForbidden, "event type not allowed"
+	}
+	return http.StatusOK, ""
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// containsToken 使用常量时间比较，避免通过时间差猜测 token
+func containsToken(tokens []string, token string) bool {
+	found := false
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+func toBody(reply microkernel.Reply) replyBody {
+	return replyBody{Code: reply.Code, Message: reply.Message, Data: reply.Data}
+}
+
+func writeReply(w http.ResponseWriter, status int, reply microkernel.Reply) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(toBody(reply))
+}
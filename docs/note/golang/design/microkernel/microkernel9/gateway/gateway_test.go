@@ -0,0 +1,47 @@
+package gateway
+
+import (
+	"microkernel/microkernel"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyLimit(t *testing.T) {
+	k := microkernel.NewMicroKernel(microkernel.NewMemoryStateStore())
+	g, err := New(k, Route{Service: "echo", MaxBody: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, httptest.NewRequest("POST", "/services/echo/ping", strings.NewReader("0123456789")))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want 413", w.Code)
+	}
+}
+
+func TestQueueFullTimesOut(t *testing.T) {
+	// 没有启动 Listen，队列放满后请求只能等到超时
+	k := microkernel.NewMicroKernelWithQueue(microkernel.NewMemoryStateStore(), 1)
+	k.Push(microkernel.Event{To: "echo"})
+	g, err := New(k, Route{Service: "echo", Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		g.ServeHTTP(w, httptest.NewRequest("POST", "/services/echo/ping", strings.NewReader("hi")))
+		done <- w.Code
+	}()
+	select {
+	case code := <-done:
+		if code != http.StatusGatewayTimeout {
+			t.Fatalf("status = %d, want 504", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request blocked on a full queue")
+	}
+}
@@ -0,0 +1,163 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Encoder 把一条日志编码为一行（含换行符）
+type Encoder interface {
+	Encode(buf *bytes.Buffer, r Record)
+}
+
+// ParseEncoder 按名称（text、json、logfmt）选择编码器
+func ParseEncoder(name string) (Encoder, error) {
+	switch strings.ToLower(name) {
+	case "", "text":
+		return TextEncoder{}, nil
+	case "json":
+		return JSONEncoder{}, nil
+	case "logfmt":
+		return LogfmtEncoder{}, nil
+	}
+	return nil, fmt.Errorf("unknown log encoder %q, want text, json or logfmt", name)
+}
+
+const textTimeFormat = "2006-01-02 15:04:05.000"
+
+// TextEncoder 原来的文本格式，字段以 key=value 追加在消息后
+//
+//	[2006-01-02 15:04:05.000] [INFO] [kernel] message key=value
+type TextEncoder struct{}
+
+func (TextEncoder) Encode(buf *bytes.Buffer, r Record) {
+	fmt.Fprintf(buf, "[%s] [%s] [%s] %s", r.Time.Format(textTimeFormat), r.Level, r.Service, r.Msg)
+	for _, f := range r.Fields {
+		buf.WriteByte(' ')
+		buf.WriteString(f.Key)
+		buf.WriteByte('=')
+		writeLogfmtValue(buf, formatValue(f.Value))
+	}
+	buf.WriteByte('\n')
+}
+
+// JSONEncoder 每行一个 JSON 对象，字段与 time、level、service、msg 同级
+type JSONEncoder struct{}
+
+func (JSONEncoder) Encode(buf *bytes.Buffer, r Record) {
+	buf.WriteString(`{"time":`)
+	writeJSON(buf, r.Time.Format(time.RFC3339Nano))
+	buf.WriteString(`,"level":`)
+	writeJSON(buf, r.Level.String())
+	buf.WriteString(`,"service":`)
+	writeJSON(buf, r.Service)
+	buf.WriteString(`,"msg":`)
+	writeJSON(buf, r.Msg)
+	for _, f := range r.Fields {
+		buf.WriteByte(',')
+		writeJSON(buf, f.Key)
+		buf.WriteByte(':')
+		writeJSON(buf, jsonValue(f.Value))
+	}
+	buf.WriteString("}\n")
+}
+
+// LogfmtEncoder logfmt 格式
+//
+//	time=2006-01-02T15:04:05.000Z level=info service=kernel msg="message" key=value
+type LogfmtEncoder struct{}
+
+func (LogfmtEncoder) Encode(buf *bytes.Buffer, r Record) {
+	buf.WriteString("time=")
+	buf.WriteString(r.Time.Format(time.RFC3339Nano))
+	buf.WriteString(" level=")
+	buf.WriteString(strings.ToLower(r.Level.String()))
+	buf.WriteString(" service=")
+	writeLogfmtValue(buf, r.Service)
+	buf.WriteString(" msg=")
+	writeLogfmtValue(buf, r.Msg)
+	for _, f := range r.Fields {
+		buf.WriteByte(' ')
+		buf.WriteString(logfmtKey(f.Key))
+		buf.WriteByte('=')
+		writeLogfmtValue(buf, formatValue(f.Value))
+	}
+	buf.WriteByte('\n')
+}
+
+// formatValue 字段值的文本形式
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "<nil>"
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case fmt.Stringer:
+		return v.String()
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// jsonValue 字段值的 JSON 形式：error、Stringer 等转换为字符串，其余按 encoding/json 编码
+func jsonValue(v any) any {
+	switch v := v.(type) {
+	case nil, string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	case error, time.Duration, fmt.Stringer:
+		return formatValue(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+func writeJSON(buf *bytes.Buffer, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		// 无法编码的值记录为文本
+		data, _ = json.Marshal(fmt.Sprintf("!ERROR %v: %v", err, v))
+	}
+	buf.Write(data)
+}
+
+// writeLogfmtValue 含空格、等号、引号或控制字符的值加引号
+func writeLogfmtValue(buf *bytes.Buffer, s string) {
+	if s != "" && !needsQuote(s) {
+		buf.WriteString(s)
+		return
+	}
+	buf.WriteString(strconv.Quote(s))
+}
+
+func needsQuote(s string) bool {
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// logfmtKey 键中的空格、等号和引号替换为下划线
+func logfmtKey(k string) string {
+	if k == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, k)
+}
@@ -3,46 +3,248 @@ package logger
 import (
 	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"time"
 )
 
 type LogLevel int
 
+// 数值与 slog 的级别对齐：INFO 为 0，级别越低输出越详细
 const (
-	INFO LogLevel = iota
+	TRACE LogLevel = iota - 2
+	DEBUG
+	INFO
 	WARN
 	ERROR
 )
 
+func (l LogLevel) String() string {
+	switch {
+	case l <= TRACE:
+		return "TRACE"
+	case l >= ERROR:
+		return "ERROR"
+	}
+	return [...]string{"DEBUG", "INFO", "WARN"}[l-DEBUG]
+}
+
+// ParseLevel 解析级别名称，不区分大小写
+func ParseLevel(s string) (LogLevel, error) {
+	for l := TRACE; l <= ERROR; l++ {
+		if strings.EqualFold(s, l.String()) {
+			return l, nil
+		}
+	}
+	return INFO, fmt.Errorf("unknown log level %q", s)
+}
+
+// MarshalText 级别以名称编码，用于 JSON 等文本格式
+func (l LogLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText 按 ParseLevel 解析级别名称
+func (l *LogLevel) UnmarshalText(text []byte) error {
+	level, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// Levels 按服务设置的日志级别，运行中可以修改，同一管道的日志器共享
+type Levels struct {
+	mu       sync.RWMutex
+	def      LogLevel
+	services map[string]LogLevel
+}
+
+// NewLevels 创建级别表，未单独设置的服务使用 def
+func NewLevels(def LogLevel) *Levels {
+	return &Levels{def: def, services: make(map[string]LogLevel)}
+}
+
+// Level 服务当前的级别
+func (lv *Levels) Level(service string) LogLevel {
+	lv.mu.RLock()
+	defer lv.mu.RUnlock()
+	if l, ok := lv.services[service]; ok {
+		return l
+	}
+	return lv.def
+}
+
+// Set 设置服务的级别
+func (lv *Levels) Set(service string, level LogLevel) {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	lv.services[service] = level
+}
+
+// Reset 服务恢复为默认级别
+func (lv *Levels) Reset(service string) {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	delete(lv.services, service)
+}
+
+// SetDefault 设置默认级别
+func (lv *Levels) SetDefault(level LogLevel) {
+	lv.mu.Lock()
+	defer lv.mu.Unlock()
+	lv.def = level
+}
+
+// Default 默认级别
+func (lv *Levels) Default() LogLevel {
+	lv.mu.RLock()
+	defer lv.mu.RUnlock()
+	return lv.def
+}
+
+// Overrides 单独设置了级别的服务
+func (lv *Levels) Overrides() map[string]LogLevel {
+	lv.mu.RLock()
+	defer lv.mu.RUnlock()
+	m := make(map[string]LogLevel, len(lv.services))
+	for k, v := range lv.services {
+		m[k] = v
+	}
+	return m
+}
+
+// Field 结构化日志的一个键值对
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Record 一条日志
+type Record struct {
+	Time    time.Time
+	Level   LogLevel
+	Service string
+	Msg     string
+	Fields  []Field
+}
+
+// Options 日志器选项
+type Options struct {
+	// Levels 为 nil 时按 Level 创建
+	Level  LogLevel
+	Levels *Levels
+	// Sink 为 nil 时按 Encoder 写入 New 的 out，Encoder 为 nil 时使用 TextEncoder
+	Sink    Sink
+	Encoder Encoder
+}
+
+// pipeline 同一输出的日志器共享的输出和级别表
+type pipeline struct {
+	sink   Sink
+	levels *Levels
+}
+
 type Logger struct {
-	mu      sync.Mutex
-	level   LogLevel
+	p       *pipeline
 	service string
-	out     io.Writer
+	fields  []Field
 }
 
 // NewLogger 初步的日志实现，可以直接在该模块内部完成设置，其他模块调用唯一的logger对象
 func NewLogger(service string, level LogLevel, out io.Writer) *Logger {
-	return &Logger{
-		service: service,
-		level:   level,
-		out:     out,
+	return New(service, out, Options{Level: level})
+}
+
+// Discard 丢弃全部输出的日志器
+func Discard() *Logger {
+	return New("", io.Discard, Options{Level: ERROR + 1})
+}
+
+// New 按选项创建日志器，opts.Sink 不为 nil 时忽略 out
+func New(service string, out io.Writer, opts Options) *Logger {
+	p := &pipeline{sink: opts.Sink, levels: opts.Levels}
+	if p.sink == nil {
+		p.sink = NewWriterSink(out, opts.Encoder)
+	}
+	if p.levels == nil {
+		p.levels = NewLevels(opts.Level)
+	}
+	return &Logger{p: p, service: service}
+}
+
+// With 返回附加了字段的子日志器，kv 为交替的键和值
+func (l *Logger) With(kv ...any) *Logger {
+	return &Logger{p: l.p, service: l.service, fields: append(cloneFields(l.fields), toFields(kv)...)}
+}
+
+// Named 返回同一管道中另一个服务的日志器，不继承字段
+func (l *Logger) Named(service string) *Logger {
+	return &Logger{p: l.p, service: service}
+}
+
+// Service 日志器所属的服务
+func (l *Logger) Service() string {
+	return l.service
+}
+
+// Sink 管道的输出
+func (l *Logger) Sink() Sink {
+	return l.p.sink
+}
+
+// Levels 管道共享的级别表
+func (l *Logger) Levels() *Levels {
+	return l.p.levels
+}
+
+// SetLevel 设置本服务的级别
+func (l *Logger) SetLevel(level LogLevel) {
+	l.p.levels.Set(l.service, level)
+}
+
+// Enabled 本服务是否输出该级别
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level >= l.p.levels.Level(l.service)
+}
+
+// log 输出一条日志，调用方已检查级别
+func (l *Logger) log(level LogLevel, msg string, fields []Field) {
+	l.write(Record{Time: time.Now(), Level: level, Service: l.service, Msg: msg, Fields: fields})
+}
+
+func (l *Logger) write(r Record) {
+	// 日志写入失败无处报告，忽略
+	l.p.sink.WriteRecord(r)
+}
+
+func (l *Logger) logkv(level LogLevel, msg string, kv []any) {
+	if !l.Enabled(level) {
+		return
 	}
+	l.log(level, msg, append(cloneFields(l.fields), toFields(kv)...))
 }
 
 func (l *Logger) logf(level LogLevel, format string, args ...any) {
-	if level < l.level {
+	if !l.Enabled(level) {
 		return
 	}
-	l.mu.Lock()
-	defer l.mu.Unlock()
-	levelStr := [...]string{"INFO", "WARN", "ERROR"}[level]
-	msg := fmt.Sprintf(format, args...)
-	ts := time.Now().Format("2006-01-02 15:04:05.000")
-	fmt.Fprintf(l.out, "[%s] [%s] [%s] %s\n", ts, levelStr, l.service, msg)
+	l.log(level, fmt.Sprintf(format, args...), l.fields)
 }
 
+func (l *Logger) Trace(msg string, kv ...any) { l.logkv(TRACE, msg, kv) }
+func (l *Logger) Debug(msg string, kv ...any) { l.logkv(DEBUG, msg, kv) }
+func (l *Logger) Info(msg string, kv ...any)  { l.logkv(INFO, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...any)  { l.logkv(WARN, msg, kv) }
+func (l *Logger) Error(msg string, kv ...any) { l.logkv(ERROR, msg, kv) }
+
+func (l *Logger) Tracef(format string, args ...any) {
+	l.logf(TRACE, format, args...)
+}
+func (l *Logger) Debugf(format string, args ...any) {
+	l.logf(DEBUG, format, args...)
+}
 func (l *Logger) Infof(format string, args ...any) {
 	l.logf(INFO, format, args...)
 }
@@ -52,3 +254,31 @@ func (l *Logger) Warnf(format string, args ...any) {
 func (l *Logger) Errorf(format string, args ...any) {
 	l.logf(ERROR, format, args...)
 }
+
+// toFields 把交替的键和值转换为字段，与 slog 相同，缺少键的值记为 !BADKEY
+func toFields(kv []any) []Field {
+	fields := make([]Field, 0, len(kv)/2+1)
+	for len(kv) > 0 {
+		switch k := kv[0].(type) {
+		case Field:
+			fields = append(fields, k)
+			kv = kv[1:]
+		case string:
+			if len(kv) == 1 {
+				fields = append(fields, Field{Key: "!BADKEY", Value: k})
+				kv = nil
+				continue
+			}
+			fields = append(fields, Field{Key: k, Value: kv[1]})
+			kv = kv[2:]
+		default:
+			fields = append(fields, Field{Key: "!BADKEY", Value: k})
+			kv = kv[1:]
+		}
+	}
+	return fields
+}
+
+func cloneFields(fields []Field) []Field {
+	return append([]Field(nil), fields...)
+}
@@ -0,0 +1,308 @@
+package logger
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotateOptions 滚动文件的选项
+type RotateOptions struct {
+	// 文件超过该大小后滚动，0 表示不按大小滚动
+	MaxSize int64
+	// 按时间滚动的周期（按本地时间对齐，例如 24h 在零点滚动），0 表示不按时间滚动
+	Interval time.Duration
+	// 保留的旧文件个数，0 表示不限
+	MaxBackups int
+	// 旧文件的保留时间，0 表示不限
+	MaxAge time.Duration
+	// 用 gzip 压缩旧文件
+	Compress bool
+	// 为 nil 时使用 TextEncoder
+	Encoder Encoder
+}
+
+// backupTimeFormat 旧文件名中的时间，按字典序即按时间排序
+const backupTimeFormat = "20060102-150405.000"
+
+// RotatingFile 按大小和时间滚动的日志文件
+//
+// 当前文件为 path，滚动后重命名为 path.<时间>，压缩后为 path.<时间>.gz。
+// 压缩和清理在后台进行，Close 等待完成。
+type RotatingFile struct {
+	path string
+	opts RotateOptions
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	periodAt time.Time // 当前文件所属周期的开始时间
+	buf      bytes.Buffer
+	// 后台压缩和清理，bg 使其依次进行，避免清理正在压缩的文件
+	wg sync.WaitGroup
+	bg sync.Mutex
+}
+
+// NewRotatingFile 打开（追加）或创建日志文件
+func NewRotatingFile(path string, opts RotateOptions) (*RotatingFile, error) {
+	if opts.Encoder == nil {
+		opts.Encoder = TextEncoder{}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f := &RotatingFile{path: path, opts: opts}
+	if err := f.open(time.Now()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *RotatingFile) open(now time.Time) error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	// 已有文件按修改时间确定所属周期，进程重启后跨周期的文件会先滚动
+	f.periodAt = f.period(info.ModTime())
+	if info.Size() == 0 {
+		f.periodAt = f.period(now)
+	}
+	return nil
+}
+
+// period 时间所属周期的开始时间
+func (f *RotatingFile) period(t time.Time) time.Time {
+	if f.opts.Interval <= 0 {
+		return time.Time{}
+	}
+	// Truncate 按 UTC 零点对齐，加上时区偏移后按本地时间对齐
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(f.opts.Interval).Add(-shift)
+}
+
+func (f *RotatingFile) WriteRecord(r Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return errors.New("rotating file closed")
+	}
+	f.buf.Reset()
+	f.opts.Encoder.Encode(&f.buf, r)
+	now := time.Now()
+	if f.shouldRotate(now, int64(f.buf.Len())) {
+		if err := f.rotate(now); err != nil {
+			return err
+		}
+	}
+	n, err := f.file.Write(f.buf.Bytes())
+	f.size += int64(n)
+	return err
+}
+
+func (f *RotatingFile) shouldRotate(now time.Time, next int64) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.opts.MaxSize > 0 && f.size+next > f.opts.MaxSize {
+		return true
+	}
+	return f.opts.Interval > 0 && !f.period(now).Equal(f.periodAt)
+}
+
+// Rotate 立即滚动
+func (f *RotatingFile) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return errors.New("rotating file closed")
+	}
+	return f.rotate(time.Now())
+}
+
+// rotate 关闭当前文件并重命名，打开新文件，调用方持有 f.mu
+func (f *RotatingFile) rotate(now time.Time) error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+	backup := f.backupName(now)
+	if err := os.Rename(f.path, backup); err != nil {
+		return err
+	}
+	if err := f.open(now); err != nil {
+		return err
+	}
+	f.periodAt = f.period(now)
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.bg.Lock()
+		defer f.bg.Unlock()
+		if f.opts.Compress {
+			// 文件可能已被之前的清理删除
+			if err := compressFile(backup); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "logger: compress %s: %v\n", backup, err)
+			}
+		}
+		if err := f.prune(now); err != nil {
+			fmt.Fprintf(os.Stderr, "logger: prune %s: %v\n", f.path, err)
+		}
+	}()
+	return nil
+}
+
+// backupName 旧文件名，同一毫秒内多次滚动时追加序号
+func (f *RotatingFile) backupName(now time.Time) string {
+	base := f.path + "." + now.Format(backupTimeFormat)
+	name := base
+	for i := 1; ; i++ {
+		_, err1 := os.Stat(name)
+		_, err2 := os.Stat(name + ".gz")
+		if os.IsNotExist(err1) && os.IsNotExist(err2) {
+			return name
+		}
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// Backups 旧文件路径，最新的在前
+func (f *RotatingFile) Backups() ([]string, error) {
+	matches, err := filepath.Glob(f.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	type backup struct {
+		path  string
+		stamp string
+		seq   int
+	}
+	var found []backup
+	prefix := f.path + "."
+	for _, m := range matches {
+		// 跳过压缩中的临时文件
+		if strings.HasSuffix(m, ".tmp") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(m, prefix), ".gz")
+		if len(name) < len(backupTimeFormat) {
+			continue
+		}
+		stamp := name[:len(backupTimeFormat)]
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil {
+			continue
+		}
+		b := backup{path: m, stamp: stamp}
+		if rest := name[len(stamp):]; rest != "" {
+			if _, err := fmt.Sscanf(rest, "-%d", &b.seq); err != nil {
+				continue
+			}
+		}
+		found = append(found, b)
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].stamp != found[j].stamp {
+			return found[i].stamp > found[j].stamp
+		}
+		return found[i].seq > found[j].seq
+	})
+	backups := make([]string, len(found))
+	for i, b := range found {
+		backups[i] = b.path
+	}
+	return backups, nil
+}
+
+// prune 按个数和保留时间删除旧文件
+func (f *RotatingFile) prune(now time.Time) error {
+	if f.opts.MaxBackups <= 0 && f.opts.MaxAge <= 0 {
+		return nil
+	}
+	backups, err := f.Backups()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for i, b := range backups {
+		remove := f.opts.MaxBackups > 0 && i >= f.opts.MaxBackups
+		if !remove && f.opts.MaxAge > 0 {
+			if info, err := os.Stat(b); err == nil && now.Sub(info.ModTime()) > f.opts.MaxAge {
+				remove = true
+			}
+		}
+		if remove {
+			if err := os.Remove(b); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// compressFile 压缩为 path.gz 后删除原文件
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	zw.Name = filepath.Base(path)
+	zw.ModTime = info.ModTime()
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = dst.Close()
+	} else {
+		dst.Close()
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// 保留原文件的修改时间，按保留时间清理时使用
+	os.Chtimes(tmp, info.ModTime(), info.ModTime())
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Close 关闭文件并等待后台压缩和清理完成
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	var err error
+	if f.file != nil {
+		err = f.file.Close()
+		f.file = nil
+	}
+	f.mu.Unlock()
+	f.wg.Wait()
+	return err
+}
@@ -0,0 +1,197 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Sink 日志输出，可以同时被多个管道使用，实现需要并发安全
+type Sink interface {
+	WriteRecord(r Record) error
+	Close() error
+}
+
+// WriterSink 编码后写入 io.Writer
+type WriterSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	enc Encoder
+	buf bytes.Buffer
+}
+
+// NewWriterSink 创建写入 w 的输出，enc 为 nil 时使用 TextEncoder
+func NewWriterSink(w io.Writer, enc Encoder) *WriterSink {
+	if enc == nil {
+		enc = TextEncoder{}
+	}
+	return &WriterSink{w: w, enc: enc}
+}
+
+func (s *WriterSink) WriteRecord(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+	s.enc.Encode(&s.buf, r)
+	_, err := s.w.Write(s.buf.Bytes())
+	return err
+}
+
+// Close 不关闭 w，w 由调用方管理
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// FanOut 把日志分发到多个输出，每个输出有自己的最低级别
+//
+// 日志器的级别表先过滤，FanOut 再按输出过滤：服务级别为 INFO 时 DEBUG 日志不会到达任何输出。
+type FanOut struct {
+	mu    sync.RWMutex
+	sinks []*fanOutEntry
+}
+
+type fanOutEntry struct {
+	name  string
+	sink  Sink
+	level LogLevel
+}
+
+// NewFanOut 创建空的分发器
+func NewFanOut() *FanOut {
+	return &FanOut{}
+}
+
+// Add 添加输出，名称用于 SetLevel 和 Remove
+func (f *FanOut) Add(name string, sink Sink, level LogLevel) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range f.sinks {
+		if e.name == name {
+			return fmt.Errorf("sink %s already added", name)
+		}
+	}
+	f.sinks = append(f.sinks, &fanOutEntry{name: name, sink: sink, level: level})
+	return nil
+}
+
+// SetLevel 修改输出的最低级别
+func (f *FanOut) SetLevel(name string, level LogLevel) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, e := range f.sinks {
+		if e.name == name {
+			e.level = level
+			return nil
+		}
+	}
+	return fmt.Errorf("sink %s not found", name)
+}
+
+// Remove 移除并关闭输出
+func (f *FanOut) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, e := range f.sinks {
+		if e.name == name {
+			f.sinks = append(f.sinks[:i], f.sinks[i+1:]...)
+			return e.sink.Close()
+		}
+	}
+	return fmt.Errorf("sink %s not found", name)
+}
+
+// Levels 各输出的名称和最低级别
+func (f *FanOut) Levels() map[string]LogLevel {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	m := make(map[string]LogLevel, len(f.sinks))
+	for _, e := range f.sinks {
+		m[e.name] = e.level
+	}
+	return m
+}
+
+// WriteRecord 写入级别满足要求的所有输出，一个输出失败不影响其他输出
+func (f *FanOut) WriteRecord(r Record) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var errs []error
+	for _, e := range f.sinks {
+		if r.Level < e.level {
+			continue
+		}
+		if err := e.sink.WriteRecord(r); err != nil {
+			errs = append(errs, fmt.Errorf("sink %s: %w", e.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close 关闭所有输出
+func (f *FanOut) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var errs []error
+	for _, e := range f.sinks {
+		errs = append(errs, e.sink.Close())
+	}
+	f.sinks = nil
+	return errors.Join(errs...)
+}
+
+// RingBuffer 在内存中保留最近的日志
+type RingBuffer struct {
+	mu      sync.Mutex
+	records []Record
+	next    int
+	full    bool
+}
+
+// NewRingBuffer 创建保留最近 size 条日志的缓冲区
+func NewRingBuffer(size int) *RingBuffer {
+	if size <= 0 {
+		size = 1
+	}
+	return &RingBuffer{records: make([]Record, size)}
+}
+
+func (b *RingBuffer) WriteRecord(r Record) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// 复制字段，调用方可能复用切片
+	r.Fields = cloneFields(r.Fields)
+	b.records[b.next] = r
+	b.next = (b.next + 1) % len(b.records)
+	if b.next == 0 {
+		b.full = true
+	}
+	return nil
+}
+
+func (b *RingBuffer) Close() error {
+	return nil
+}
+
+// Records 缓冲区中的全部日志，最早的在前
+func (b *RingBuffer) Records() []Record {
+	return b.Last(len(b.records))
+}
+
+// Last 最近的 n 条日志，最早的在前
+func (b *RingBuffer) Last(n int) []Record {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	count := b.next
+	if b.full {
+		count = len(b.records)
+	}
+	n = min(n, count)
+	out := make([]Record, 0, n)
+	for i := n; i > 0; i-- {
+		idx := (b.next - i + len(b.records)) % len(b.records)
+		out = append(out, b.records[idx])
+	}
+	return out
+}
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// slog 级别与 LogLevel 的对应：DEBUG 以下为 TRACE，其余按区间映射
+func fromSlogLevel(l slog.Level) LogLevel {
+	switch {
+	case l < slog.LevelDebug:
+		return TRACE
+	case l < slog.LevelInfo:
+		return DEBUG
+	case l < slog.LevelWarn:
+		return INFO
+	case l < slog.LevelError:
+		return WARN
+	}
+	return ERROR
+}
+
+// slogHandler 把 slog 的日志送入同一管道，级别由管道的级别表控制
+type slogHandler struct {
+	l *Logger
+	// WithGroup 设置的分组前缀，以点号连接
+	prefix string
+}
+
+// Handler 返回实现 slog.Handler 的适配器，输出到日志器的管道，
+// 日志器的字段和服务名称对 slog 的日志同样生效。
+//
+//	slog.SetDefault(slog.New(log.Handler()))
+func (l *Logger) Handler() slog.Handler {
+	return &slogHandler{l: l}
+}
+
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.l.Enabled(fromSlogLevel(level))
+}
+
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	fields := cloneFields(h.l.fields)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendAttr(fields, h.prefix, a)
+		return true
+	})
+	t := r.Time
+	if t.IsZero() {
+		t = time.Now()
+	}
+	h.l.write(Record{Time: t, Level: fromSlogLevel(r.Level), Service: h.l.service, Msg: r.Message, Fields: fields})
+	return nil
+}
+
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	fields := cloneFields(h.l.fields)
+	for _, a := range attrs {
+		fields = appendAttr(fields, h.prefix, a)
+	}
+	return &slogHandler{l: &Logger{p: h.l.p, service: h.l.service, fields: fields}, prefix: h.prefix}
+}
+
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return &slogHandler{l: h.l, prefix: h.prefix + name + "."}
+}
+
+// appendAttr 展开分组，键以点号连接
+func appendAttr(fields []Field, prefix string, a slog.Attr) []Field {
+	v := a.Value.Resolve()
+	if v.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range v.Group() {
+			fields = appendAttr(fields, prefix, ga)
+		}
+		return fields
+	}
+	if a.Key == "" {
+		return fields
+	}
+	return append(fields, Field{Key: prefix + a.Key, Value: v.Any()})
+}
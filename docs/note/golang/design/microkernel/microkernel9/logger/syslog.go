@@ -0,0 +1,145 @@
+package logger
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// syslog facility，见 RFC 5424
+const (
+	FacilityUser   = 1
+	FacilityDaemon = 3
+	FacilityLocal0 = 16
+)
+
+// SyslogOptions 本地 syslog 的选项
+type SyslogOptions struct {
+	// 为空时依次尝试 /dev/log、/var/run/syslog、/var/run/log
+	Addr string
+	// unixgram 或 unix，为空时先尝试 unixgram
+	Network string
+	// 默认 FacilityUser
+	Facility int
+	// 默认为程序名
+	Tag string
+}
+
+// syslogAddrs 常见的本地 syslog 套接字
+var syslogAddrs = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// SyslogSink 通过本地套接字写入 syslog
+//
+// 消息格式为 RFC 3164 的本地格式：<PRI>Mmm dd hh:mm:ss TAG[PID]: [service] msg key=value。
+// 写入失败时重新连接一次，syslog 守护进程重启后可以恢复。
+type SyslogSink struct {
+	opts SyslogOptions
+
+	mu   sync.Mutex
+	conn net.Conn
+	// 实际连接的网络类型
+	network string
+	buf     bytes.Buffer
+}
+
+// NewSyslog 连接本地 syslog
+func NewSyslog(opts SyslogOptions) (*SyslogSink, error) {
+	if opts.Facility == 0 {
+		opts.Facility = FacilityUser
+	}
+	if opts.Tag == "" {
+		opts.Tag = filepath.Base(os.Args[0])
+	}
+	s := &SyslogSink{opts: opts}
+	if err := s.connect(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SyslogSink) connect() error {
+	addrs := syslogAddrs
+	if s.opts.Addr != "" {
+		addrs = []string{s.opts.Addr}
+	}
+	networks := []string{"unixgram", "unix"}
+	if s.opts.Network != "" {
+		networks = []string{s.opts.Network}
+	}
+	var errs []error
+	for _, addr := range addrs {
+		for _, network := range networks {
+			conn, err := net.Dial(network, addr)
+			if err == nil {
+				s.conn, s.network = conn, network
+				return nil
+			}
+			errs = append(errs, err)
+		}
+	}
+	return fmt.Errorf("connect syslog: %w", errors.Join(errs...))
+}
+
+// severity 日志级别对应的 syslog 严重程度
+func severity(l LogLevel) int {
+	switch {
+	case l >= ERROR:
+		return 3 // err
+	case l >= WARN:
+		return 4 // warning
+	case l >= INFO:
+		return 6 // info
+	}
+	return 7 // debug
+}
+
+func (s *SyslogSink) WriteRecord(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Reset()
+	fmt.Fprintf(&s.buf, "<%d>%s %s[%d]: [%s] %s", s.opts.Facility*8+severity(r.Level),
+		r.Time.Format(time.Stamp), s.opts.Tag, os.Getpid(), r.Service, r.Msg)
+	for _, f := range r.Fields {
+		s.buf.WriteByte(' ')
+		s.buf.WriteString(logfmtKey(f.Key))
+		s.buf.WriteByte('=')
+		writeLogfmtValue(&s.buf, formatValue(f.Value))
+	}
+	msg := bytes.ReplaceAll(s.buf.Bytes(), []byte("\n"), []byte(" "))
+	if s.conn != nil {
+		if _, err := s.conn.Write(s.frame(msg)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.connect(); err != nil {
+		return err
+	}
+	_, err := s.conn.Write(s.frame(msg))
+	return err
+}
+
+// frame 流式套接字需要换行分隔消息
+func (s *SyslogSink) frame(msg []byte) []byte {
+	if s.network == "unixgram" {
+		return msg
+	}
+	return append(msg, '\n')
+}
+
+func (s *SyslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
@@ -0,0 +1,256 @@
+package logstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"microkernel/logger"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Query 查询条件，零值字段不参与过滤
+type Query struct {
+	// 服务名称，为空表示全部服务
+	Services []string `json:"services,omitempty"`
+	// 最低级别，零值为 INFO，需要全部级别时设为 TRACE
+	Level logger.LogLevel `json:"level"`
+	// 时间范围 [Since, Until)
+	Since time.Time `json:"since,omitempty"`
+	Until time.Time `json:"until,omitempty"`
+	// 消息、字段名或字段值包含该文本，不区分大小写
+	Text string `json:"text,omitempty"`
+	// 只返回序号大于 After 的日志，用于分页
+	After uint64 `json:"after,omitempty"`
+	// 只返回最新的 Limit 条，0 表示不限
+	Limit int `json:"limit,omitempty"`
+}
+
+// matchService 服务集合中是否有符合条件的服务
+func (q *Query) matchService(services map[string]int) bool {
+	if len(q.Services) == 0 {
+		return true
+	}
+	for _, name := range q.Services {
+		if services[name] > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTime 时间范围 [min, max] 是否与条件相交
+func (q *Query) matchTime(min, max time.Time) bool {
+	if !q.Since.IsZero() && max.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !min.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Match 日志是否符合条件
+func (q *Query) Match(e *Entry) bool {
+	if e.Seq <= q.After || e.Level < q.Level {
+		return false
+	}
+	if len(q.Services) > 0 && !slices.Contains(q.Services, e.Service) {
+		return false
+	}
+	if !q.Since.IsZero() && e.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !e.Time.Before(q.Until) {
+		return false
+	}
+	return q.Text == "" || matchText(e, strings.ToLower(q.Text))
+}
+
+func matchText(e *Entry, text string) bool {
+	if strings.Contains(strings.ToLower(e.Msg), text) {
+		return true
+	}
+	for k, v := range e.Fields {
+		if strings.Contains(strings.ToLower(k), text) ||
+			strings.Contains(strings.ToLower(fmt.Sprint(v)), text) {
+			return true
+		}
+	}
+	return false
+}
+
+// segmentView 查询时段索引的快照，读取文件时不持有锁
+type segmentView struct {
+	path   string
+	first  uint64
+	blocks []block
+}
+
+// snapshot 复制可能包含符合条件日志的段，只包含序号不大于 upto 的日志
+func (s *Store) snapshot(q *Query, upto uint64) ([]segmentView, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil, errors.New("log store closed")
+	}
+	var views []segmentView
+	for _, seg := range s.segments {
+		m := &seg.meta
+		if m.Count == 0 || m.Last <= q.After || m.First > upto ||
+			!q.matchTime(m.MinTime, m.MaxTime) || !q.matchService(m.Services) {
+			continue
+		}
+		views = append(views, segmentView{path: seg.logPath(), first: m.First, blocks: slices.Clone(m.Blocks)})
+	}
+	return views, nil
+}
+
+// Query 按条件查询日志，按序号升序返回
+func (s *Store) Query(q Query) ([]Entry, error) {
+	return s.query(&q, ^uint64(0))
+}
+
+// query 从最新的块向前读取，达到 Limit 后停止
+func (s *Store) query(q *Query, upto uint64) ([]Entry, error) {
+	views, err := s.snapshot(q, upto)
+	if err != nil {
+		return nil, err
+	}
+	var result []Entry
+	full := func() bool { return q.Limit > 0 && len(result) >= q.Limit }
+	for i := len(views) - 1; i >= 0 && !full(); i-- {
+		v := views[i]
+		f, err := os.Open(v.path)
+		if os.IsNotExist(err) {
+			// 查询期间被清理
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for j := len(v.blocks) - 1; j >= 0 && !full(); j-- {
+			b := v.blocks[j]
+			// 段内序号连续，除最后一块外每块 blockSize 条
+			first := v.first + uint64(j*blockSize)
+			last := first + uint64(b.Count) - 1
+			if last <= q.After || first > upto || !q.matchTime(b.MinTime, b.MaxTime) {
+				continue
+			}
+			var matched []Entry
+			err := readBlock(f, b, func(e *Entry) bool {
+				if e.Seq > upto {
+					return false
+				}
+				if q.Match(e) {
+					matched = append(matched, *e)
+				}
+				return true
+			})
+			if err != nil {
+				f.Close()
+				return nil, err
+			}
+			for k := len(matched) - 1; k >= 0 && !full(); k-- {
+				result = append(result, matched[k])
+			}
+		}
+		f.Close()
+	}
+	slices.Reverse(result)
+	return result, nil
+}
+
+// followBuffer 跟踪者的缓冲大小，消费跟不上时丢弃新日志
+const followBuffer = 1024
+
+type subscriber struct {
+	q      Query
+	ch     chan Entry
+	closed bool
+}
+
+// close 调用方持有 s.mu
+func (sub *subscriber) close() {
+	if !sub.closed {
+		sub.closed = true
+		close(sub.ch)
+	}
+}
+
+// publish 把新日志发给符合条件的跟踪者，调用方持有 s.mu
+func (s *Store) publish(e *Entry) {
+	for sub := range s.subs {
+		if !sub.q.Match(e) {
+			continue
+		}
+		select {
+		case sub.ch <- *e:
+		default:
+		}
+	}
+}
+
+// Follow 跟踪符合条件的日志：先返回已有的最新 tail 条，然后持续返回新写入的日志。
+// ctx 取消或存储关闭时关闭返回的通道；读取过慢时会丢弃部分新日志。
+func (s *Store) Follow(ctx context.Context, q Query, tail int) (<-chan Entry, error) {
+	sub := &subscriber{q: q, ch: make(chan Entry, followBuffer)}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, errors.New("log store closed")
+	}
+	// 先订阅再查询已有日志，订阅之后写入的日志都从订阅中获得
+	s.subs[sub] = struct{}{}
+	upto := s.nextSeq - 1
+	s.mu.Unlock()
+
+	var backlog []Entry
+	if tail > 0 {
+		bq := q
+		bq.Limit = tail
+		var err error
+		if backlog, err = s.query(&bq, upto); err != nil {
+			s.unsubscribe(sub)
+			return nil, err
+		}
+	}
+
+	out := make(chan Entry)
+	go func() {
+		defer close(out)
+		defer s.unsubscribe(sub)
+		for _, e := range backlog {
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+		for {
+			select {
+			case e, ok := <-sub.ch:
+				if !ok {
+					return
+				}
+				select {
+				case out <- e:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
+
+func (s *Store) unsubscribe(sub *subscriber) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subs, sub)
+	sub.close()
+}
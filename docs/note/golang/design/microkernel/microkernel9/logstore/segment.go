@@ -0,0 +1,183 @@
+package logstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 段文件格式：
+//
+//	<起始序号>.log  每行一条 JSON 编码的 Entry，按序号递增
+//	<起始序号>.idx  段封存后写入的索引（segmentMeta 的 JSON）
+//
+// 索引把段分为每 blockSize 条一块，记录块的偏移和时间范围，
+// 查询按服务集合和时间范围跳过整段，再按时间范围跳过块。
+// 活动段的索引只在内存中，打开存储时扫描重建。
+
+// blockSize 索引块的记录数
+const blockSize = 256
+
+// maxLineSize 单条记录的最大长度
+const maxLineSize = 1 << 20
+
+type block struct {
+	Offset  int64     `json:"offset"`
+	Count   int       `json:"count"`
+	MinTime time.Time `json:"min_time"`
+	MaxTime time.Time `json:"max_time"`
+}
+
+// segmentMeta 段的索引
+type segmentMeta struct {
+	First    uint64         `json:"first"`
+	Last     uint64         `json:"last"`
+	Count    int            `json:"count"`
+	Bytes    int64          `json:"bytes"`
+	MinTime  time.Time      `json:"min_time"`
+	MaxTime  time.Time      `json:"max_time"`
+	Created  time.Time      `json:"created"`
+	Services map[string]int `json:"services"`
+	Blocks   []block        `json:"blocks"`
+}
+
+type segment struct {
+	base string // 不含扩展名的路径
+	meta segmentMeta
+	// 只有活动段打开写入
+	file *os.File
+}
+
+func segmentBase(dir string, first uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d", first))
+}
+
+func (s *segment) logPath() string { return s.base + ".log" }
+func (s *segment) idxPath() string { return s.base + ".idx" }
+
+// add 把已写入的记录加入索引
+func (m *segmentMeta) add(e *Entry, offset, n int64) {
+	if m.Count == 0 {
+		m.First = e.Seq
+		m.MinTime, m.MaxTime = e.Time, e.Time
+	}
+	m.Last = e.Seq
+	m.Count++
+	m.Bytes = offset + n
+	if e.Time.Before(m.MinTime) {
+		m.MinTime = e.Time
+	}
+	if e.Time.After(m.MaxTime) {
+		m.MaxTime = e.Time
+	}
+	if m.Services == nil {
+		m.Services = make(map[string]int)
+	}
+	m.Services[e.Service]++
+	if len(m.Blocks) == 0 || m.Blocks[len(m.Blocks)-1].Count == blockSize {
+		m.Blocks = append(m.Blocks, block{Offset: offset, MinTime: e.Time, MaxTime: e.Time})
+	}
+	b := &m.Blocks[len(m.Blocks)-1]
+	b.Count++
+	if e.Time.Before(b.MinTime) {
+		b.MinTime = e.Time
+	}
+	if e.Time.After(b.MaxTime) {
+		b.MaxTime = e.Time
+	}
+}
+
+// scanSegment 扫描段文件重建索引，截断末尾写了一半的记录
+func scanSegment(base string) (segmentMeta, error) {
+	f, err := os.OpenFile(base+".log", os.O_RDWR, 0)
+	if err != nil {
+		return segmentMeta{}, err
+	}
+	defer f.Close()
+	var meta segmentMeta
+	if info, err := f.Stat(); err == nil {
+		meta.Created = info.ModTime()
+	}
+	r := bufio.NewReaderSize(f, 64*1024)
+	var offset int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				// 崩溃时写了一半的记录
+				if err := f.Truncate(offset); err != nil {
+					return meta, err
+				}
+			}
+			break
+		}
+		if err != nil {
+			return meta, err
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			if err := f.Truncate(offset); err != nil {
+				return meta, err
+			}
+			break
+		}
+		meta.add(&e, offset, int64(len(line)))
+		offset += int64(len(line))
+	}
+	return meta, nil
+}
+
+// loadIndex 读取封存段的索引，索引缺失或与段文件大小不符时返回错误
+func loadIndex(base string) (segmentMeta, error) {
+	var meta segmentMeta
+	data, err := os.ReadFile(base + ".idx")
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, err
+	}
+	info, err := os.Stat(base + ".log")
+	if err != nil {
+		return meta, err
+	}
+	if info.Size() != meta.Bytes {
+		return meta, fmt.Errorf("index of %s is stale", base)
+	}
+	return meta, nil
+}
+
+// writeIndex 原子写入封存段的索引
+func writeIndex(s *segment) error {
+	data, err := json.Marshal(&s.meta)
+	if err != nil {
+		return err
+	}
+	tmp := s.idxPath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.idxPath())
+}
+
+// readBlock 读取一块中的记录
+func readBlock(f *os.File, b block, fn func(e *Entry) bool) error {
+	sc := bufio.NewScanner(io.NewSectionReader(f, b.Offset, 1<<62))
+	sc.Buffer(make([]byte, 64*1024), maxLineSize)
+	for i := 0; i < b.Count && sc.Scan(); i++ {
+		var e Entry
+		if err := json.Unmarshal(bytes.TrimSpace(sc.Bytes()), &e); err != nil {
+			return fmt.Errorf("%s at offset %d: %w", f.Name(), b.Offset, err)
+		}
+		if !fn(&e) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
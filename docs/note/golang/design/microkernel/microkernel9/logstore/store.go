@@ -0,0 +1,342 @@
+// Package logstore 基于分段文件的本地日志存储，支持按服务、级别、时间范围和文本查询，
+// 跟踪新日志以及按时间和大小清理旧日志
+package logstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"microkernel/logger"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry 一条存储的日志
+type Entry struct {
+	// 存储分配的递增序号
+	Seq     uint64          `json:"seq"`
+	Time    time.Time       `json:"time"`
+	Level   logger.LogLevel `json:"level"`
+	Service string          `json:"service"`
+	Msg     string          `json:"msg"`
+	Fields  map[string]any  `json:"fields,omitempty"`
+}
+
+// FromRecord 把日志器的记录转换为存储的日志，字段值转换为可以 JSON 编码的形式
+func FromRecord(r logger.Record) Entry {
+	e := Entry{Time: r.Time, Level: r.Level, Service: r.Service, Msg: r.Msg}
+	if len(r.Fields) > 0 {
+		e.Fields = make(map[string]any, len(r.Fields))
+		for _, f := range r.Fields {
+			e.Fields[f.Key] = fieldValue(f.Value)
+		}
+	}
+	return e
+}
+
+// fieldValue 错误和 Stringer 编码为字符串，不能 JSON 编码的值按 fmt.Sprint 编码
+func fieldValue(v any) any {
+	switch v := v.(type) {
+	case nil, string, bool, int, int64, uint64, float64:
+		return v
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return v
+}
+
+// Options 存储选项
+type Options struct {
+	// 活动段超过该大小后封存，默认 4MiB
+	SegmentBytes int64
+	// 活动段创建超过该时间后封存，0 表示不按时间封存
+	SegmentAge time.Duration
+	// 删除最新日志早于该时间的段，0 表示不限
+	MaxAge time.Duration
+	// 所有段的总大小上限，超过时从最旧的段开始删除，0 表示不限
+	MaxBytes int64
+}
+
+// DefaultSegmentBytes 默认的段大小
+const DefaultSegmentBytes = 4 << 20
+
+// Store 日志存储
+type Store struct {
+	dir  string
+	opts Options
+
+	mu       sync.RWMutex
+	segments []*segment // 按序号排列，最后一个为活动段
+	nextSeq  uint64
+	subs     map[*subscriber]struct{}
+	closed   bool
+}
+
+// Open 打开或创建日志目录
+func Open(dir string, opts Options) (*Store, error) {
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = DefaultSegmentBytes
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Store{dir: dir, opts: opts, nextSeq: 1, subs: make(map[*subscriber]struct{})}
+	if err := s.load(); err != nil {
+		s.closeFiles()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) load() error {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.log"))
+	if err != nil {
+		return err
+	}
+	var firsts []uint64
+	for _, m := range matches {
+		first, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(m), ".log"), 10, 64)
+		if err != nil {
+			continue
+		}
+		firsts = append(firsts, first)
+	}
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i] < firsts[j] })
+	for i, first := range firsts {
+		seg := &segment{base: segmentBase(s.dir, first)}
+		active := i == len(firsts)-1
+		meta, err := loadIndex(seg.base)
+		if err != nil || active {
+			// 活动段和索引缺失的段扫描重建
+			if meta, err = scanSegment(seg.base); err != nil {
+				return err
+			}
+			if !active && meta.Count > 0 {
+				seg.meta = meta
+				if err := writeIndex(seg); err != nil {
+					return err
+				}
+			}
+		}
+		if meta.Count == 0 && !active {
+			// 空的封存段
+			os.Remove(seg.logPath())
+			os.Remove(seg.idxPath())
+			continue
+		}
+		seg.meta = meta
+		if meta.Count > 0 {
+			s.nextSeq = meta.Last + 1
+		}
+		s.segments = append(s.segments, seg)
+	}
+	if len(s.segments) == 0 {
+		return s.newSegment()
+	}
+	active := s.segments[len(s.segments)-1]
+	f, err := os.OpenFile(active.logPath(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	active.file = f
+	// 活动段的索引以内存为准，旧的索引文件作废
+	os.Remove(active.idxPath())
+	return nil
+}
+
+// newSegment 创建新的活动段，调用方持有 s.mu
+func (s *Store) newSegment() error {
+	seg := &segment{base: segmentBase(s.dir, s.nextSeq)}
+	f, err := os.OpenFile(seg.logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	seg.file = f
+	seg.meta.Created = time.Now()
+	s.segments = append(s.segments, seg)
+	return nil
+}
+
+func (s *Store) active() *segment {
+	return s.segments[len(s.segments)-1]
+}
+
+// Append 写入日志，分配序号并通知跟踪者
+func (s *Store) Append(e Entry) (uint64, error) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	if len(e.Fields) > 0 {
+		fields := make(map[string]any, len(e.Fields))
+		for k, v := range e.Fields {
+			fields[k] = fieldValue(v)
+		}
+		e.Fields = fields
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, errors.New("log store closed")
+	}
+	seg := s.active()
+	if s.shouldRoll(seg) {
+		if err := s.roll(); err != nil {
+			return 0, err
+		}
+		seg = s.active()
+	}
+	e.Seq = s.nextSeq
+	line, err := json.Marshal(&e)
+	if err != nil {
+		return 0, err
+	}
+	line = append(line, '\n')
+	offset := seg.meta.Bytes
+	if _, err := seg.file.Write(line); err != nil {
+		return 0, err
+	}
+	seg.meta.add(&e, offset, int64(len(line)))
+	s.nextSeq++
+	s.publish(&e)
+	return e.Seq, nil
+}
+
+func (s *Store) shouldRoll(seg *segment) bool {
+	if seg.meta.Count == 0 {
+		return false
+	}
+	if seg.meta.Bytes >= s.opts.SegmentBytes {
+		return true
+	}
+	return s.opts.SegmentAge > 0 && time.Since(seg.meta.Created) >= s.opts.SegmentAge
+}
+
+// roll 封存活动段并创建新段，然后按保留策略清理，调用方持有 s.mu
+func (s *Store) roll() error {
+	seg := s.active()
+	if err := seg.file.Sync(); err != nil {
+		return err
+	}
+	if err := seg.file.Close(); err != nil {
+		return err
+	}
+	seg.file = nil
+	if err := writeIndex(seg); err != nil {
+		return err
+	}
+	if err := s.newSegment(); err != nil {
+		return err
+	}
+	return s.pruneLocked(time.Now())
+}
+
+// Prune 封存到期的活动段，按保留策略删除旧的封存段
+func (s *Store) Prune() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	// 按时间封存的活动段在没有新日志时也要封存
+	if s.shouldRoll(s.active()) {
+		return s.roll()
+	}
+	return s.pruneLocked(time.Now())
+}
+
+func (s *Store) pruneLocked(now time.Time) error {
+	var total int64
+	for _, seg := range s.segments {
+		total += seg.meta.Bytes
+	}
+	var errs []error
+	// 活动段不删除
+	for len(s.segments) > 1 {
+		oldest := s.segments[0]
+		expired := s.opts.MaxAge > 0 && now.Sub(oldest.meta.MaxTime) > s.opts.MaxAge
+		oversize := s.opts.MaxBytes > 0 && total > s.opts.MaxBytes
+		if !expired && !oversize {
+			break
+		}
+		if err := os.Remove(oldest.logPath()); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+			break
+		}
+		os.Remove(oldest.idxPath())
+		total -= oldest.meta.Bytes
+		s.segments = s.segments[1:]
+	}
+	return errors.Join(errs...)
+}
+
+// Stats 存储的统计信息
+type Stats struct {
+	Segments int       `json:"segments"`
+	Entries  int       `json:"entries"`
+	Bytes    int64     `json:"bytes"`
+	FirstSeq uint64    `json:"first_seq"`
+	LastSeq  uint64    `json:"last_seq"`
+	Oldest   time.Time `json:"oldest"`
+	Newest   time.Time `json:"newest"`
+}
+
+// Stats 返回存储的统计信息
+func (s *Store) Stats() Stats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	st := Stats{Segments: len(s.segments), LastSeq: s.nextSeq - 1}
+	for _, seg := range s.segments {
+		if seg.meta.Count == 0 {
+			continue
+		}
+		if st.Entries == 0 {
+			st.FirstSeq, st.Oldest = seg.meta.First, seg.meta.MinTime
+		}
+		st.Entries += seg.meta.Count
+		st.Bytes += seg.meta.Bytes
+		if seg.meta.MinTime.Before(st.Oldest) {
+			st.Oldest = seg.meta.MinTime
+		}
+		if seg.meta.MaxTime.After(st.Newest) {
+			st.Newest = seg.meta.MaxTime
+		}
+	}
+	return st
+}
+
+// Close 同步并关闭活动段，结束所有跟踪
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	for sub := range s.subs {
+		sub.close()
+	}
+	s.subs = nil
+	return s.closeFiles()
+}
+
+func (s *Store) closeFiles() error {
+	var errs []error
+	for _, seg := range s.segments {
+		if seg.file != nil {
+			errs = append(errs, seg.file.Sync(), seg.file.Close())
+			seg.file = nil
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -1,26 +1,58 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
 	"fmt"
+	"log/slog"
+	"microkernel/admin"
+	"microkernel/gateway"
 	"microkernel/microkernel"
 	"microkernel/service"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+	"text/tabwriter"
 	"time"
 )
 
 func main() {
-	crypter := microkernel.NewAESCrypter([]byte("1234567890123456"))
-	store := microkernel.NewStateStore("./state", crypter)
-	// 1. 创建微内核
-	microKernel := microkernel.NewMicroKernel(store) // 增加加密存储
-	// 2. 注册服务
-	logSvc := service.NewLogService(microKernel)
-	if err := microKernel.Register(logSvc); err != nil {
+	configFile := flag.String("config", "./kernel.toml", "kernel config file")
+	listTypes := flag.Bool("types", false, "list registered service types and exit")
+	adminAddr := flag.String("admin", "unix:./admin.sock", "admin API address: unix:/path or a loopback host:port")
+	httpAddr := flag.String("http", "127.0.0.1:8080", "gateway and metrics address, loopback only (no authentication)")
+	flag.Parse()
+	if *listTypes {
+		printServiceTypes()
+		return
+	}
+
+	// 1. 按配置文件创建微内核 2. 注册服务
+	// 内核、状态存储、密钥来源和服务都在配置文件中声明，服务按类型从注册表创建
+	// 示例状态使用的密钥：MICROKERNEL_KEY=1234567890123456 go run .
+	cfg, err := microkernel.LoadConfig(*configFile)
+	if err != nil {
+		panic(err)
+	}
+	runtime, err := cfg.Apply(nil)
+	if err != nil {
 		panic(err)
 	}
-	// 增加EchoService服务注册
-	echoSvc := service.NewEchoService(microKernel)
-	if err := microKernel.Register(echoSvc); err != nil {
+	defer runtime.Close()
+	microKernel, crypter := runtime.Kernel, runtime.Crypter
+	// 使用 log/slog 的第三方库与内核共用日志管道
+	slog.SetDefault(slog.New(microKernel.Logger().Named("slog").Handler()))
+	svc, _ := microKernel.Service("logger")
+	logSvc := svc.(*service.LogService)
+	// 加载插件目录中的服务（Go plugin 或子进程服务）
+	loader := microkernel.NewPluginLoader(microKernel, "./plugins", crypter)
+	if err := loader.LoadAll(); err != nil && !errors.Is(err, os.ErrNotExist) {
 		panic(err)
 	}
 	// 3. 启动所有服务
@@ -32,6 +64,59 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	go microKernel.Listen(ctx)
+	// 监听插件目录，模块变化时热替换
+	go loader.Watch(ctx, time.Second)
+	// 收到 SIGHUP 时重载配置文件，按差异增删、替换或重新配置服务
+	go runtime.ReloadOnSignal(ctx, syscall.SIGHUP)
+
+	// 通过 HTTP 网关对外暴露 echo 服务和日志查询
+	// 例如 curl -d '{"services":["echo"],"tail":10}' 'http://127.0.0.1:8080/services/logger/log.follow?stream=1'
+	gw, err := gateway.New(microKernel,
+		gateway.Route{Service: "echo", Stream: true},
+		gateway.Route{
+			Service: "logger",
+			Types:   []string{service.EventLogQuery, service.EventLogFollow, service.EventLogStats},
+			Stream:  true,
+			// 跟踪日志的连接保持较长时间
+			Timeout: time.Hour,
+		})
+	if err != nil {
+		panic(err)
+	}
+	// 同一端口以 Prometheus 文本格式导出内核和服务的指标
+	// 示例路由没有配置 Tokens，网关和指标都不认证，因此只监听回环地址；
+	// 需要对外暴露时为路由配置 Tokens 和 AllowCIDRs，或者放在带认证的反向代理之后
+	mux := http.NewServeMux()
+	mux.Handle("/services/", gw)
+	mux.Handle("/metrics", microKernel.Metrics().Handler())
+	ln, err := listenLoopback(*httpAddr)
+	if err != nil {
+		panic(err)
+	}
+	srv := &http.Server{Handler: mux}
+	context.AfterFunc(ctx, func() { srv.Close() })
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			microKernel.Logger().Error("gateway stopped", "addr", *httpAddr, "err", err)
+		}
+	}()
+
+	// 管理接口：查看和启停服务、发送测试事件、保存状态、查看死信
+	// 例如 curl --unix-socket admin.sock -H "Authorization: Bearer $(cat admin.token)" http://admin/v1/services
+	// 或者使用命令行客户端 go run ./cmd/mkctl services
+	token, err := adminToken("./admin.token")
+	if err != nil {
+		panic(err)
+	}
+	adm, err := admin.New(microKernel, admin.Options{Token: token, Runtime: runtime})
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		if err := adm.ListenAndServe(ctx, *adminAddr); err != nil {
+			microKernel.Logger().Error("admin API stopped", "addr", *adminAddr, "err", err)
+		}
+	}()
 
 	// 5. 测试日志服务
 	logSvc.Log("Hello, Microkernel!")
@@ -51,7 +136,7 @@ func main() {
 	// 7. 热替换服务
 	// 热更新为 V2
 	//_ = microKernel.ReplaceService(service.NewEchoServiceV2(microKernel))
-	err := microKernel.ReplaceServiceEncrypted(service.NewEchoServiceV2(microKernel), crypter)
+	err = microKernel.ReplaceServiceEncrypted(service.NewEchoServiceV2(microKernel), crypter)
 	if err != nil {
 		panic(err)
 	}
@@ -72,8 +157,68 @@ func main() {
 	})
 	fmt.Println("v2 reply:", <-replyCh2)
 
+	// 一致性快照：所有服务的状态和队列中的事件写入一个加密归档，可用 Restore 恢复
+	var snapshot bytes.Buffer
+	if err := microKernel.Snapshot(&snapshot, crypter); err != nil {
+		panic(err)
+	}
+	fmt.Printf("snapshot: %d bytes\n", snapshot.Len())
+
 	// 8. 停止所有服务
 	if err := microKernel.StopAll(); err != nil {
 		panic(err)
 	}
 }
+
+// listenLoopback 监听 TCP 地址，拒绝回环以外的地址
+func listenLoopback(addr string) (net.Listener, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("gateway: %s is not a loopback address", addr)
+	}
+	return net.Listen("tcp", addr)
+}
+
+// adminToken 管理接口的 token：优先使用环境变量 MICROKERNEL_ADMIN_TOKEN，
+// 否则读取 path，文件不存在时生成随机 token 写入（权限 0600）
+func adminToken(path string) (string, error) {
+	if token := os.Getenv("MICROKERNEL_ADMIN_TOKEN"); token != "" {
+		return token, nil
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", err
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+	return token, os.WriteFile(path, []byte(token+"\n"), 0o600)
+}
+
+// printServiceTypes 列出服务包注册的服务类型和参数
+func printServiceTypes() {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tVERSION\tDESCRIPTION")
+	for _, t := range microkernel.ServiceTypes() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", t.Name, t.Version, t.Description)
+		for _, p := range t.Params {
+			def := "optional"
+			switch {
+			case p.Required:
+				def = "required"
+			case p.Default != nil:
+				def = fmt.Sprintf("default %v", p.Default)
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s (%s)\n", p.Name, p.Kind, p.Description, def)
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,433 @@
+// Package metrics 进程内指标：计数器、仪表和直方图，支持标签，
+// 可以通过 Go API 读取，也可以按 Prometheus 文本格式导出
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind 指标类型
+type Kind int
+
+const (
+	KindCounter Kind = iota
+	KindGauge
+	KindHistogram
+)
+
+func (k Kind) String() string {
+	return [...]string{"counter", "gauge", "histogram"}[k]
+}
+
+// DefBuckets 默认的直方图桶（秒），覆盖 1ms 到 10s 的延迟
+var DefBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// ExponentialBuckets 从 start 开始每次乘以 factor 的 count 个桶
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+var (
+	nameRe  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Registry 指标注册表，并发安全
+//
+// 同名指标重复注册时，类型、标签和桶都相同则返回已注册的指标，
+// 服务热替换后重新注册可以继续累计；不同则返回错误。
+type Registry struct {
+	mu       sync.RWMutex
+	families map[string]*family
+}
+
+// NewRegistry 创建空的注册表
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// family 同名指标的全部标签组合
+type family struct {
+	name    string
+	help    string
+	kind    Kind
+	labels  []string
+	buckets []float64
+
+	mu     sync.RWMutex
+	series map[string]*series
+	// GaugeFunc 注册的指标没有序列，读取时调用 fn
+	isFunc bool
+	fn     func() float64
+}
+
+type series struct {
+	values []string
+	// 计数器和仪表的值
+	value atomicFloat
+	hist  *Histogram
+}
+
+func (r *Registry) register(name, help string, kind Kind, buckets []float64, labels []string, isFunc bool) (*family, error) {
+	if !nameRe.MatchString(name) {
+		return nil, fmt.Errorf("invalid metric name %q", name)
+	}
+	for _, l := range labels {
+		if !labelRe.MatchString(l) || strings.HasPrefix(l, "__") || (kind == KindHistogram && l == "le") {
+			return nil, fmt.Errorf("metric %s: invalid label name %q", name, l)
+		}
+	}
+	if kind == KindHistogram {
+		if len(buckets) == 0 {
+			buckets = DefBuckets
+		}
+		if !slices.IsSorted(buckets) {
+			return nil, fmt.Errorf("metric %s: buckets must be sorted", name)
+		}
+		// +Inf 桶由导出时补上
+		if math.IsInf(buckets[len(buckets)-1], 1) {
+			buckets = buckets[:len(buckets)-1]
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.kind != kind || f.isFunc != isFunc || !slices.Equal(f.labels, labels) || !slices.Equal(f.buckets, buckets) {
+			return nil, fmt.Errorf("metric %s already registered as %s with labels %v", name, f.kind, f.labels)
+		}
+		return f, nil
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  slices.Clone(labels),
+		buckets: slices.Clone(buckets),
+		series:  make(map[string]*series),
+		isFunc:  isFunc,
+	}
+	r.families[name] = f
+	return f, nil
+}
+
+// with 按标签值取得序列，不存在时创建
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s: got %d label values, want %d", f.name, len(values), len(f.labels)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.RLock()
+	s, ok := f.series[key]
+	f.mu.RUnlock()
+	if ok {
+		return s
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.series[key]; ok {
+		return s
+	}
+	s = &series{values: slices.Clone(values)}
+	if f.kind == KindHistogram {
+		s.hist = newHistogram(f.buckets)
+	}
+	f.series[key] = s
+	return s
+}
+
+func (f *family) delete(values []string) bool {
+	key := strings.Join(values, "\xff")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.series[key]
+	delete(f.series, key)
+	return ok
+}
+
+// Counter 只增不减的计数器
+type Counter struct{ s *series }
+
+// Inc 加 1
+func (c *Counter) Inc() { c.s.value.add(1) }
+
+// Add 增加 v，v 为负数时 panic
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.s.value.add(v)
+}
+
+// Value 当前值
+func (c *Counter) Value() float64 { return c.s.value.load() }
+
+// Gauge 可增可减的仪表
+type Gauge struct{ s *series }
+
+func (g *Gauge) Set(v float64)  { g.s.value.store(v) }
+func (g *Gauge) Add(v float64)  { g.s.value.add(v) }
+func (g *Gauge) Inc()           { g.s.value.add(1) }
+func (g *Gauge) Dec()           { g.s.value.add(-1) }
+func (g *Gauge) Value() float64 { return g.s.value.load() }
+
+// Histogram 按桶统计观测值的分布
+type Histogram struct {
+	upper []float64
+
+	mu     sync.Mutex
+	counts []uint64 // 每个桶（不累计），最后一个为 +Inf
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{upper: buckets, counts: make([]uint64, len(buckets)+1)}
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.count++
+	h.mu.Unlock()
+}
+
+// ObserveDuration 以秒为单位记录时长
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+// Snapshot 当前的分布
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snap := HistogramSnapshot{Count: h.count, Sum: h.sum, Buckets: make([]Bucket, len(h.counts))}
+	var cum uint64
+	for i, n := range h.counts {
+		cum += n
+		upper := math.Inf(1)
+		if i < len(h.upper) {
+			upper = h.upper[i]
+		}
+		snap.Buckets[i] = Bucket{UpperBound: upper, Count: cum}
+	}
+	return snap
+}
+
+// CounterVec 带标签的计数器
+type CounterVec struct{ f *family }
+
+// With 按标签值（与注册时的标签顺序一致）取得计数器
+func (v *CounterVec) With(values ...string) *Counter { return &Counter{v.f.with(values)} }
+
+// Delete 删除标签值对应的计数器
+func (v *CounterVec) Delete(values ...string) bool { return v.f.delete(values) }
+
+// GaugeVec 带标签的仪表
+type GaugeVec struct{ f *family }
+
+func (v *GaugeVec) With(values ...string) *Gauge { return &Gauge{v.f.with(values)} }
+func (v *GaugeVec) Delete(values ...string) bool { return v.f.delete(values) }
+
+// HistogramVec 带标签的直方图
+type HistogramVec struct{ f *family }
+
+func (v *HistogramVec) With(values ...string) *Histogram { return v.f.with(values).hist }
+func (v *HistogramVec) Delete(values ...string) bool     { return v.f.delete(values) }
+
+// Counter 注册不带标签的计数器
+func (r *Registry) Counter(name, help string) (*Counter, error) {
+	f, err := r.register(name, help, KindCounter, nil, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Counter{f.with(nil)}, nil
+}
+
+// CounterVec 注册带标签的计数器
+func (r *Registry) CounterVec(name, help string, labels ...string) (*CounterVec, error) {
+	f, err := r.register(name, help, KindCounter, nil, labels, false)
+	if err != nil {
+		return nil, err
+	}
+	return &CounterVec{f}, nil
+}
+
+// Gauge 注册不带标签的仪表
+func (r *Registry) Gauge(name, help string) (*Gauge, error) {
+	f, err := r.register(name, help, KindGauge, nil, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Gauge{f.with(nil)}, nil
+}
+
+// GaugeVec 注册带标签的仪表
+func (r *Registry) GaugeVec(name, help string, labels ...string) (*GaugeVec, error) {
+	f, err := r.register(name, help, KindGauge, nil, labels, false)
+	if err != nil {
+		return nil, err
+	}
+	return &GaugeVec{f}, nil
+}
+
+// GaugeFunc 注册读取时调用 fn 取值的仪表，重复注册时替换 fn
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) error {
+	f, err := r.register(name, help, KindGauge, nil, nil, true)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	f.fn = fn
+	f.mu.Unlock()
+	return nil
+}
+
+// Histogram 注册不带标签的直方图，buckets 为升序的桶上界，为空时使用 DefBuckets
+func (r *Registry) Histogram(name, help string, buckets []float64) (*Histogram, error) {
+	f, err := r.register(name, help, KindHistogram, buckets, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	return f.with(nil).hist, nil
+}
+
+// HistogramVec 注册带标签的直方图
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labels ...string) (*HistogramVec, error) {
+	f, err := r.register(name, help, KindHistogram, buckets, labels, false)
+	if err != nil {
+		return nil, err
+	}
+	return &HistogramVec{f}, nil
+}
+
+// Unregister 删除指标
+func (r *Registry) Unregister(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.families[name]
+	delete(r.families, name)
+	return ok
+}
+
+// Bucket 直方图的一个桶，Count 为不大于上界的观测值个数（累计）
+type Bucket struct {
+	UpperBound float64
+	Count      uint64
+}
+
+// HistogramSnapshot 直方图的分布，最后一个桶的上界为 +Inf
+type HistogramSnapshot struct {
+	Count   uint64
+	Sum     float64
+	Buckets []Bucket
+}
+
+// Sample 一组标签值对应的值
+type Sample struct {
+	Labels map[string]string
+	// 计数器和仪表的值
+	Value float64
+	// 直方图的分布
+	Histogram *HistogramSnapshot
+}
+
+// Family 同名指标的全部样本
+type Family struct {
+	Name    string
+	Help    string
+	Kind    Kind
+	Labels  []string
+	Samples []Sample
+}
+
+// Gather 读取全部指标，按名称和标签值排序
+func (r *Registry) Gather() []Family {
+	r.mu.RLock()
+	fams := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		fams = append(fams, f)
+	}
+	r.mu.RUnlock()
+	sort.Slice(fams, func(i, j int) bool { return fams[i].name < fams[j].name })
+
+	out := make([]Family, 0, len(fams))
+	for _, f := range fams {
+		out = append(out, f.gather())
+	}
+	return out
+}
+
+// Find 按名称读取指标
+func (r *Registry) Find(name string) (Family, bool) {
+	r.mu.RLock()
+	f, ok := r.families[name]
+	r.mu.RUnlock()
+	if !ok {
+		return Family{}, false
+	}
+	return f.gather(), true
+}
+
+func (f *family) gather() Family {
+	f.mu.RLock()
+	fam := Family{Name: f.name, Help: f.help, Kind: f.kind, Labels: slices.Clone(f.labels)}
+	fn := f.fn
+	list := make([]*series, 0, len(f.series))
+	for _, s := range f.series {
+		list = append(list, s)
+	}
+	f.mu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return slices.Compare(list[i].values, list[j].values) < 0 })
+
+	if f.isFunc {
+		if fn != nil {
+			fam.Samples = []Sample{{Labels: map[string]string{}, Value: fn()}}
+		}
+		return fam
+	}
+	for _, s := range list {
+		sample := Sample{Labels: make(map[string]string, len(f.labels))}
+		for i, l := range f.labels {
+			sample.Labels[l] = s.values[i]
+		}
+		if s.hist != nil {
+			snap := s.hist.Snapshot()
+			sample.Histogram = &snap
+		} else {
+			sample.Value = s.value.load()
+		}
+		fam.Samples = append(fam.Samples, sample)
+	}
+	return fam
+}
+
+// atomicFloat 原子操作的 float64
+type atomicFloat struct{ bits atomic.Uint64 }
+
+func (a *atomicFloat) load() float64   { return math.Float64frombits(a.bits.Load()) }
+func (a *atomicFloat) store(v float64) { a.bits.Store(math.Float64bits(v)) }
+
+func (a *atomicFloat) add(v float64) {
+	for {
+		old := a.bits.Load()
+		if a.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
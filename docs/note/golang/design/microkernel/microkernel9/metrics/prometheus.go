@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentType Prometheus 文本格式 0.0.4 的 Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteText 按 Prometheus 文本格式写出全部指标
+func (r *Registry) WriteText(w io.Writer) error {
+	return WriteText(w, r.Gather())
+}
+
+// WriteText 按 Prometheus 文本格式写出指标
+//
+//	# HELP name help
+//	# TYPE name counter
+//	name{label="value"} 1
+//
+// 直方图写出累计的 name_bucket{le="..."}，以及 name_sum 和 name_count。
+func WriteText(w io.Writer, fams []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, f := range fams {
+		if f.Help != "" {
+			bw.WriteString("# HELP " + f.Name + " " + escapeHelp(f.Help) + "\n")
+		}
+		bw.WriteString("# TYPE " + f.Name + " " + f.Kind.String() + "\n")
+		for _, s := range f.Samples {
+			if s.Histogram == nil {
+				writeSample(bw, f.Name, f.Labels, s.Labels, "", s.Value)
+				continue
+			}
+			for _, b := range s.Histogram.Buckets {
+				writeSample(bw, f.Name+"_bucket", f.Labels, s.Labels, formatFloat(b.UpperBound), float64(b.Count))
+			}
+			writeSample(bw, f.Name+"_sum", f.Labels, s.Labels, "", s.Histogram.Sum)
+			writeSample(bw, f.Name+"_count", f.Labels, s.Labels, "", float64(s.Histogram.Count))
+		}
+	}
+	return bw.Flush()
+}
+
+// writeSample 写出一行样本，le 不为空时追加 le 标签
+func writeSample(w *bufio.Writer, name string, labels []string, values map[string]string, le string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || le != "" {
+		w.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(l + `="` + escapeLabel(values[l]) + `"`)
+		}
+		if le != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			w.WriteString(`le="` + le + `"`)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// Handler 以 Prometheus 文本格式导出指标的 HTTP 处理器，挂载到 /metrics 供抓取
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", ContentType)
+		if req.Method == http.MethodHead {
+			return
+		}
+		r.WriteText(w)
+	})
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// golden testRegistry 导出的文本
+// 指标按名称排序；标签按注册顺序输出，样本按标签值排序
+const golden = `# HELP mk_events_total Events handled.\nBy service\\code
+# TYPE mk_events_total counter
+mk_events_total{service="a\"b\\c\nd",code="500"} 1
+mk_events_total{service="echo",code="200"} 3
+mk_events_total{service="echo",code="404"} 1
+# HELP mk_latency_seconds Handle latency.
+# TYPE mk_latency_seconds histogram
+mk_latency_seconds_bucket{service="x",le="0.5"} 2
+mk_latency_seconds_bucket{service="x",le="1"} 2
+mk_latency_seconds_bucket{service="x",le="+Inf"} 3
+mk_latency_seconds_sum{service="x"} 2.75
+mk_latency_seconds_count{service="x"} 3
+# HELP mk_queue Queue length.
+# TYPE mk_queue gauge
+mk_queue 2.5
+# TYPE mk_size histogram
+mk_size_bucket{le="1"} 0
+mk_size_bucket{le="+Inf"} 1
+mk_size_sum 3
+mk_size_count 1
+# TYPE mk_up gauge
+mk_up 1
+`
+
+func testRegistry(t *testing.T) *Registry {
+	t.Helper()
+	r := NewRegistry()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	up, err := r.Gauge("mk_up", "")
+	must(err)
+	up.Set(1)
+	events, err := r.CounterVec("mk_events_total", "Events handled.\nBy service\\code", "service", "code")
+	must(err)
+	events.With("echo", "404").Inc()
+	events.With("echo", "200").Add(3)
+	events.With("a\"b\\c\nd", "500").Inc()
+	latency, err := r.HistogramVec("mk_latency_seconds", "Handle latency.", []float64{0.5, 1}, "service")
+	must(err)
+	for _, v := range []float64{0.25, 0.5, 2} {
+		latency.With("x").Observe(v)
+	}
+	size, err := r.Histogram("mk_size", "", []float64{1})
+	must(err)
+	size.Observe(3)
+	must(r.GaugeFunc("mk_queue", "Queue length.", func() float64 { return 2.5 }))
+	return r
+}
+
+func TestWriteText(t *testing.T) {
+	var b strings.Builder
+	if err := testRegistry(t).WriteText(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != golden {
+		t.Fatalf("got:\n%s\nwant:\n%s", b.String(), golden)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	h := testRegistry(t).Handler()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != ContentType {
+		t.Fatalf("GET = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	if rec.Body.String() != golden {
+		t.Fatalf("body:\n%s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST = %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,864 @@
+package microkernel
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Config 内核配置文件，支持 JSON（.json）和 TOML 子集（.toml）
+//
+//	[kernel]
+//	queue_size = 100
+//	persist = { mode = "interval", interval = "2s" }
+//
+//	[store]
+//	backend = "file"          # file、log 或 memory
+//	path = "./state"
+//	generations = 3
+//
+//	[key]
+//	mode = "aes"                     # aes（默认）、keyring 或 envelope，见 KeyConfig
+//	source = "env:MICROKERNEL_KEY"   # 格式见 ParseKeySource，省略时使用 DefaultKeyProvider
+//	                                 # memory 后端省略时使用一次性的随机密钥
+//
+//	[[services]]
+//	name = "echo"
+//	type = "echo"
+//	deps = ["logger"]
+//	restart = { policy = "on-failure", max_restarts = 3, backoff = "1s" }
+//	persist = { mode = "on-change", debounce = "500ms" }
+//	[services.params]        # 按服务类型声明的参数校验，见 ServiceType
+//	greeting = "hello"
+//
+// 加载时校验全部字段并读取一次密钥，错误带有文件名和行号。
+// 运行中修改后可以用 Runtime.Reload 重载，kernel.queue_size、store 和 key 需要重启才能修改。
+type Config struct {
+	File     string
+	Kernel   KernelConfig
+	Store    StoreConfig
+	Key      KeyConfig
+	Services []ServiceConfig
+	// [key] 所在的行，用于报告密钥错误
+	keyLine int
+}
+
+// KernelConfig 内核参数
+type KernelConfig struct {
+	QueueSize int
+	// 服务没有声明持久化策略时使用
+	Persist *PersistPolicy
+}
+
+// StoreConfig 状态存储后端
+type StoreConfig struct {
+	Backend     string
+	Path        string
+	Generations int
+}
+
+// KeyConfig 状态加密密钥
+//
+//	mode = "aes"        单个 AES 密钥，来源为 source
+//	mode = "keyring"    密钥环：keys 中的全部密钥都可以解密，active（默认第一个）用于加密
+//	                    keys = [{ id = "k2", source = "file:./k2.key" }, { id = "k1", source = "env:OLD_KEY" }]
+//	mode = "envelope"   信封加密：每个服务独立的数据密钥，由主密钥包装后保存在 dir
+//	                    master = "aes"     对称主密钥，来源为 source
+//	                    master = "x25519"  X25519 主密钥，source 为 32 字节私钥；
+//	                                       只写节点改用 public_key（statectl pubkey 输出的公钥文件）
+//	                    dir 默认为 file 后端的 <path>/keys、log 后端的 <path>.keys
+type KeyConfig struct {
+	Mode   string
+	Source string
+	// keyring
+	Keys   []KeyringEntry
+	Active string
+	// envelope
+	Master    string
+	PublicKey string
+	Dir       string
+}
+
+// KeyringEntry 密钥环中的一个密钥
+type KeyringEntry struct {
+	ID     string
+	Source string
+}
+
+// 密钥模式
+const (
+	KeyAES      = "aes"
+	KeyKeyring  = "keyring"
+	KeyEnvelope = "envelope"
+)
+
+// 信封加密的主密钥类型
+const (
+	MasterAES    = "aes"
+	MasterX25519 = "x25519"
+)
+
+// ServiceConfig 一个服务的声明
+type ServiceConfig struct {
+	Name   string
+	Type   string
+	Params map[string]any
+	// 在服务自己声明的依赖之外追加的依赖
+	Deps    []string
+	Restart *RestartPolicy
+	Persist *PersistPolicy
+	// 声明所在的行，用于报告构建错误
+	Line int
+}
+
+// 状态存储后端
+const (
+	StoreFile   = "file"
+	StoreLog    = "log"
+	StoreMemory = "memory"
+)
+
+// LoadConfig 读取并校验配置文件，格式由扩展名决定，服务类型和参数按 DefaultServiceRegistry 校验
+func LoadConfig(path string) (*Config, error) {
+	return LoadConfigWith(path, DefaultServiceRegistry)
+}
+
+// LoadConfigWith 读取配置文件，服务类型和参数按 registry 校验；
+// 使用自定义 BuildFunc 时 registry 为 nil，不校验服务类型
+func LoadConfigWith(path string, registry *ServiceRegistry) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := ParseConfigWith(path, data, registry)
+	if err != nil {
+		return nil, err
+	}
+	// 密钥不可用时在加载时失败，而不是在运行中第一次加密状态时
+	crypter, err := cfg.NewCrypter()
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := crypter.(interface{ Close() error }); ok {
+		c.Close()
+	}
+	return cfg, nil
+}
+
+// ParseConfig 解析并校验配置，file 用于选择格式和错误信息，服务类型和参数按 DefaultServiceRegistry 校验
+func ParseConfig(file string, data []byte) (*Config, error) {
+	return ParseConfigWith(file, data, DefaultServiceRegistry)
+}
+
+// ParseConfigWith 解析并校验配置，服务类型和参数按 registry 校验，registry 为 nil 时不校验
+func ParseConfigWith(file string, data []byte, registry *ServiceRegistry) (*Config, error) {
+	var root *configNode
+	var err error
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".json":
+		root, err = parseJSONConfig(file, data)
+	case ".toml":
+		root, err = parseTOMLConfig(file, data)
+	default:
+		return nil, fmt.Errorf("%s: unsupported config format, want .json or .toml", file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := &configDecoder{file: file, registry: registry}
+	cfg := d.decode(root)
+	if len(d.errs) > 0 {
+		return nil, errors.Join(d.errs...)
+	}
+	return cfg, nil
+}
+
+// configDecoder 把节点树解码为 Config，收集全部错误
+type configDecoder struct {
+	file     string
+	registry *ServiceRegistry
+	errs     []error
+}
+
+func (d *configDecoder) errorf(n *configNode, path, format string, args ...any) {
+	d.errs = append(d.errs, &ConfigError{File: d.file, Line: n.line, Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+// tableReader 读取一个表的字段，记录用过的键，done 时报告未知的键
+type tableReader struct {
+	d    *configDecoder
+	n    *configNode
+	path string
+	used map[string]bool
+}
+
+func (d *configDecoder) table(n *configNode, path string) *tableReader {
+	if n.kind != nodeObject {
+		d.errorf(n, path, "want a table, got %s", n.kind)
+		return nil
+	}
+	return &tableReader{d: d, n: n, path: path, used: map[string]bool{}}
+}
+
+func (t *tableReader) field(key string, kind nodeKind) *configNode {
+	v, ok := t.n.fields[key]
+	if !ok {
+		return nil
+	}
+	t.used[key] = true
+	if v.kind != kind {
+		t.d.errorf(v, t.sub(key), "want a %s, got %s", kind, v.kind)
+		return nil
+	}
+	return v
+}
+
+func (t *tableReader) sub(key string) string {
+	if t.path == "" {
+		return key
+	}
+	return t.path + "." + key
+}
+
+func (t *tableReader) str(key string, dst *string) {
+	if v := t.field(key, nodeString); v != nil {
+		*dst = v.str
+	}
+}
+
+func (t *tableReader) int(key string, dst *int) {
+	if v := t.field(key, nodeNumber); v != nil {
+		if v.num != math.Trunc(v.num) || math.Abs(v.num) > math.MaxInt32 {
+			t.d.errorf(v, t.sub(key), "want an integer, got %s", v.str)
+			return
+		}
+		*dst = int(v.num)
+	}
+}
+
+func (t *tableReader) bool(key string, dst *bool) {
+	if v := t.field(key, nodeBool); v != nil {
+		*dst = v.b
+	}
+}
+
+func (t *tableReader) duration(key string, dst *time.Duration) {
+	if v := t.field(key, nodeString); v != nil {
+		dur, err := time.ParseDuration(v.str)
+		if err != nil || dur < 0 {
+			t.d.errorf(v, t.sub(key), "invalid duration %q", v.str)
+			return
+		}
+		*dst = dur
+	}
+}
+
+func (t *tableReader) strings(key string, dst *[]string) {
+	v := t.field(key, nodeArray)
+	if v == nil {
+		return
+	}
+	for i, item := range v.items {
+		if item.kind != nodeString {
+			t.d.errorf(item, fmt.Sprintf("%s[%d]", t.sub(key), i), "want a string, got %s", item.kind)
+			continue
+		}
+		*dst = append(*dst, item.str)
+	}
+}
+
+func (t *tableReader) table(key string) *tableReader {
+	v, ok := t.n.fields[key]
+	if !ok {
+		return nil
+	}
+	t.used[key] = true
+	return t.d.table(v, t.sub(key))
+}
+
+// done 报告未知的键，拼写错误的配置项不会被静默忽略
+func (t *tableReader) done() {
+	for _, key := range t.n.keys {
+		if !t.used[key] {
+			t.d.errorf(t.n.fields[key], t.sub(key), "unknown key")
+		}
+	}
+}
+
+func (t *tableReader) line(key string) int {
+	if v, ok := t.n.fields[key]; ok {
+		return v.line
+	}
+	return t.n.line
+}
+
+var serviceNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+func (d *configDecoder) decode(root *configNode) *Config {
+	cfg := &Config{
+		File:   d.file,
+		Kernel: KernelConfig{QueueSize: DefaultQueueSize},
+		Store:  StoreConfig{Backend: StoreFile, Path: "./state"},
+	}
+	top := d.table(root, "")
+	if kt := top.table("kernel"); kt != nil {
+		kt.int("queue_size", &cfg.Kernel.QueueSize)
+		if cfg.Kernel.QueueSize < 1 {
+			d.errorf(&configNode{line: kt.line("queue_size")}, "kernel.queue_size", "must be at least 1")
+		}
+		if pt := kt.table("persist"); pt != nil {
+			cfg.Kernel.Persist = d.persist(pt)
+		}
+		kt.done()
+	}
+	if st := top.table("store"); st != nil {
+		st.str("backend", &cfg.Store.Backend)
+		st.str("path", &cfg.Store.Path)
+		st.int("generations", &cfg.Store.Generations)
+		switch cfg.Store.Backend {
+		case StoreFile, StoreLog:
+			if cfg.Store.Path == "" {
+				d.errorf(st.n, "store.path", "required for backend %q", cfg.Store.Backend)
+			}
+		case StoreMemory:
+		default:
+			d.errorf(st.n.fields["backend"], "store.backend", "unknown backend %q, want file, log or memory", cfg.Store.Backend)
+		}
+		if cfg.Store.Generations < 0 || cfg.Store.Generations > 0 && cfg.Store.Backend != StoreFile {
+			d.errorf(st.n, "store.generations", "must be positive and only applies to the file backend")
+		}
+		st.done()
+	}
+	if kt := top.table("key"); kt != nil {
+		cfg.keyLine = kt.n.line
+		cfg.Key = d.key(kt, cfg.Store)
+	}
+	if v := top.field("services", nodeArray); v != nil {
+		for i, item := range v.items {
+			if sc, ok := d.service(item, fmt.Sprintf("services[%d]", i)); ok {
+				cfg.Services = append(cfg.Services, sc)
+			}
+		}
+	}
+	top.done()
+	d.checkServices(cfg.Services)
+	return cfg
+}
+
+func (d *configDecoder) key(t *tableReader, store StoreConfig) KeyConfig {
+	kc := KeyConfig{Mode: KeyAES}
+	t.str("mode", &kc.Mode)
+	t.str("source", &kc.Source)
+	t.str("active", &kc.Active)
+	t.str("master", &kc.Master)
+	t.str("public_key", &kc.PublicKey)
+	t.str("dir", &kc.Dir)
+	source := func(key, spec string) {
+		if _, err := ParseKeySource(spec); err != nil {
+			d.errorf(&configNode{line: t.line(key)}, t.sub(key), "%v", err)
+		}
+	}
+	if kc.Source != "" {
+		source("source", kc.Source)
+	}
+	// only 报告当前设置下不使用的键
+	only := func(where string, keys ...string) {
+		for _, key := range keys {
+			if _, ok := t.n.fields[key]; ok {
+				d.errorf(&configNode{line: t.line(key)}, t.sub(key), "only applies to %s", where)
+			}
+		}
+	}
+	if v := t.field("keys", nodeArray); v != nil {
+		seen := make(map[string]bool)
+		for i, item := range v.items {
+			et := d.table(item, fmt.Sprintf("key.keys[%d]", i))
+			if et == nil {
+				continue
+			}
+			var e KeyringEntry
+			et.str("id", &e.ID)
+			et.str("source", &e.Source)
+			et.done()
+			switch {
+			case e.ID == "" || len(e.ID) > 255:
+				d.errorf(item, et.sub("id"), "invalid key id %q", e.ID)
+			case seen[e.ID]:
+				d.errorf(item, et.sub("id"), "duplicate key id %q", e.ID)
+			}
+			seen[e.ID] = true
+			if e.Source == "" {
+				d.errorf(item, et.sub("source"), "required")
+			} else if _, err := ParseKeySource(e.Source); err != nil {
+				d.errorf(&configNode{line: et.line("source")}, et.sub("source"), "%v", err)
+			}
+			kc.Keys = append(kc.Keys, e)
+		}
+	}
+	switch kc.Mode {
+	case KeyAES:
+		only(`mode "keyring"`, "keys", "active")
+		only(`mode "envelope"`, "master", "public_key", "dir")
+	case KeyKeyring:
+		only(`mode "aes" and "envelope", set keys[].source`, "source")
+		only(`mode "envelope"`, "master", "public_key", "dir")
+		if len(kc.Keys) == 0 {
+			d.errorf(t.n, t.sub("keys"), "required for mode %q", KeyKeyring)
+		}
+		if kc.Active == "" && len(kc.Keys) > 0 {
+			kc.Active = kc.Keys[0].ID
+		}
+		if !slices.ContainsFunc(kc.Keys, func(e KeyringEntry) bool { return e.ID == kc.Active }) && len(kc.Keys) > 0 {
+			d.errorf(&configNode{line: t.line("active")}, t.sub("active"), "unknown key id %q", kc.Active)
+		}
+	case KeyEnvelope:
+		only(`mode "keyring"`, "keys", "active")
+		switch kc.Master {
+		case "":
+			kc.Master = MasterAES
+			fallthrough
+		case MasterAES:
+			only(`master "x25519"`, "public_key")
+		case MasterX25519:
+			if kc.Source != "" && kc.PublicKey != "" {
+				d.errorf(&configNode{line: t.line("public_key")}, t.sub("public_key"), "set either source or public_key")
+			}
+		default:
+			d.errorf(&configNode{line: t.line("master")}, t.sub("master"), "unknown master key %q, want aes or x25519", kc.Master)
+		}
+		if kc.Dir == "" {
+			switch store.Backend {
+			case StoreFile:
+				kc.Dir = filepath.Join(store.Path, "keys")
+			case StoreLog:
+				kc.Dir = store.Path + ".keys"
+			default:
+				d.errorf(t.n, t.sub("dir"), "required for backend %q", store.Backend)
+			}
+		}
+	default:
+		d.errorf(&configNode{line: t.line("mode")}, t.sub("mode"), "unknown mode %q, want aes, keyring or envelope", kc.Mode)
+	}
+	t.done()
+	return kc
+}
+
+func (d *configDecoder) service(n *configNode, path string) (ServiceConfig, bool) {
+	t := d.table(n, path)
+	if t == nil {
+		return ServiceConfig{}, false
+	}
+	sc := ServiceConfig{Line: n.line}
+	t.str("name", &sc.Name)
+	t.str("type", &sc.Type)
+	t.strings("deps", &sc.Deps)
+	if !serviceNamePattern.MatchString(sc.Name) {
+		d.errorf(&configNode{line: t.line("name")}, path+".name", "invalid service name %q", sc.Name)
+	}
+	if sc.Type == "" {
+		d.errorf(n, path+".type", "required")
+	}
+	params := t.field("params", nodeObject)
+	if params != nil {
+		sc.Params = params.toAny().(map[string]any)
+	}
+	if sc.Type != "" {
+		d.serviceType(t, params, sc)
+	}
+	if rt := t.table("restart"); rt != nil {
+		sc.Restart = d.restart(rt)
+	}
+	if pt := t.table("persist"); pt != nil {
+		sc.Persist = d.persist(pt)
+	}
+	t.done()
+	return sc, true
+}
+
+// serviceType 按注册表检查服务类型存在、参数已声明且类型正确，参数错误报告在参数所在的行
+func (d *configDecoder) serviceType(t *tableReader, params *configNode, sc ServiceConfig) {
+	if d.registry == nil {
+		return
+	}
+	st, ok := d.registry.Lookup(sc.Type)
+	if !ok {
+		d.errorf(&configNode{line: t.line("type")}, t.sub("type"), "unknown service type %q", sc.Type)
+		return
+	}
+	_, err := st.params(sc.Params)
+	if err == nil {
+		return
+	}
+	for _, err := range err.(interface{ Unwrap() []error }).Unwrap() {
+		pe := err.(*paramError)
+		n := t.n
+		if params != nil {
+			n = params
+			if v, ok := params.fields[pe.name]; ok {
+				n = v
+			}
+		}
+		d.errorf(n, t.sub("params."+pe.name), "%s", pe.msg)
+	}
+}
+
+func (d *configDecoder) persist(t *tableReader) *PersistPolicy {
+	policy := DefaultPersistPolicy
+	mode := policy.Mode.String()
+	t.str("mode", &mode)
+	t.duration("interval", &policy.Interval)
+	t.duration("debounce", &policy.Debounce)
+	t.bool("track_dirty", &policy.TrackDirty)
+	t.done()
+	for m := PersistInterval; m <= PersistNever; m++ {
+		if m.String() == mode {
+			policy.Mode = m
+			if m == PersistInterval && policy.Interval <= 0 {
+				d.errorf(t.n, t.sub("interval"), "must be positive")
+			}
+			return &policy
+		}
+	}
+	d.errorf(&configNode{line: t.line("mode")}, t.sub("mode"), "unknown mode %q, want interval, on-change, on-stop or never", mode)
+	return &policy
+}
+
+func (d *configDecoder) restart(t *tableReader) *RestartPolicy {
+	var policy RestartPolicy
+	mode := policy.Mode.String()
+	t.str("policy", &mode)
+	t.int("max_restarts", &policy.MaxRestarts)
+	t.duration("backoff", &policy.Backoff)
+	t.done()
+	if policy.MaxRestarts < 0 {
+		d.errorf(&configNode{line: t.line("max_restarts")}, t.sub("max_restarts"), "must not be negative")
+	}
+	switch mode {
+	case RestartNever.String():
+		policy.Mode = RestartNever
+	case RestartOnFailure.String():
+		policy.Mode = RestartOnFailure
+	default:
+		d.errorf(&configNode{line: t.line("policy")}, t.sub("policy"), "unknown restart policy %q, want never or on-failure", mode)
+	}
+	return &policy
+}
+
+// checkServices 检查服务名称唯一、依赖存在且没有循环
+func (d *configDecoder) checkServices(services []ServiceConfig) {
+	byName := make(map[string]ServiceConfig, len(services))
+	for _, sc := range services {
+		if prev, dup := byName[sc.Name]; dup {
+			d.errorf(&configNode{line: sc.Line}, "services", "service %q already declared at line %d", sc.Name, prev.Line)
+			continue
+		}
+		byName[sc.Name] = sc
+	}
+	for _, sc := range services {
+		for _, dep := range sc.Deps {
+			if _, ok := byName[dep]; !ok {
+				d.errorf(&configNode{line: sc.Line}, "services."+sc.Name+".deps", "unknown service %q", dep)
+			}
+			if dep == sc.Name {
+				d.errorf(&configNode{line: sc.Line}, "services."+sc.Name+".deps", "service depends on itself")
+			}
+		}
+	}
+	// 深度优先检查配置中声明的依赖是否有循环
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var visit func(name string, stack []string) bool
+	visit = func(name string, stack []string) bool {
+		switch state[name] {
+		case visiting:
+			sc := byName[name]
+			d.errorf(&configNode{line: sc.Line}, "services."+name+".deps", "dependency cycle: %s", strings.Join(append(stack, name), " -> "))
+			return false
+		case visited:
+			return true
+		}
+		state[name] = visiting
+		for _, dep := range byName[name].Deps {
+			if _, ok := byName[dep]; ok && dep != name && !visit(dep, append(stack, name)) {
+				return false
+			}
+		}
+		state[name] = visited
+		return true
+	}
+	for _, sc := range services {
+		if state[sc.Name] == unvisited && !visit(sc.Name, nil) {
+			return
+		}
+	}
+}
+
+// BuildFunc 根据服务声明创建服务
+type BuildFunc func(k *MicroKernel, sc ServiceConfig) (Service, error)
+
+// Runtime 由配置构建的内核及其状态存储和加密器
+type Runtime struct {
+	Kernel  *MicroKernel
+	Store   StateStore
+	Crypter Crypter
+
+	// 串行化重载，保护 config
+	mu sync.Mutex
+	// 当前生效的配置
+	config *Config
+	build  BuildFunc
+	// 服务按类型从注册表创建时用于校验 Reconfigure 的参数，自定义 BuildFunc 时为 nil
+	registry *ServiceRegistry
+}
+
+// Close 关闭状态存储并清除密钥
+func (r *Runtime) Close() error {
+	var errs []error
+	if c, ok := r.Store.(interface{ Close() error }); ok {
+		errs = append(errs, c.Close())
+	}
+	if c, ok := r.Crypter.(interface{ Close() error }); ok {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Apply 按配置创建状态存储和内核，使用 build 创建并注册服务，设置依赖、重启和持久化策略
+// build 为 nil 时按服务类型从 DefaultServiceRegistry 创建；服务未启动，由调用方 StartAll
+func (c *Config) Apply(build BuildFunc) (*Runtime, error) {
+	rt := &Runtime{config: c, build: build}
+	if build == nil {
+		rt.registry = DefaultServiceRegistry
+		rt.build = rt.registry.BuildFunc()
+	}
+	if err := c.openStore(rt); err != nil {
+		return nil, err
+	}
+	k := NewMicroKernelWithQueue(rt.Store, c.Kernel.QueueSize)
+	rt.Kernel = k
+	if c.Kernel.Persist != nil {
+		k.SetDefaultPersistPolicy(*c.Kernel.Persist)
+	}
+	for _, sc := range c.Services {
+		svc, err := rt.newService(sc)
+		if err == nil {
+			err = rt.register(svc, sc)
+		}
+		if err != nil {
+			rt.Close()
+			return nil, c.serviceError(sc, err)
+		}
+	}
+	return rt, nil
+}
+
+// serviceError 带有服务声明位置的错误
+func (c *Config) serviceError(sc ServiceConfig, err error) error {
+	return &ConfigError{File: c.File, Line: sc.Line, Path: "services." + sc.Name, Msg: err.Error()}
+}
+
+// newService 按服务声明创建服务
+func (rt *Runtime) newService(sc ServiceConfig) (Service, error) {
+	svc, err := rt.build(rt.Kernel, sc)
+	if err != nil {
+		return nil, err
+	}
+	if svc.Name() != sc.Name {
+		return nil, fmt.Errorf("type %s built service named %q", sc.Type, svc.Name())
+	}
+	return svc, nil
+}
+
+// register 注册服务并设置依赖、重启和持久化策略
+func (rt *Runtime) register(svc Service, sc ServiceConfig) error {
+	// 持久化策略在注册前设置，Register 导入状态后立即生效
+	if sc.Persist != nil {
+		rt.Kernel.SetPersistPolicy(sc.Name, *sc.Persist)
+	}
+	if err := rt.Kernel.Register(svc); err != nil {
+		return err
+	}
+	rt.applyPolicies(svc, sc)
+	return nil
+}
+
+// applyPolicies 按服务声明设置已注册服务的依赖、重启和持久化策略，声明中省略的策略恢复为默认
+func (rt *Runtime) applyPolicies(svc Service, sc ServiceConfig) {
+	k := rt.Kernel
+	k.setDependencies(sc.Name, sc.Deps...)
+	restart := RestartPolicy{}
+	if sc.Restart != nil {
+		restart = *sc.Restart
+	}
+	k.SetRestartPolicy(sc.Name, restart)
+	if sc.Persist != nil {
+		k.SetPersistPolicy(sc.Name, *sc.Persist)
+	} else {
+		k.clearPersistPolicy(svc)
+	}
+}
+
+// NewCrypter 按 [key] 创建状态加密器，调用方不再使用时调用其 Close 清除密钥
+// memory 后端没有声明密钥时使用一次性的随机密钥，只用于热替换时加密传递中的状态
+func (c *Config) NewCrypter() (Crypter, error) {
+	var crypter Crypter
+	var err error
+	switch c.Key.Mode {
+	case KeyKeyring:
+		crypter, err = c.newKeyring()
+	case KeyEnvelope:
+		crypter, err = c.newEnvelopeCrypter()
+	default:
+		if c.Store.Backend == StoreMemory && c.Key.Source == "" {
+			return newEphemeralCrypter()
+		}
+		var p KeyProvider
+		if p, err = keyProvider(c.Key.Source); err == nil {
+			crypter, err = NewAESCrypterFromProvider(p)
+		}
+	}
+	if err != nil {
+		return nil, c.keyError(err)
+	}
+	return crypter, nil
+}
+
+// keyProvider 解析密钥来源，为空时使用 DefaultKeyProvider
+func keyProvider(spec string) (KeyProvider, error) {
+	if spec == "" {
+		return DefaultKeyProvider(), nil
+	}
+	return ParseKeySource(spec)
+}
+
+// readKey 从密钥来源读取密钥，调用方用完后 Zero
+func readKey(spec string) ([]byte, error) {
+	p, err := keyProvider(spec)
+	if err != nil {
+		return nil, err
+	}
+	return p.Key()
+}
+
+func (c *Config) newKeyring() (*Keyring, error) {
+	kr := NewKeyring()
+	for _, e := range c.Key.Keys {
+		key, err := readKey(e.Source)
+		if err != nil {
+			kr.Close()
+			return nil, fmt.Errorf("key %s: %w", e.ID, err)
+		}
+		err = kr.Add(e.ID, key)
+		Zero(key)
+		if err != nil {
+			kr.Close()
+			return nil, err
+		}
+	}
+	if err := kr.SetActive(c.Key.Active); err != nil {
+		kr.Close()
+		return nil, err
+	}
+	return kr, nil
+}
+
+func (c *Config) newEnvelopeCrypter() (*EnvelopeCrypter, error) {
+	var master MasterKey
+	switch {
+	case c.Key.Master == MasterX25519 && c.Key.PublicKey != "":
+		pub, err := LoadX25519PublicKey(c.Key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if master, err = NewX25519RecipientKey(pub); err != nil {
+			return nil, err
+		}
+	case c.Key.Master == MasterX25519:
+		key, err := readKey(c.Key.Source)
+		if err != nil {
+			return nil, err
+		}
+		defer Zero(key)
+		priv, err := ecdh.X25519().NewPrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("x25519 master key: %w", err)
+		}
+		if master, err = NewX25519MasterKey(priv); err != nil {
+			return nil, err
+		}
+	default:
+		key, err := readKey(c.Key.Source)
+		if err != nil {
+			return nil, err
+		}
+		defer Zero(key)
+		if master, err = NewSymmetricMasterKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return NewEnvelopeCrypter(master, c.Key.Dir), nil
+}
+
+// keyError 带有 [key] 位置的密钥错误
+func (c *Config) keyError(err error) error {
+	return &ConfigError{File: c.File, Line: c.keyLine, Path: "key", Msg: err.Error()}
+}
+
+// newEphemeralCrypter 使用一次性随机密钥的加密器
+func newEphemeralCrypter() (*AESCrypter, error) {
+	key := make([]byte, 32)
+	defer Zero(key)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return NewAESCrypter(key)
+}
+
+// OpenStore 按 [store] 和 [key] 打开状态存储和加密器，不创建内核，供离线工具使用
+// 用完后调用 Runtime.Close 关闭存储并清除密钥
+func (c *Config) OpenStore() (*Runtime, error) {
+	rt := &Runtime{config: c}
+	if err := c.openStore(rt); err != nil {
+		return nil, err
+	}
+	return rt, nil
+}
+
+func (c *Config) openStore(rt *Runtime) error {
+	crypter, err := c.NewCrypter()
+	if err != nil {
+		return err
+	}
+	rt.Crypter = crypter
+	switch c.Store.Backend {
+	case StoreMemory:
+		rt.Store = NewMemoryStateStore()
+	case StoreLog:
+		store, err := NewLogStateStore(c.Store.Path, crypter)
+		if err != nil {
+			rt.Close()
+			return err
+		}
+		rt.Store = store
+	default:
+		store := NewFileStateStore(c.Store.Path, crypter)
+		if c.Store.Generations > 0 {
+			store.SetGenerations(c.Store.Generations)
+		}
+		rt.Store = store
+	}
+	return nil
+}
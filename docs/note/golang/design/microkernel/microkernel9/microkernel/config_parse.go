@@ -0,0 +1,534 @@
+package microkernel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// 配置文件先解析为带行号的节点树，再解码为 Config，
+// 这样 JSON 和 TOML 两种格式的校验错误都能指出所在的行。
+
+type nodeKind int
+
+const (
+	nodeObject nodeKind = iota
+	nodeArray
+	nodeString
+	nodeNumber
+	nodeBool
+)
+
+func (k nodeKind) String() string {
+	return [...]string{"table", "array", "string", "number", "bool"}[k]
+}
+
+// configNode 配置节点，line 为节点在文件中的行号（从 1 开始）
+type configNode struct {
+	kind nodeKind
+	line int
+	// nodeObject：按出现顺序的键
+	keys   []string
+	fields map[string]*configNode
+	// nodeArray
+	items []*configNode
+	// 标量
+	str string
+	num float64
+	b   bool
+}
+
+func newObjectNode(line int) *configNode {
+	return &configNode{kind: nodeObject, line: line, fields: make(map[string]*configNode)}
+}
+
+func (n *configNode) set(key string, v *configNode) {
+	if _, ok := n.fields[key]; !ok {
+		n.keys = append(n.keys, key)
+	}
+	n.fields[key] = v
+}
+
+// ConfigError 带文件名和行号的配置错误
+type ConfigError struct {
+	File string
+	Line int
+	Path string
+	Msg  string
+}
+
+func (e *ConfigError) Error() string {
+	loc := e.File
+	if e.Line > 0 {
+		loc = fmt.Sprintf("%s:%d", e.File, e.Line)
+	}
+	if e.Path != "" {
+		return fmt.Sprintf("%s: %s: %s", loc, e.Path, e.Msg)
+	}
+	return fmt.Sprintf("%s: %s", loc, e.Msg)
+}
+
+// parseJSONConfig 使用 json.Decoder 的 token 流构建节点树，通过偏移量计算行号
+func parseJSONConfig(file string, data []byte) (*configNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	lineAt := func(offset int64) int {
+		return bytes.Count(data[:min(int(offset), len(data))], []byte("\n")) + 1
+	}
+	// tokenLine 返回刚读取的 token 所在行：偏移量指向 token 末尾，回退到 token 本身
+	tokenLine := func() int {
+		off := dec.InputOffset() - 1
+		for off > 0 && (data[off] == ' ' || data[off] == '\n' || data[off] == '\r' || data[off] == '\t') {
+			off--
+		}
+		return lineAt(off)
+	}
+	var parse func(tok json.Token, line int) (*configNode, error)
+	parse = func(tok json.Token, line int) (*configNode, error) {
+		switch v := tok.(type) {
+		case json.Delim:
+			switch v {
+			case '{':
+				obj := newObjectNode(line)
+				for dec.More() {
+					kt, err := dec.Token()
+					if err != nil {
+						return nil, err
+					}
+					key := kt.(string)
+					keyLine := tokenLine()
+					if _, dup := obj.fields[key]; dup {
+						return nil, &ConfigError{File: file, Line: keyLine, Msg: fmt.Sprintf("duplicate key %q", key)}
+					}
+					vt, err := dec.Token()
+					if err != nil {
+						return nil, err
+					}
+					child, err := parse(vt, tokenLine())
+					if err != nil {
+						return nil, err
+					}
+					child.line = keyLine
+					obj.set(key, child)
+				}
+				_, err := dec.Token()
+				return obj, err
+			case '[':
+				arr := &configNode{kind: nodeArray, line: line}
+				for dec.More() {
+					vt, err := dec.Token()
+					if err != nil {
+						return nil, err
+					}
+					child, err := parse(vt, tokenLine())
+					if err != nil {
+						return nil, err
+					}
+					arr.items = append(arr.items, child)
+				}
+				_, err := dec.Token()
+				return arr, err
+			}
+		case string:
+			return &configNode{kind: nodeString, line: line, str: v}, nil
+		case json.Number:
+			f, err := v.Float64()
+			if err != nil {
+				return nil, err
+			}
+			return &configNode{kind: nodeNumber, line: line, num: f, str: v.String()}, nil
+		case bool:
+			return &configNode{kind: nodeBool, line: line, b: v}, nil
+		case nil:
+			return nil, &ConfigError{File: file, Line: line, Msg: "null is not allowed"}
+		}
+		return nil, fmt.Errorf("unexpected token %v", tok)
+	}
+	tok, err := dec.Token()
+	if err == nil {
+		var root *configNode
+		root, err = parse(tok, tokenLine())
+		if err == nil {
+			if root.kind != nodeObject {
+				return nil, &ConfigError{File: file, Line: root.line, Msg: "top level must be an object"}
+			}
+			if _, err := dec.Token(); err != io.EOF {
+				return nil, &ConfigError{File: file, Line: tokenLine(), Msg: "unexpected data after top-level object"}
+			}
+			return root, nil
+		}
+	}
+	var ce *ConfigError
+	if errors.As(err, &ce) {
+		return nil, err
+	}
+	var se *json.SyntaxError
+	if errors.As(err, &se) {
+		return nil, &ConfigError{File: file, Line: lineAt(se.Offset), Msg: se.Error()}
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, &ConfigError{File: file, Line: lineAt(int64(len(data))), Msg: "unexpected end of file"}
+	}
+	return nil, &ConfigError{File: file, Line: lineAt(dec.InputOffset()), Msg: err.Error()}
+}
+
+// parseTOMLConfig 解析 TOML 子集：
+//
+//   - 注释 #、键值对 key = value、点分隔的键 a.b = value
+//   - 表 [a.b] 和表数组 [[services]]
+//   - 值：基本字符串 "..."、字面字符串 '...'、整数、浮点数、true/false、
+//     数组 [...]（可以跨行）和内联表 {k = v, ...}
+type tomlParser struct {
+	file  string
+	lines []string
+	line  int // 当前行号（从 1 开始）
+	col   int // 当前行内的位置
+}
+
+func parseTOMLConfig(file string, data []byte) (*configNode, error) {
+	p := &tomlParser{file: file, lines: strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")}
+	root := newObjectNode(1)
+	current := root
+	// 显式定义过的表，重复定义报错
+	defined := map[*configNode]bool{}
+	for p.line = 1; p.line <= len(p.lines); p.line++ {
+		p.col = 0
+		p.skipSpace()
+		if p.eol() {
+			continue
+		}
+		text := p.rest()
+		switch {
+		case strings.HasPrefix(text, "[["):
+			end := strings.Index(text, "]]")
+			if end < 0 {
+				return nil, p.errorf("unterminated table array header")
+			}
+			path, err := p.splitKey(text[2:end])
+			if err != nil {
+				return nil, err
+			}
+			p.col += end + 2
+			if err := p.expectEnd(); err != nil {
+				return nil, err
+			}
+			parent, err := p.walk(root, path[:len(path)-1])
+			if err != nil {
+				return nil, err
+			}
+			last := path[len(path)-1]
+			arr, ok := parent.fields[last]
+			if !ok {
+				arr = &configNode{kind: nodeArray, line: p.line}
+				parent.set(last, arr)
+			} else if arr.kind != nodeArray {
+				return nil, p.errorf("%s is already defined as a %s", strings.Join(path, "."), arr.kind)
+			}
+			current = newObjectNode(p.line)
+			arr.items = append(arr.items, current)
+		case strings.HasPrefix(text, "["):
+			end := strings.Index(text, "]")
+			if end < 0 {
+				return nil, p.errorf("unterminated table header")
+			}
+			path, err := p.splitKey(text[1:end])
+			if err != nil {
+				return nil, err
+			}
+			p.col += end + 1
+			if err := p.expectEnd(); err != nil {
+				return nil, err
+			}
+			if current, err = p.walk(root, path); err != nil {
+				return nil, err
+			}
+			if defined[current] {
+				return nil, p.errorf("table [%s] defined twice", strings.Join(path, "."))
+			}
+			defined[current] = true
+			current.line = p.line
+		default:
+			if err := p.keyValue(current); err != nil {
+				return nil, err
+			}
+			if err := p.expectEnd(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return root, nil
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	return &ConfigError{File: p.file, Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *tomlParser) cur() string {
+	if p.line > len(p.lines) {
+		return ""
+	}
+	return p.lines[p.line-1]
+}
+
+func (p *tomlParser) rest() string { return p.cur()[p.col:] }
+
+func (p *tomlParser) eol() bool {
+	r := p.rest()
+	return r == "" || r[0] == '#'
+}
+
+func (p *tomlParser) skipSpace() {
+	for p.col < len(p.cur()) && (p.cur()[p.col] == ' ' || p.cur()[p.col] == '\t') {
+		p.col++
+	}
+}
+
+// skipBlank 跳过空白、注释和换行，用于跨行的数组
+func (p *tomlParser) skipBlank() error {
+	for {
+		p.skipSpace()
+		if !p.eol() {
+			return nil
+		}
+		if p.line >= len(p.lines) {
+			return p.errorf("unexpected end of file")
+		}
+		p.line++
+		p.col = 0
+	}
+}
+
+func (p *tomlParser) expectEnd() error {
+	p.skipSpace()
+	if !p.eol() {
+		return p.errorf("unexpected %q", p.rest())
+	}
+	return nil
+}
+
+// walk 沿路径查找或创建表；路径经过表数组时进入最后一个元素
+func (p *tomlParser) walk(node *configNode, path []string) (*configNode, error) {
+	for i, key := range path {
+		child, ok := node.fields[key]
+		if !ok {
+			child = newObjectNode(p.line)
+			node.set(key, child)
+		}
+		switch child.kind {
+		case nodeObject:
+			node = child
+		case nodeArray:
+			if len(child.items) == 0 || child.items[len(child.items)-1].kind != nodeObject {
+				return nil, p.errorf("%s is not a table", strings.Join(path[:i+1], "."))
+			}
+			node = child.items[len(child.items)-1]
+		default:
+			return nil, p.errorf("%s is already defined as a %s", strings.Join(path[:i+1], "."), child.kind)
+		}
+	}
+	return node, nil
+}
+
+// splitKey 拆分点分隔的键，支持带引号的键
+func (p *tomlParser) splitKey(s string) ([]string, error) {
+	var parts []string
+	for _, part := range strings.Split(s, ".") {
+		part = strings.TrimSpace(part)
+		if len(part) >= 2 && (part[0] == '"' && part[len(part)-1] == '"' || part[0] == '\'' && part[len(part)-1] == '\'') {
+			part = part[1 : len(part)-1]
+		} else if part == "" || strings.ContainsAny(part, " \t\"'=[]{}#") {
+			return nil, p.errorf("invalid key %q", s)
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// keyValue 解析 key = value 写入 table
+func (p *tomlParser) keyValue(table *configNode) error {
+	r := p.rest()
+	eq := strings.Index(r, "=")
+	if eq < 0 {
+		return p.errorf("expected key = value")
+	}
+	path, err := p.splitKey(r[:eq])
+	if err != nil {
+		return err
+	}
+	line := p.line
+	p.col += eq + 1
+	p.skipSpace()
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	v.line = line
+	parent, err := p.walk(table, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+	key := path[len(path)-1]
+	if _, dup := parent.fields[key]; dup {
+		return &ConfigError{File: p.file, Line: line, Msg: fmt.Sprintf("duplicate key %q", strings.Join(path, "."))}
+	}
+	parent.set(key, v)
+	return nil
+}
+
+func (p *tomlParser) value() (*configNode, error) {
+	r := p.rest()
+	if r == "" {
+		return nil, p.errorf("missing value")
+	}
+	line := p.line
+	switch {
+	case r[0] == '"':
+		s, n, err := p.basicString(r)
+		if err != nil {
+			return nil, err
+		}
+		p.col += n
+		return &configNode{kind: nodeString, line: line, str: s}, nil
+	case r[0] == '\'':
+		end := strings.IndexByte(r[1:], '\'')
+		if end < 0 {
+			return nil, p.errorf("unterminated string")
+		}
+		p.col += end + 2
+		return &configNode{kind: nodeString, line: line, str: r[1 : end+1]}, nil
+	case r[0] == '[':
+		p.col++
+		arr := &configNode{kind: nodeArray, line: line}
+		for {
+			if err := p.skipBlank(); err != nil {
+				return nil, err
+			}
+			if p.rest()[0] == ']' {
+				p.col++
+				return arr, nil
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			arr.items = append(arr.items, v)
+			if err := p.skipBlank(); err != nil {
+				return nil, err
+			}
+			switch p.rest()[0] {
+			case ',':
+				p.col++
+			case ']':
+			default:
+				return nil, p.errorf("expected , or ] in array")
+			}
+		}
+	case r[0] == '{':
+		p.col++
+		obj := newObjectNode(line)
+		for {
+			p.skipSpace()
+			if strings.HasPrefix(p.rest(), "}") {
+				p.col++
+				return obj, nil
+			}
+			if err := p.keyValue(obj); err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			switch {
+			case strings.HasPrefix(p.rest(), ","):
+				p.col++
+			case strings.HasPrefix(p.rest(), "}"):
+			default:
+				return nil, p.errorf("expected , or } in inline table")
+			}
+		}
+	}
+	// 裸值：读到分隔符为止
+	end := strings.IndexAny(r, " \t,]}#")
+	if end < 0 {
+		end = len(r)
+	}
+	word := r[:end]
+	p.col += end
+	switch word {
+	case "true", "false":
+		return &configNode{kind: nodeBool, line: line, b: word == "true"}, nil
+	}
+	f, err := strconv.ParseFloat(strings.ReplaceAll(word, "_", ""), 64)
+	if err != nil {
+		return nil, p.errorf("invalid value %q (strings must be quoted)", word)
+	}
+	return &configNode{kind: nodeNumber, line: line, num: f, str: word}, nil
+}
+
+// basicString 解析双引号字符串，返回内容和消耗的字节数
+func (p *tomlParser) basicString(r string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(r); i++ {
+		c := r[i]
+		switch c {
+		case '"':
+			return b.String(), i + 1, nil
+		case '\\':
+			i++
+			if i >= len(r) {
+				return "", 0, p.errorf("unterminated string")
+			}
+			switch r[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '"', '\\':
+				b.WriteByte(r[i])
+			case 'u':
+				if i+4 >= len(r) {
+					return "", 0, p.errorf("invalid unicode escape")
+				}
+				code, err := strconv.ParseUint(r[i+1:i+5], 16, 32)
+				if err != nil {
+					return "", 0, p.errorf("invalid unicode escape")
+				}
+				b.WriteRune(rune(code))
+				i += 4
+			default:
+				return "", 0, p.errorf("invalid escape \\%c", r[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, p.errorf("unterminated string")
+}
+
+// toAny 把节点转换为普通的 Go 值（map[string]any、[]any、string、float64、bool），用于服务参数
+func (n *configNode) toAny() any {
+	switch n.kind {
+	case nodeObject:
+		m := make(map[string]any, len(n.fields))
+		for k, v := range n.fields {
+			m[k] = v.toAny()
+		}
+		return m
+	case nodeArray:
+		a := make([]any, len(n.items))
+		for i, v := range n.items {
+			a[i] = v.toAny()
+		}
+		return a
+	case nodeString:
+		return n.str
+	case nodeNumber:
+		return n.num
+	default:
+		return n.b
+	}
+}
@@ -0,0 +1,84 @@
+package microkernel
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseConfigServiceTypes(t *testing.T) {
+	data := `[store]
+backend = "memory"
+
+[[services]]
+name = "a"
+type = "counter"
+params = { name = "a", version = "two", colour = "red" }
+
+[[services]]
+name = "b"
+type = "nope"
+
+[[services]]
+name = "c"
+type = "counter"
+[services.params]
+version = 2
+`
+	_, err := ParseConfig("kernel.toml", []byte(data))
+	if err == nil {
+		t.Fatal("ParseConfig succeeded")
+	}
+	// 全部错误一次报告，每个错误带有出错的键所在的行
+	want := []string{
+		`kernel.toml:7: services[0].params.version: want int, got string`,
+		`kernel.toml:7: services[0].params.colour: unknown parameter for service type counter`,
+		`kernel.toml:11: services[1].type: unknown service type "nope"`,
+		`kernel.toml:16: services[2].params.name: required`,
+	}
+	for _, w := range want {
+		if !strings.Contains(err.Error(), w) {
+			t.Errorf("missing %q in\n%v", w, err)
+		}
+	}
+	var ce *ConfigError
+	if !errors.As(err, &ce) {
+		t.Fatalf("err = %T, want *ConfigError", err)
+	}
+
+	// 自定义 BuildFunc 不使用注册表，不校验服务类型
+	if _, err := ParseConfigWith("kernel.toml", []byte(data), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"unterminated string", "[store]\nbackend = \"memory\n", `kernel.toml:2: unterminated string`},
+		{"duplicate key", "[kernel]\nqueue_size = 1\nqueue_size = 2\n", `kernel.toml:3: duplicate key "queue_size"`},
+		{"table defined twice", "[store]\nbackend = \"memory\"\n\n[store]\n", `kernel.toml:4: table [store] defined twice`},
+		{"unquoted value", "[store]\nbackend = memory\n", `kernel.toml:2: invalid value "memory" (strings must be quoted)`},
+		{"multi-line array", "[[services]]\nname = \"a\"\ndeps = [\n  \"b\",\n  \"c\" \"d\",\n]\n", `kernel.toml:5: expected , or ] in array`},
+		{"unterminated array", "[[services]]\ndeps = [\n  \"b\",\n", `kernel.toml:4: unexpected end of file`},
+		{"trailing junk", "[store] x\n", `kernel.toml:1: unexpected "x"`},
+		{"unterminated header", "\n[kernel\n", `kernel.toml:2: unterminated table header`},
+		{"unterminated inline table", "[[services]]\nparams = { name = \"a\"\n", `kernel.toml:2: expected , or } in inline table`},
+		{"key redefined as table", "kernel = 1\n[kernel]\n", `kernel.toml:2: kernel is already defined as a number`},
+		{"missing value", "[kernel]\nqueue_size =\n", `kernel.toml:2: missing value`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConfig("kernel.toml", []byte(tt.data))
+			if err == nil {
+				t.Fatal("ParseConfig succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v\nwant %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -1,76 +1,370 @@
 package microkernel
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
+	"sync"
 )
 
+// Crypter 状态加解密
+// aad 作为附加认证数据绑定到密文，解密时必须提供相同的 aad
 type Crypter interface {
-	Encrypt(data any) ([]byte, error)
-	Decrypt(cipher []byte) (any, error)
+	Encrypt(data any, aad StateAAD) ([]byte, error)
+	Decrypt(cipher []byte, aad StateAAD) (any, error)
 }
 
-type AESCrypter struct {
-	key []byte // 16/24/32 字节
+// Sealer 字节级加解密，密钥轮换等需要直接处理密文的场景使用
+// AESCrypter 和 Keyring 都实现了该接口
+type Sealer interface {
+	Seal(plaintext []byte, aad StateAAD) ([]byte, error)
+	Open(ciphertext []byte, aad StateAAD) ([]byte, error)
 }
 
-func NewAESCrypter(key []byte) *AESCrypter {
-	return &AESCrypter{key: key}
+// StateAAD 绑定到状态密文的身份信息
+// 把 logger.state 复制为 echo.state，或把其他存储的状态文件复制过来，解密都会失败
+type StateAAD struct {
+	StoreID string
+	Service string
+	Version int
 }
 
-func (a *AESCrypter) Encrypt(data any) ([]byte, error) {
-	plaintext, err := json.Marshal(data)
-	if err != nil {
-		return nil, err
+// Bytes 附加认证数据的编码，各字段以 0 分隔
+func (a StateAAD) Bytes() []byte {
+	return fmt.Appendf(nil, "microkernel-state\x00%s\x00%s\x00%d", a.StoreID, a.Service, a.Version)
+}
+
+func (a StateAAD) String() string {
+	return fmt.Sprintf("store=%s service=%s version=%d", a.StoreID, a.Service, a.Version)
+}
+
+// 密文格式：
+//
+//	"MKC" | 版本(1 字节) | 密钥 ID 长度(1 字节) | 密钥 ID | nonce | 密文
+//
+// 附加认证数据为 文件头 + StateAAD。
+// 没有该文件头的密文为最早的格式：nonce | 密文。
+// 旧格式仍然可以解密，密钥轮换（FileStateStore.Rotate）会把它升级为带文件头的格式。
+// 旧格式不绑定服务和存储，存储的严格模式（见 FileStateStore.SetStrictAAD）拒绝解密它
+var cipherMagic = []byte("MKC")
+
+// cipherVersion 密文格式版本，从 2 开始
+const cipherVersion = 2
+
+// ErrUnknownKey 密文使用的密钥不在密钥环中
+var ErrUnknownKey = errors.New("unknown key id")
+
+// ErrIntegrity 密文认证失败：被篡改，或者不属于该服务/存储（被替换或重放）
+var ErrIntegrity = errors.New("state integrity check failed")
+
+// ErrUnboundCiphertext 密文格式没有绑定附加认证数据，严格模式下拒绝解密
+var ErrUnboundCiphertext = errors.New("ciphertext is not bound to the state identity")
+
+// BindsAAD 密文格式是否绑定了附加认证数据：MKC 和信封加密格式（MKE）
+func BindsAAD(ciphertext []byte) bool {
+	if _, ok := parseCipherHeader(ciphertext); ok {
+		return true
+	}
+	_, _, _, ok := parseEnvelopeCipher(ciphertext)
+	return ok
+}
+
+// requireAAD 严格模式下检查密文格式
+func requireAAD(ciphertext []byte, aad StateAAD) error {
+	if BindsAAD(ciphertext) {
+		return nil
+	}
+	return fmt.Errorf("%w for %s: %w", ErrIntegrity, aad, ErrUnboundCiphertext)
+}
+
+// KeyID 根据密钥内容计算默认的密钥 ID
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// CiphertextKeyID 返回密文头中的密钥 ID，旧格式密文返回 false
+func CiphertextKeyID(ciphertext []byte) (string, bool) {
+	h, ok := parseCipherHeader(ciphertext)
+	return h.id, ok
+}
+
+type cipherHeader struct {
+	id   string
+	raw  []byte // 文件头原始字节
+	body []byte // nonce | 密文
+}
+
+func parseCipherHeader(ciphertext []byte) (cipherHeader, bool) {
+	n := len(cipherMagic)
+	if len(ciphertext) < n+2 || !bytes.Equal(ciphertext[:n], cipherMagic) {
+		return cipherHeader{}, false
+	}
+	if ciphertext[n] != cipherVersion {
+		return cipherHeader{}, false
 	}
-	block, err := aes.NewCipher(a.key)
+	end := n + 2 + int(ciphertext[n+1])
+	if len(ciphertext) < end {
+		return cipherHeader{}, false
+	}
+	return cipherHeader{
+		id:   string(ciphertext[n+2 : end]),
+		raw:  ciphertext[:end],
+		body: ciphertext[end:],
+	}, true
+}
+
+// additionalData 文件头 + StateAAD
+func (h cipherHeader) additionalData(aad StateAAD) []byte {
+	return append(bytes.Clone(h.raw), aad.Bytes()...)
+}
+
+func (h cipherHeader) open(key []byte, aad StateAAD) ([]byte, error) {
+	plaintext, err := openWithKey(key, h.body, h.additionalData(aad))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w for %s: %v", ErrIntegrity, aad, err)
 	}
-	aesgcm, err := cipher.NewGCM(block)
+	return plaintext, nil
+}
+
+func sealWithKey(id string, key, plaintext []byte, aad StateAAD) ([]byte, error) {
+	if len(id) > 255 {
+		return nil, fmt.Errorf("key id too long")
+	}
+	aesgcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
+	header := make([]byte, 0, len(cipherMagic)+2+len(id))
+	header = append(header, cipherMagic...)
+	header = append(header, cipherVersion, byte(len(id)))
+	header = append(header, id...)
 
 	nonce := make([]byte, aesgcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
-
-	ciphertext := aesgcm.Seal(nonce, nonce, plaintext, nil)
-	return ciphertext, nil
+	ad := append(bytes.Clone(header), aad.Bytes()...)
+	out := append(header, nonce...)
+	return aesgcm.Seal(out, nonce, plaintext, ad), nil
 }
 
-func (a *AESCrypter) Decrypt(ciphertext []byte) (any, error) {
-	block, err := aes.NewCipher(a.key)
-	if err != nil {
-		return nil, err
-	}
-	aesgcm, err := cipher.NewGCM(block)
+// openWithKey 解密 nonce | 密文
+func openWithKey(key, body, ad []byte) ([]byte, error) {
+	aesgcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
-
 	nonceSize := aesgcm.NonceSize()
-	if len(ciphertext) < nonceSize {
+	if len(body) < nonceSize {
 		return nil, fmt.Errorf("ciphertext too short")
 	}
+	return aesgcm.Open(nil, body[:nonceSize], body[nonceSize:], ad)
+}
 
-	nonce := ciphertext[:nonceSize]
-	cipherData := ciphertext[nonceSize:]
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
 
-	plaintext, err := aesgcm.Open(nil, nonce, cipherData, nil)
+func encryptJSON(s Sealer, data any, aad StateAAD) ([]byte, error) {
+	plaintext, err := json.Marshal(data)
 	if err != nil {
 		return nil, err
 	}
+	return s.Seal(plaintext, aad)
+}
 
+func decryptJSON(s Sealer, ciphertext []byte, aad StateAAD) (any, error) {
+	plaintext, err := s.Open(ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
 	var result any
 	if err := json.Unmarshal(plaintext, &result); err != nil {
 		return nil, err
 	}
 	return result, nil
 }
+
+type AESCrypter struct {
+	key []byte // 16/24/32 字节
+	id  string
+}
+
+// NewAESCrypter 创建加密器，密钥长度必须为 16/24/32 字节
+// 加密器持有密钥的副本，调用方可以立即清除自己的密钥；不再使用时调用 Close 清除副本
+func NewAESCrypter(key []byte) (*AESCrypter, error) {
+	if err := checkKeyLen(key); err != nil {
+		return nil, err
+	}
+	return &AESCrypter{key: bytes.Clone(key), id: KeyID(key)}, nil
+}
+
+// Close 清除加密器持有的密钥
+func (a *AESCrypter) Close() error {
+	Zero(a.key)
+	return nil
+}
+
+func (a *AESCrypter) Encrypt(data any, aad StateAAD) ([]byte, error) {
+	return encryptJSON(a, data, aad)
+}
+
+func (a *AESCrypter) Decrypt(ciphertext []byte, aad StateAAD) (any, error) {
+	return decryptJSON(a, ciphertext, aad)
+}
+
+func (a *AESCrypter) Seal(plaintext []byte, aad StateAAD) ([]byte, error) {
+	return sealWithKey(a.id, a.key, plaintext, aad)
+}
+
+func (a *AESCrypter) Open(ciphertext []byte, aad StateAAD) ([]byte, error) {
+	h, ok := parseCipherHeader(ciphertext)
+	if !ok {
+		// 最早的格式，没有文件头
+		return openWithKey(a.key, ciphertext, nil)
+	}
+	if h.id != a.id {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, h.id)
+	}
+	return h.open(a.key, aad)
+}
+
+// Keyring 多密钥加密器：使用当前密钥加密，使用任意已知密钥解密
+// 更换密钥时添加新密钥并设为当前密钥，旧密钥保留用于解密，
+// 状态全部重新加密（见 FileStateStore.Rotate）后即可移除旧密钥
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[string][]byte
+	active string
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string][]byte)}
+}
+
+// Add 添加密钥（保存副本），第一个添加的密钥自动成为当前密钥
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" || len(id) > 255 {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+	if err := checkKeyLen(key); err != nil {
+		return fmt.Errorf("key %s: %w", id, err)
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if old, ok := k.keys[id]; ok {
+		Zero(old)
+	}
+	k.keys[id] = bytes.Clone(key)
+	if k.active == "" {
+		k.active = id
+	}
+	return nil
+}
+
+// Remove 移除不再使用的密钥，当前密钥不能移除
+func (k *Keyring) Remove(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.active {
+		return fmt.Errorf("cannot remove active key %s", id)
+	}
+	Zero(k.keys[id])
+	delete(k.keys, id)
+	return nil
+}
+
+// Close 清除所有密钥
+func (k *Keyring) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for id, key := range k.keys {
+		Zero(key)
+		delete(k.keys, id)
+	}
+	k.active = ""
+	return nil
+}
+
+// SetActive 设置加密使用的当前密钥
+func (k *Keyring) SetActive(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[id]; !ok {
+		return fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	k.active = id
+	return nil
+}
+
+// Active 返回当前密钥 ID
+func (k *Keyring) Active() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// IDs 返回所有密钥 ID
+func (k *Keyring) IDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	ids := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (k *Keyring) Encrypt(data any, aad StateAAD) ([]byte, error) {
+	return encryptJSON(k, data, aad)
+}
+
+func (k *Keyring) Decrypt(ciphertext []byte, aad StateAAD) (any, error) {
+	return decryptJSON(k, ciphertext, aad)
+}
+
+func (k *Keyring) Seal(plaintext []byte, aad StateAAD) ([]byte, error) {
+	// 复制密钥：解锁后并发的 Remove 或 Close 会原地清除密钥环中的密钥
+	k.mu.RLock()
+	id, key := k.active, bytes.Clone(k.keys[k.active])
+	k.mu.RUnlock()
+	defer Zero(key)
+	if id == "" {
+		return nil, errors.New("keyring has no active key")
+	}
+	return sealWithKey(id, key, plaintext, aad)
+}
+
+func (k *Keyring) Open(ciphertext []byte, aad StateAAD) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	h, ok := parseCipherHeader(ciphertext)
+	if ok {
+		key, found := k.keys[h.id]
+		if !found {
+			return nil, fmt.Errorf("%w %q", ErrUnknownKey, h.id)
+		}
+		return h.open(key, aad)
+	}
+	// 最早的格式没有密钥 ID，依次尝试所有密钥
+	for _, key := range k.keys {
+		if plaintext, err := openWithKey(key, ciphertext, nil); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, errors.New("no key can decrypt legacy ciphertext")
+}
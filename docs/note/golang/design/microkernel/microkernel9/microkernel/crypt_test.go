@@ -0,0 +1,94 @@
+package microkernel
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// aadVariants 与 aad 只差一个字段的附加认证数据
+func aadVariants(aad StateAAD) map[string]StateAAD {
+	store, service, version := aad, aad, aad
+	store.StoreID += "-other"
+	service.Service += "-other"
+	version.Version++
+	return map[string]StateAAD{"store": store, "service": service, "version": version}
+}
+
+func TestSealAADMismatch(t *testing.T) {
+	aad := StateAAD{StoreID: "s1", Service: "echo", Version: 2}
+	aesCrypter, err := NewAESCrypter(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aesCrypter.Close()
+	master, err := NewSymmetricMasterKey(bytes.Repeat([]byte{8}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := NewEnvelopeCrypter(master, t.TempDir())
+	defer envelope.Close()
+
+	for name, s := range map[string]Sealer{"MKC": aesCrypter, "MKE": envelope} {
+		ct, err := s.Seal([]byte("state"), aad)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !BindsAAD(ct) {
+			t.Errorf("%s: BindsAAD = false", name)
+		}
+		if pt, err := s.Open(ct, aad); err != nil || string(pt) != "state" {
+			t.Fatalf("%s: Open = %q, %v", name, pt, err)
+		}
+		for field, other := range aadVariants(aad) {
+			_, err := s.Open(ct, other)
+			// 信封加密的数据密钥按服务保存，换了服务名找不到数据密钥
+			if !errors.Is(err, ErrIntegrity) && !(name == "MKE" && field == "service" && errors.Is(err, ErrUnknownKey)) {
+				t.Errorf("%s: Open with different %s: err = %v, want ErrIntegrity", name, field, err)
+			}
+		}
+		tampered := bytes.Clone(ct)
+		tampered[len(tampered)-1] ^= 1
+		if _, err := s.Open(tampered, aad); !errors.Is(err, ErrIntegrity) {
+			t.Errorf("%s: Open tampered: err = %v, want ErrIntegrity", name, err)
+		}
+	}
+}
+
+func TestRequireAADRejectsUnboundFormats(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	aad := StateAAD{StoreID: "s1", Service: "echo", Version: 1}
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, aesgcm.NonceSize())
+	legacy := aesgcm.Seal(bytes.Clone(nonce), nonce, []byte("state"), nil)
+
+	if BindsAAD(legacy) {
+		t.Error("BindsAAD = true")
+	}
+	err = requireAAD(legacy, aad)
+	if !errors.Is(err, ErrUnboundCiphertext) || !errors.Is(err, ErrIntegrity) {
+		t.Errorf("requireAAD = %v, want ErrUnboundCiphertext", err)
+	}
+	// 旧格式在非严格模式下仍然可以解密
+	c, err := NewAESCrypter(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if pt, err := c.Open(legacy, aad); err != nil || string(pt) != "state" {
+		t.Errorf("Open = %q, %v", pt, err)
+	}
+
+	// 不绑定附加认证数据的 MKC 版本 1 不再支持
+	id := KeyID(key)
+	v1 := append(append([]byte{'M', 'K', 'C', 1, byte(len(id))}, id...), legacy...)
+	if BindsAAD(v1) {
+		t.Error("MKC v1: BindsAAD = true")
+	}
+	if _, err := c.Open(v1, aad); err == nil {
+		t.Error("MKC v1: Open succeeded")
+	}
+}
@@ -0,0 +1,111 @@
+package microkernel
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// EventDeadLetter 事件未能投递或处理，已进入死信队列
+const EventDeadLetter = "event.dead_letter"
+
+// 死信的原因
+const (
+	// DeadUnavailable 目标服务未注册或未运行
+	DeadUnavailable = "unavailable"
+	// DeadReplyTimeout 服务处理完成，但回复在超时前没有被接收
+	DeadReplyTimeout = "reply_timeout"
+	// DeadPanic 服务处理事件时 panic
+	DeadPanic = "panic"
+)
+
+// DefaultDeadLetterCapacity 死信队列默认保留的条数
+const DefaultDeadLetterCapacity = 1000
+
+// DeadLetter 一条死信
+type DeadLetter struct {
+	ID     uint64    `json:"id"`
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+	Code   int       `json:"code"`
+	Error  string    `json:"error,omitempty"`
+	// 事件的回复通道不保留
+	Event Event `json:"event"`
+}
+
+// deadLetters 有界的死信队列，满时丢弃最旧的
+type deadLetters struct {
+	mu       sync.Mutex
+	letters  []DeadLetter
+	capacity int
+	nextID   uint64
+}
+
+// deadLetter 记录死信并广播内核事件
+func (k *MicroKernel) deadLetter(evt Event, reason string, code int, err string) {
+	evt.ReplyCh = nil
+	d := &k.dead
+	d.mu.Lock()
+	d.nextID++
+	letter := DeadLetter{ID: d.nextID, Time: time.Now(), Reason: reason, Code: code, Error: err, Event: evt}
+	d.letters = append(d.letters, letter)
+	capacity := d.capacity
+	if capacity <= 0 {
+		capacity = DefaultDeadLetterCapacity
+	}
+	if n := len(d.letters) - capacity; n > 0 {
+		d.letters = append(d.letters[:0:0], d.letters[n:]...)
+	}
+	d.mu.Unlock()
+	k.metrics.deadLetters.With(reason).Inc()
+	k.emit(EventDeadLetter, fmt.Sprintf("id=%d reason=%s type=%s from=%s to=%s", letter.ID, reason, evt.Type, evt.From, evt.To))
+}
+
+// SetDeadLetterCapacity 设置死信队列保留的条数，超出的最旧死信被丢弃
+func (k *MicroKernel) SetDeadLetterCapacity(n int) {
+	d := &k.dead
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.capacity = n
+	if n > 0 && len(d.letters) > n {
+		d.letters = append(d.letters[:0:0], d.letters[len(d.letters)-n:]...)
+	}
+}
+
+// DeadLetters 返回死信的副本，最旧的在前
+func (k *MicroKernel) DeadLetters() []DeadLetter {
+	d := &k.dead
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DeadLetter(nil), d.letters...)
+}
+
+// ClearDeadLetters 清空死信队列，返回清除的条数
+func (k *MicroKernel) ClearDeadLetters() int {
+	d := &k.dead
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := len(d.letters)
+	d.letters = nil
+	return n
+}
+
+// Redeliver 把死信重新放入事件队列并从死信队列中移除；重新投递的事件没有回复通道
+func (k *MicroKernel) Redeliver(id uint64) error {
+	d := &k.dead
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, letter := range d.letters {
+		if letter.ID != id {
+			continue
+		}
+		if !k.TryPush(letter.Event) {
+			return errors.New("event queue full")
+		}
+		d.letters = append(d.letters[:i], d.letters[i+1:]...)
+		k.log.Info("dead letter redelivered", "id", id, "to", letter.Event.To, "type", letter.Event.Type)
+		return nil
+	}
+	return fmt.Errorf("dead letter %d not found", id)
+}
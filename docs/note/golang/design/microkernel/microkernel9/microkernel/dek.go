@@ -0,0 +1,522 @@
+package microkernel
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 信封加密：每个服务的状态使用独立的随机数据密钥（DEK）加密，
+// 数据密钥由主密钥包装后保存在 <keyDir>/<service>.keys。
+//
+//   - 一个数据密钥泄露只影响一个服务
+//   - 删除服务的密钥文件即可销毁其全部状态（crypto-shredding），见 EnvelopeCrypter.Shred
+//   - 主密钥可以是对称密钥，也可以是 X25519 公钥：只持有公钥的节点可以写状态但无法读回
+
+// MasterKey 包装数据密钥的主密钥
+// ad 为附加认证数据，包装后的数据密钥只能以相同的 ad 解包
+type MasterKey interface {
+	ID() string
+	Wrap(dek, ad []byte) ([]byte, error)
+	Unwrap(wrapped, ad []byte) ([]byte, error)
+}
+
+// ErrWriteOnly 主密钥只有公钥，无法解包数据密钥
+var ErrWriteOnly = errors.New("master key is write-only")
+
+// SymmetricMasterKey AES-GCM 包装数据密钥
+type SymmetricMasterKey struct {
+	key []byte
+	id  string
+}
+
+// NewSymmetricMasterKey 创建对称主密钥，持有密钥的副本
+func NewSymmetricMasterKey(key []byte) (*SymmetricMasterKey, error) {
+	if err := checkKeyLen(key); err != nil {
+		return nil, err
+	}
+	return &SymmetricMasterKey{key: bytes.Clone(key), id: KeyID(key)}, nil
+}
+
+func (m *SymmetricMasterKey) ID() string { return m.id }
+
+func (m *SymmetricMasterKey) Wrap(dek, ad []byte) ([]byte, error) {
+	return gcmSeal(m.key, dek, ad)
+}
+
+func (m *SymmetricMasterKey) Unwrap(wrapped, ad []byte) ([]byte, error) {
+	return openWithKey(m.key, wrapped, ad)
+}
+
+// Close 清除主密钥
+func (m *SymmetricMasterKey) Close() error {
+	Zero(m.key)
+	return nil
+}
+
+// X25519MasterKey 使用 X25519 密钥协商包装数据密钥（类似 ECIES）
+// 每次包装生成临时密钥对，共享密钥经 HKDF-SHA256 派生出包装密钥
+//
+//	包装格式：临时公钥(32 字节) | nonce | 密文
+//
+// 只有公钥时（NewX25519RecipientKey）只能包装，Unwrap 返回 ErrWriteOnly
+type X25519MasterKey struct {
+	pub  *ecdh.PublicKey
+	priv *ecdh.PrivateKey
+	id   string
+}
+
+// NewX25519MasterKey 使用私钥创建主密钥，可以包装和解包
+func NewX25519MasterKey(priv *ecdh.PrivateKey) (*X25519MasterKey, error) {
+	if priv.Curve() != ecdh.X25519() {
+		return nil, errors.New("master key must be an X25519 key")
+	}
+	m, err := NewX25519RecipientKey(priv.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+	m.priv = priv
+	return m, nil
+}
+
+// NewX25519RecipientKey 使用公钥创建只写主密钥
+func NewX25519RecipientKey(pub *ecdh.PublicKey) (*X25519MasterKey, error) {
+	if pub.Curve() != ecdh.X25519() {
+		return nil, errors.New("master key must be an X25519 key")
+	}
+	return &X25519MasterKey{pub: pub, id: "x25519-" + KeyID(pub.Bytes())}, nil
+}
+
+// LoadX25519PublicKey 读取 base64 编码的 X25519 公钥文件（statectl pubkey 的输出）
+func LoadX25519PublicKey(path string) (*ecdh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("public key %s: %w", path, err)
+	}
+	pub, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("public key %s: %w", path, err)
+	}
+	return pub, nil
+}
+
+// GenerateX25519MasterKey 生成新的 X25519 主密钥
+func GenerateX25519MasterKey() (*X25519MasterKey, error) {
+	priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewX25519MasterKey(priv)
+}
+
+func (m *X25519MasterKey) ID() string { return m.id }
+
+// PublicKey 返回公钥，分发给只写节点
+func (m *X25519MasterKey) PublicKey() *ecdh.PublicKey { return m.pub }
+
+func (m *X25519MasterKey) Wrap(dek, ad []byte) ([]byte, error) {
+	eph, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := eph.ECDH(m.pub)
+	if err != nil {
+		return nil, err
+	}
+	kek := m.kek(shared, eph.PublicKey().Bytes())
+	defer Zero(kek)
+	ct, err := gcmSeal(kek, dek, ad)
+	if err != nil {
+		return nil, err
+	}
+	return append(eph.PublicKey().Bytes(), ct...), nil
+}
+
+func (m *X25519MasterKey) Unwrap(wrapped, ad []byte) ([]byte, error) {
+	if m.priv == nil {
+		return nil, ErrWriteOnly
+	}
+	if len(wrapped) < 32 {
+		return nil, errors.New("wrapped key too short")
+	}
+	ephPub, err := ecdh.X25519().NewPublicKey(wrapped[:32])
+	if err != nil {
+		return nil, err
+	}
+	shared, err := m.priv.ECDH(ephPub)
+	if err != nil {
+		return nil, err
+	}
+	kek := m.kek(shared, wrapped[:32])
+	defer Zero(kek)
+	return openWithKey(kek, wrapped[32:], ad)
+}
+
+// kek 派生包装密钥，盐为 临时公钥 | 接收方公钥
+func (m *X25519MasterKey) kek(shared, ephPub []byte) []byte {
+	defer Zero(shared)
+	salt := append(bytes.Clone(ephPub), m.pub.Bytes()...)
+	return hkdfSHA256(shared, salt, []byte("microkernel dek wrap v1"), 32)
+}
+
+// hkdfSHA256 RFC 5869 HKDF，哈希为 SHA-256
+func hkdfSHA256(secret, salt, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+	defer Zero(prk)
+
+	expand := hmac.New(sha256.New, prk)
+	out := make([]byte, 0, length+sha256.Size)
+	var t []byte
+	for counter := byte(1); len(out) < length; counter++ {
+		expand.Reset()
+		expand.Write(t)
+		expand.Write(info)
+		expand.Write([]byte{counter})
+		t = expand.Sum(nil)
+		out = append(out, t...)
+	}
+	Zero(t)
+	key := make([]byte, length)
+	copy(key, out)
+	Zero(out)
+	return key
+}
+
+// gcmSeal 使用随机 nonce 加密，输出 nonce | 密文
+func gcmSeal(key, plaintext, ad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aesgcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aesgcm.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+// dataKeyEntry 密钥文件中的一个数据密钥
+type dataKeyEntry struct {
+	ID      string    `json:"id"`
+	Master  string    `json:"master"`
+	Wrapped []byte    `json:"wrapped"`
+	Created time.Time `json:"created"`
+}
+
+// dataKeyFile <service>.keys 的内容
+// 旧的数据密钥保留用于解密旧状态，新状态使用 Active 指向的密钥
+type dataKeyFile struct {
+	Active string         `json:"active"`
+	Keys   []dataKeyEntry `json:"keys"`
+}
+
+type dataKey struct {
+	id  string
+	key []byte
+}
+
+// EnvelopeCrypter 信封加密的状态加密器
+//
+//	密文格式："MKE" | 版本(1 字节) | 数据密钥 ID 长度(1 字节) | 数据密钥 ID | nonce | 密文
+//
+// 附加认证数据为 文件头 + StateAAD；数据密钥以服务名称为附加认证数据包装，不能挪给其他服务使用。
+// 只写节点重启后无法解包已有的数据密钥，会生成新的数据密钥并设为当前密钥。
+type EnvelopeCrypter struct {
+	master MasterKey
+	dir    string
+
+	mu sync.Mutex
+	// 已解包或本进程生成的数据密钥：服务名称 -> 数据密钥 ID -> 密钥
+	cache map[string]map[string][]byte
+	// 服务当前使用的数据密钥
+	active map[string]dataKey
+}
+
+// NewEnvelopeCrypter 创建信封加密器，包装后的数据密钥保存在 keyDir
+func NewEnvelopeCrypter(master MasterKey, keyDir string) *EnvelopeCrypter {
+	return &EnvelopeCrypter{
+		master: master,
+		dir:    keyDir,
+		cache:  make(map[string]map[string][]byte),
+		active: make(map[string]dataKey),
+	}
+}
+
+var envelopeCipherMagic = []byte("MKE")
+
+const envelopeCipherVersion = 1
+
+func (e *EnvelopeCrypter) Encrypt(data any, aad StateAAD) ([]byte, error) {
+	return encryptJSON(e, data, aad)
+}
+
+func (e *EnvelopeCrypter) Decrypt(ciphertext []byte, aad StateAAD) (any, error) {
+	return decryptJSON(e, ciphertext, aad)
+}
+
+func (e *EnvelopeCrypter) Seal(plaintext []byte, aad StateAAD) ([]byte, error) {
+	// 持有锁直到加密完成，Shred/Close 不会在使用中清除密钥
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	dk, err := e.activeKey(aad.Service)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, len(envelopeCipherMagic)+2+len(dk.id))
+	header = append(header, envelopeCipherMagic...)
+	header = append(header, envelopeCipherVersion, byte(len(dk.id)))
+	header = append(header, dk.id...)
+	ct, err := gcmSeal(dk.key, plaintext, append(bytes.Clone(header), aad.Bytes()...))
+	if err != nil {
+		return nil, err
+	}
+	return append(header, ct...), nil
+}
+
+func (e *EnvelopeCrypter) Open(ciphertext []byte, aad StateAAD) ([]byte, error) {
+	id, header, body, ok := parseEnvelopeCipher(ciphertext)
+	if !ok {
+		return nil, errors.New("not an envelope-encrypted state")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key, err := e.dataKey(aad.Service, id)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := openWithKey(key, body, append(bytes.Clone(header), aad.Bytes()...))
+	if err != nil {
+		return nil, fmt.Errorf("%w for %s: %v", ErrIntegrity, aad, err)
+	}
+	return plaintext, nil
+}
+
+// DataKeyID 返回信封密文使用的数据密钥 ID
+func DataKeyID(ciphertext []byte) (string, bool) {
+	id, _, _, ok := parseEnvelopeCipher(ciphertext)
+	return id, ok
+}
+
+func parseEnvelopeCipher(ciphertext []byte) (id string, header, body []byte, ok bool) {
+	n := len(envelopeCipherMagic)
+	if len(ciphertext) < n+2 || !bytes.Equal(ciphertext[:n], envelopeCipherMagic) || ciphertext[n] != envelopeCipherVersion {
+		return "", nil, nil, false
+	}
+	end := n + 2 + int(ciphertext[n+1])
+	if len(ciphertext) < end {
+		return "", nil, nil, false
+	}
+	return string(ciphertext[n+2 : end]), ciphertext[:end], ciphertext[end:], true
+}
+
+// RotateDataKey 为服务生成新的数据密钥并设为当前密钥，旧密钥保留用于解密
+func (e *EnvelopeCrypter) RotateDataKey(service string) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	dk, err := e.newDataKey(service)
+	return dk.id, err
+}
+
+// Shred 删除服务的全部数据密钥，该服务已保存的状态（包括旧的代和备份）从此无法解密
+func (e *EnvelopeCrypter) Shred(service string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, key := range e.cache[service] {
+		Zero(key)
+	}
+	delete(e.cache, service)
+	delete(e.active, service)
+	err := os.Remove(e.keyPath(service))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return syncDir(e.dir)
+}
+
+// Close 清除内存中的数据密钥，主密钥实现了 Close 时一并清除
+func (e *EnvelopeCrypter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for service, keys := range e.cache {
+		for _, key := range keys {
+			Zero(key)
+		}
+		delete(e.cache, service)
+	}
+	clear(e.active)
+	if c, ok := e.master.(interface{ Close() error }); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (e *EnvelopeCrypter) keyPath(service string) string {
+	return filepath.Join(e.dir, service+".keys")
+}
+
+// wrapAD 数据密钥包装的附加认证数据
+func wrapAD(service, id string) []byte {
+	return fmt.Appendf(nil, "microkernel-dek\x00%s\x00%s", service, id)
+}
+
+// activeKey 返回服务当前的数据密钥，调用方需持有 e.mu
+// 密钥文件中的当前密钥无法解包时（只写节点）生成新的数据密钥
+func (e *EnvelopeCrypter) activeKey(service string) (dataKey, error) {
+	if dk, ok := e.active[service]; ok {
+		return dk, nil
+	}
+	kf, err := e.readKeyFile(service)
+	if err != nil {
+		return dataKey{}, err
+	}
+	if kf.Active != "" {
+		key, err := e.dataKey(service, kf.Active)
+		if err == nil {
+			dk := dataKey{id: kf.Active, key: key}
+			e.active[service] = dk
+			return dk, nil
+		}
+		if !errors.Is(err, ErrWriteOnly) && !errors.Is(err, ErrUnknownKey) {
+			return dataKey{}, err
+		}
+	}
+	return e.newDataKey(service)
+}
+
+// dataKey 按 ID 查找并解包数据密钥，调用方需持有 e.mu
+func (e *EnvelopeCrypter) dataKey(service, id string) ([]byte, error) {
+	if key, ok := e.cache[service][id]; ok {
+		return key, nil
+	}
+	kf, err := e.readKeyFile(service)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range kf.Keys {
+		if entry.ID != id {
+			continue
+		}
+		if entry.Master != e.master.ID() {
+			return nil, fmt.Errorf("%w: data key %s of %s is wrapped by master key %s", ErrUnknownKey, id, service, entry.Master)
+		}
+		key, err := e.master.Unwrap(entry.Wrapped, wrapAD(service, id))
+		if err != nil {
+			if errors.Is(err, ErrWriteOnly) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: unwrap data key %s of %s: %v", ErrIntegrity, id, service, err)
+		}
+		e.cacheKey(service, id, key)
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: data key %s of %s", ErrUnknownKey, id, service)
+}
+
+// newDataKey 生成、包装并保存新的数据密钥，调用方需持有 e.mu
+func (e *EnvelopeCrypter) newDataKey(service string) (dataKey, error) {
+	kf, err := e.readKeyFile(service)
+	if err != nil {
+		return dataKey{}, err
+	}
+	raw := make([]byte, 32+4)
+	if _, err := io.ReadFull(rand.Reader, raw); err != nil {
+		return dataKey{}, err
+	}
+	key, id := raw[:32], hex.EncodeToString(raw[32:])
+	wrapped, err := e.master.Wrap(key, wrapAD(service, id))
+	if err != nil {
+		Zero(key)
+		return dataKey{}, err
+	}
+	kf.Keys = append(kf.Keys, dataKeyEntry{ID: id, Master: e.master.ID(), Wrapped: wrapped, Created: time.Now().UTC()})
+	kf.Active = id
+	if err := e.writeKeyFile(service, kf); err != nil {
+		Zero(key)
+		return dataKey{}, err
+	}
+	e.cacheKey(service, id, key)
+	dk := dataKey{id: id, key: key}
+	e.active[service] = dk
+	return dk, nil
+}
+
+func (e *EnvelopeCrypter) cacheKey(service, id string, key []byte) {
+	if e.cache[service] == nil {
+		e.cache[service] = make(map[string][]byte)
+	}
+	e.cache[service][id] = key
+}
+
+func (e *EnvelopeCrypter) readKeyFile(service string) (*dataKeyFile, error) {
+	data, err := os.ReadFile(e.keyPath(service))
+	if errors.Is(err, os.ErrNotExist) {
+		return &dataKeyFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var kf dataKeyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("key file %s: %w", e.keyPath(service), err)
+	}
+	return &kf, nil
+}
+
+// writeKeyFile 原子写入密钥文件（临时文件 + fsync + rename）
+func (e *EnvelopeCrypter) writeKeyFile(service string, kf *dataKeyFile) error {
+	data, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(e.dir, 0700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(e.dir, service+".keys.tmp-*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, e.keyPath(service))
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(e.dir)
+}
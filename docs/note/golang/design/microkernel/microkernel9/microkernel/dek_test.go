@@ -0,0 +1,162 @@
+package microkernel
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeKeyFile(t *testing.T, dir, name string, key []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEnvelopeX25519WriteOnly(t *testing.T) {
+	dir := t.TempDir()
+	master, err := GenerateX25519MasterKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipient, err := NewX25519RecipientKey(master.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	aad := StateAAD{StoreID: "s", Service: "echo", Version: 1}
+
+	// 只写节点可以加密，不能解密
+	writer := NewEnvelopeCrypter(recipient, dir)
+	ct, err := writer.Seal([]byte("state"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+	if _, err := NewEnvelopeCrypter(recipient, dir).Open(ct, aad); !errors.Is(err, ErrWriteOnly) {
+		t.Fatalf("write-only Open: err = %v, want ErrWriteOnly", err)
+	}
+
+	reader := NewEnvelopeCrypter(master, dir)
+	defer reader.Close()
+	pt, err := reader.Open(ct, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(pt) != "state" {
+		t.Fatalf("Open = %q", pt)
+	}
+
+	if err := reader.Shred("echo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.Open(ct, aad); err == nil {
+		t.Fatal("Open after Shred succeeded")
+	}
+}
+
+func TestConfigKeyModes(t *testing.T) {
+	dir := t.TempDir()
+	k1 := writeKeyFile(t, dir, "k1.key", bytes.Repeat([]byte{1}, 32))
+	k2 := writeKeyFile(t, dir, "k2.key", bytes.Repeat([]byte{2}, 32))
+	x := writeKeyFile(t, dir, "x.key", bytes.Repeat([]byte{3}, 32))
+	aad := StateAAD{StoreID: "s", Service: "echo", Version: 1}
+
+	keyring := `
+[store]
+backend = "memory"
+[key]
+mode = "keyring"
+keys = [{ id = "k1", source = "file:` + k1 + `" }, { id = "k2", source = "file:` + k2 + `" }]
+active = "k2"
+`
+	cfg, err := ParseConfig("kernel.toml", []byte(keyring))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := cfg.NewCrypter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kr, ok := c.(*Keyring)
+	if !ok {
+		t.Fatalf("NewCrypter = %T, want *Keyring", c)
+	}
+	if kr.Active() != "k2" {
+		t.Fatalf("active = %s, want k2", kr.Active())
+	}
+	kr.Close()
+
+	envelope := `
+[store]
+backend = "memory"
+[key]
+mode = "envelope"
+master = "x25519"
+source = "file:` + x + `"
+dir = "` + filepath.Join(dir, "keys") + `"
+`
+	cfg, err = ParseConfig("kernel.toml", []byte(envelope))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = cfg.NewCrypter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := c.(*EnvelopeCrypter)
+	defer env.Close()
+	ct, err := env.Seal([]byte("state"), aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 公钥文件配置出的只写节点与私钥使用同一个主密钥
+	priv, ok := env.master.(*X25519MasterKey)
+	if !ok {
+		t.Fatalf("master = %T", env.master)
+	}
+	pub := writeKeyFile(t, dir, "x.pub", []byte(base64.StdEncoding.EncodeToString(priv.PublicKey().Bytes())+"\n"))
+	loaded, err := LoadX25519PublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Equal(priv.PublicKey()) {
+		t.Fatal("loaded public key differs")
+	}
+	if pt, err := env.Open(ct, aad); err != nil || string(pt) != "state" {
+		t.Fatalf("Open = %q, %v", pt, err)
+	}
+}
+
+func TestConfigKeyErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		want string
+	}{
+		{"unknown mode", `mode = "rsa"`, `kernel.toml:4: key.mode: unknown mode "rsa"`},
+		{"keyring without keys", `mode = "keyring"`, `kernel.toml:3: key.keys: required`},
+		{"duplicate id", "mode = \"keyring\"\nkeys = [{ id = \"a\", source = \"env:A\" }, { id = \"a\", source = \"env:B\" }]", `key.keys[1].id: duplicate key id "a"`},
+		{"unknown active", "mode = \"keyring\"\nkeys = [{ id = \"a\", source = \"env:A\" }]\nactive = \"b\"", `kernel.toml:6: key.active: unknown key id "b"`},
+		{"keys in aes mode", `keys = [{ id = "a", source = "env:A" }]`, `key.keys: only applies to mode "keyring"`},
+		{"envelope on memory", `mode = "envelope"`, `key.dir: required for backend "memory"`},
+		{"x25519 with both", "mode = \"envelope\"\nmaster = \"x25519\"\nsource = \"env:A\"\npublic_key = \"x.pub\"\ndir = \"keys\"", `key.public_key: set either source or public_key`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseConfig("kernel.toml", []byte("[store]\nbackend = \"memory\"\n[key]\n"+tt.key+"\n"))
+			if err == nil {
+				t.Fatal("ParseConfig succeeded")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v\nwant %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package microkernel
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// StateEnvelope 导出状态的信封，记录状态属于哪个服务、schema 版本和编码方式（见 Codec）
+// 持久化和热替换时传递的都是信封，而不是裸状态
+type StateEnvelope struct {
+	Service  string `json:"service"`
+	Version  int    `json:"version"`
+	Encoding string `json:"encoding"`
+	Data     []byte `json:"data"`
+}
+
+// EncodingJSON 状态负载使用 JSON 编码
+const EncodingJSON = "json"
+
+// Versioned 服务可选实现：声明状态 schema 版本，未实现时为 1
+type Versioned interface {
+	StateVersion() int
+}
+
+// MigrateFunc 把状态负载从 from 版本迁移到 from+1 版本
+// 负载使用信封中记录的编码（默认为 JSON）
+type MigrateFunc func(data []byte) ([]byte, error)
+
+// Migrator 服务可选实现：提供状态迁移函数
+// 返回 from 版本 -> 迁移函数，例如 {1: v1ToV2, 2: v2ToV3}
+// 内核在 Register 和热替换时按顺序执行迁移链，把旧状态升级到服务当前版本
+type Migrator interface {
+	Migrations() map[int]MigrateFunc
+}
+
+func stateVersion(svc Service) int {
+	if v, ok := svc.(Versioned); ok {
+		return v.StateVersion()
+	}
+	return 1
+}
+
+// exportEnvelope 导出服务状态并封装为信封
+func exportEnvelope(svc Service) (*StateEnvelope, error) {
+	encoding, data, err := stateAdapterFor(svc).exportState()
+	if err != nil {
+		return nil, fmt.Errorf("state encode failed: %w", err)
+	}
+	return &StateEnvelope{
+		Service:  svc.Name(),
+		Version:  stateVersion(svc),
+		Encoding: encoding,
+		Data:     data,
+	}, nil
+}
+
+// DecodeEnvelope 把 StateStore.Load 返回的值还原为信封，供离线工具使用
+func DecodeEnvelope(name string, raw any) (*StateEnvelope, error) {
+	return decodeEnvelope(name, raw)
+}
+
+// decodeEnvelope 把存储或解密得到的值还原为信封
+// 没有信封的旧状态视为 1 版本的 JSON 状态
+func decodeEnvelope(name string, raw any) (*StateEnvelope, error) {
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := raw.(map[string]any); ok && isEnvelope(m) {
+		var env StateEnvelope
+		if err := json.Unmarshal(data, &env); err != nil {
+			return nil, fmt.Errorf("invalid state envelope: %w", err)
+		}
+		if env.Service != name {
+			return nil, fmt.Errorf("state belongs to service %s, not %s", env.Service, name)
+		}
+		return &env, nil
+	}
+	return &StateEnvelope{Service: name, Version: 1, Encoding: EncodingJSON, Data: data}, nil
+}
+
+func isEnvelope(m map[string]any) bool {
+	for _, key := range []string{"service", "version", "encoding", "data"} {
+		if _, ok := m[key]; !ok {
+			return false
+		}
+	}
+	return len(m) == 4
+}
+
+// migrate 执行迁移链，把信封升级到目标版本
+// 状态版本比服务新，或缺少某一步迁移时返回错误
+func migrate(svc Service, env *StateEnvelope) error {
+	target := stateVersion(svc)
+	if env.Version > target {
+		return fmt.Errorf("state of %s is version %d, newer than service version %d", env.Service, env.Version, target)
+	}
+	if env.Version == target {
+		return nil
+	}
+	var steps map[int]MigrateFunc
+	if m, ok := svc.(Migrator); ok {
+		steps = m.Migrations()
+	}
+	for env.Version < target {
+		fn, ok := steps[env.Version]
+		if !ok {
+			return fmt.Errorf("no migration path for %s from version %d to %d", env.Service, env.Version, target)
+		}
+		data, err := fn(env.Data)
+		if err != nil {
+			return fmt.Errorf("migrate %s from version %d failed: %w", env.Service, env.Version, err)
+		}
+		env.Data = data
+		env.Version++
+	}
+	return nil
+}
+
+// importEnvelope 迁移并导入状态
+func importEnvelope(svc Service, env *StateEnvelope) error {
+	if err := migrate(svc, env); err != nil {
+		return err
+	}
+	return stateAdapterFor(svc).importState(env.Encoding, env.Data)
+}
@@ -0,0 +1,121 @@
+package microkernel
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// staged 使用自定义迁移链的 counter
+type staged struct {
+	counter
+	steps map[int]MigrateFunc
+}
+
+func (s *staged) Migrations() map[int]MigrateFunc { return s.steps }
+
+// arith 返回把 JSON 数字 n 变为 fn(n) 的迁移函数
+func arith(fn func(int) int) MigrateFunc {
+	return func(data []byte) ([]byte, error) {
+		n, err := strconv.Atoi(string(data))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strconv.Itoa(fn(n))), nil
+	}
+}
+
+func TestMigrationChain(t *testing.T) {
+	store := NewMemoryStateStore()
+	if err := store.Save("a", &StateEnvelope{Service: "a", Version: 1, Encoding: EncodingJSON, Data: []byte("2")}); err != nil {
+		t.Fatal(err)
+	}
+	// 按顺序执行 1 -> 2 -> 3 -> 4，顺序错误会得到不同的结果
+	svc := &staged{counter: counter{name: "a", version: 4}, steps: map[int]MigrateFunc{
+		1: arith(func(n int) int { return n * 10 }),
+		2: arith(func(n int) int { return n + 5 }),
+		3: arith(func(n int) int { return n * 2 }),
+	}}
+	if err := NewMicroKernel(store).Register(svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.n != 50 {
+		t.Fatalf("state = %d, want 50", svc.n)
+	}
+
+	// 没有信封的旧状态按 1 版本迁移
+	legacy := NewMemoryStateStore()
+	if err := legacy.Save("a", 3); err != nil {
+		t.Fatal(err)
+	}
+	svc = &staged{counter: counter{name: "a", version: 4}, steps: svc.steps}
+	if err := NewMicroKernel(legacy).Register(svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.n != 70 {
+		t.Fatalf("legacy state = %d, want 70", svc.n)
+	}
+}
+
+func TestMigrationErrors(t *testing.T) {
+	double := arith(func(n int) int { return n * 2 })
+	tests := []struct {
+		name    string
+		version int
+		steps   map[int]MigrateFunc
+		env     StateEnvelope
+		want    string
+	}{
+		{
+			name:    "missing step",
+			version: 3,
+			steps:   map[int]MigrateFunc{1: double},
+			env:     StateEnvelope{Service: "a", Version: 1, Encoding: EncodingJSON, Data: []byte("1")},
+			want:    "no migration path for a from version 2 to 3",
+		},
+		{
+			name:    "newer state",
+			version: 2,
+			steps:   map[int]MigrateFunc{1: double},
+			env:     StateEnvelope{Service: "a", Version: 3, Encoding: EncodingJSON, Data: []byte("1")},
+			want:    "state of a is version 3, newer than service version 2",
+		},
+		{
+			name:    "failing step",
+			version: 3,
+			steps: map[int]MigrateFunc{1: double, 2: func([]byte) ([]byte, error) {
+				return nil, errors.New("bad data")
+			}},
+			env:  StateEnvelope{Service: "a", Version: 1, Encoding: EncodingJSON, Data: []byte("1")},
+			want: "migrate a from version 2 failed: bad data",
+		},
+		{
+			name:    "other service",
+			version: 1,
+			env:     StateEnvelope{Service: "b", Version: 1, Encoding: EncodingJSON, Data: []byte("1")},
+			want:    "state belongs to service b, not a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryStateStore()
+			if err := store.Save("a", &tt.env); err != nil {
+				t.Fatal(err)
+			}
+			svc := &staged{counter: counter{name: "a", version: tt.version, n: 7}, steps: tt.steps}
+			k := NewMicroKernel(store)
+			err := k.Register(svc)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %s", err, tt.want)
+			}
+			// 迁移失败时不导入部分迁移的状态，也不注册服务
+			if svc.n != 7 {
+				t.Fatalf("state = %d, want 7", svc.n)
+			}
+			if _, ok := k.Service("a"); ok {
+				t.Fatal("service registered after a failed migration")
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package microkernel
+
+import (
+	"context"
+	"sync"
+)
+
+// 内核事件类型，内核通过 Subscribe 向订阅者广播
+const (
+	// EventStateFallback 最新状态损坏，已回退到旧一代状态
+	EventStateFallback = "state.fallback"
+)
+
+// KernelEventSource 内核事件的 From 字段
+const KernelEventSource = "kernel"
+
+type subscriber struct {
+	ch    chan Event
+	types map[string]bool
+}
+
+// eventHub 内核事件订阅管理
+// 广播不阻塞：订阅者处理不过来时丢弃事件
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[*subscriber]struct{}
+}
+
+// Subscribe 订阅内核事件，types 为空表示订阅全部类型
+// ctx 取消后关闭通道
+func (k *MicroKernel) Subscribe(ctx context.Context, types ...string) <-chan Event {
+	sub := &subscriber{ch: make(chan Event, 16)}
+	if len(types) > 0 {
+		sub.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			sub.types[t] = true
+		}
+	}
+	h := &k.events
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[*subscriber]struct{})
+	}
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		delete(h.subs, sub)
+		h.mu.Unlock()
+		close(sub.ch)
+	}()
+	return sub.ch
+}
+
+// emit 广播内核事件
+func (k *MicroKernel) emit(typ, content string) {
+	evt := Event{From: KernelEventSource, Type: typ, Content: content}
+	h := &k.events
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		if sub.types != nil && !sub.types[typ] {
+			continue
+		}
+		select {
+		case sub.ch <- evt:
+		default:
+		}
+	}
+}
+
+// fallbackReporter 支持报告状态回退的存储
+type fallbackReporter interface {
+	SetFallbackHandler(func(StateFallback))
+}
+
+// repairReporter 打开时可能修复数据的存储
+type repairReporter interface {
+	Repaired() error
+}
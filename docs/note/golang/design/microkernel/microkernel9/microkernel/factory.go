@@ -0,0 +1,286 @@
+package microkernel
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ParamKind 服务参数的类型
+type ParamKind int
+
+const (
+	ParamString ParamKind = iota
+	ParamInt
+	ParamBool
+	// ParamDuration 配置中写成 "500ms"、"2s" 这样的字符串
+	ParamDuration
+	ParamStrings
+)
+
+func (k ParamKind) String() string {
+	return [...]string{"string", "int", "bool", "duration", "[]string"}[k]
+}
+
+// ParamSpec 服务参数的声明
+type ParamSpec struct {
+	Name string
+	Kind ParamKind
+	// 未配置时使用的值，类型与 Kind 对应（string、int、bool、time.Duration、[]string）
+	Default     any
+	Required    bool
+	Description string
+}
+
+// Params 按参数声明校验并补全默认值后的服务参数
+type Params struct {
+	values map[string]any
+}
+
+// Has 参数是否配置或有默认值
+func (p Params) Has(name string) bool {
+	_, ok := p.values[name]
+	return ok
+}
+
+func (p Params) String(name string) string {
+	s, _ := p.values[name].(string)
+	return s
+}
+
+func (p Params) Int(name string) int {
+	n, _ := p.values[name].(int)
+	return n
+}
+
+func (p Params) Bool(name string) bool {
+	b, _ := p.values[name].(bool)
+	return b
+}
+
+func (p Params) Duration(name string) time.Duration {
+	d, _ := p.values[name].(time.Duration)
+	return d
+}
+
+func (p Params) Strings(name string) []string {
+	s, _ := p.values[name].([]string)
+	return s
+}
+
+// ServiceType 可按类型名创建的服务
+type ServiceType struct {
+	Name        string
+	Description string
+	Version     string
+	Params      []ParamSpec
+	// New 创建服务，参数已按 Params 校验
+	New func(k *MicroKernel, p Params) (Service, error)
+}
+
+// ServiceRegistry 服务类型注册表
+type ServiceRegistry struct {
+	mu    sync.RWMutex
+	types map[string]ServiceType
+}
+
+// NewServiceRegistry 创建服务类型注册表
+func NewServiceRegistry() *ServiceRegistry {
+	return &ServiceRegistry{types: make(map[string]ServiceType)}
+}
+
+// DefaultServiceRegistry 服务包在 init 中注册类型的默认注册表
+var DefaultServiceRegistry = NewServiceRegistry()
+
+// RegisterServiceType 向默认注册表注册服务类型，类型重复或声明无效时 panic
+func RegisterServiceType(t ServiceType) {
+	if err := DefaultServiceRegistry.Register(t); err != nil {
+		panic(err)
+	}
+}
+
+// ServiceTypes 默认注册表中的全部服务类型
+func ServiceTypes() []ServiceType {
+	return DefaultServiceRegistry.Types()
+}
+
+// Register 注册服务类型，检查参数声明和默认值
+func (r *ServiceRegistry) Register(t ServiceType) error {
+	if t.Name == "" || t.New == nil {
+		return errors.New("service type needs a name and a constructor")
+	}
+	seen := make(map[string]bool, len(t.Params))
+	for i, spec := range t.Params {
+		if seen[spec.Name] {
+			return fmt.Errorf("service type %s: parameter %s declared twice", t.Name, spec.Name)
+		}
+		seen[spec.Name] = true
+		if spec.Default == nil {
+			continue
+		}
+		v, err := convertParam(spec.Kind, spec.Default)
+		if err != nil {
+			return fmt.Errorf("service type %s: default of %s: %w", t.Name, spec.Name, err)
+		}
+		t.Params[i].Default = v
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, dup := r.types[t.Name]; dup {
+		return fmt.Errorf("service type %s already registered", t.Name)
+	}
+	r.types[t.Name] = t
+	return nil
+}
+
+// Lookup 按名称查找服务类型
+func (r *ServiceRegistry) Lookup(name string) (ServiceType, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.types[name]
+	return t, ok
+}
+
+// Types 按名称排序的全部服务类型
+func (r *ServiceRegistry) Types() []ServiceType {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]ServiceType, 0, len(r.types))
+	for _, t := range r.types {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i].Name < types[j].Name })
+	return types
+}
+
+// Build 按类型名和参数创建服务
+// 未声明的参数、类型不符和缺少必填参数都是错误
+func (r *ServiceRegistry) Build(k *MicroKernel, typ string, raw map[string]any) (Service, error) {
+	t, ok := r.Lookup(typ)
+	if !ok {
+		return nil, fmt.Errorf("unknown service type %q", typ)
+	}
+	params, err := t.params(raw)
+	if err != nil {
+		return nil, err
+	}
+	return t.New(k, params)
+}
+
+// Params 按服务类型的参数声明校验参数并补全默认值
+func (r *ServiceRegistry) Params(typ string, raw map[string]any) (Params, error) {
+	t, ok := r.Lookup(typ)
+	if !ok {
+		return Params{}, fmt.Errorf("unknown service type %q", typ)
+	}
+	return t.params(raw)
+}
+
+// BuildFunc 用于 Config.Apply，按服务声明的类型和参数创建服务
+func (r *ServiceRegistry) BuildFunc() BuildFunc {
+	return func(k *MicroKernel, sc ServiceConfig) (Service, error) {
+		return r.Build(k, sc.Type, sc.Params)
+	}
+}
+
+// paramError 一个参数的校验错误，配置解码时据此报告参数所在的行
+type paramError struct {
+	name string
+	msg  string
+}
+
+func (e *paramError) Error() string {
+	return "params." + e.name + ": " + e.msg
+}
+
+// params 按参数声明校验配置值并补全默认值，返回的错误由 *paramError 组成
+func (t ServiceType) params(raw map[string]any) (Params, error) {
+	var errs []error
+	values := make(map[string]any, len(t.Params))
+	declared := make(map[string]bool, len(t.Params))
+	for _, spec := range t.Params {
+		declared[spec.Name] = true
+		v, ok := raw[spec.Name]
+		if !ok {
+			if spec.Required {
+				errs = append(errs, &paramError{spec.Name, "required"})
+			} else if spec.Default != nil {
+				values[spec.Name] = spec.Default
+			}
+			continue
+		}
+		cv, err := convertParam(spec.Kind, v)
+		if err != nil {
+			errs = append(errs, &paramError{spec.Name, err.Error()})
+			continue
+		}
+		values[spec.Name] = cv
+	}
+	var unknown []string
+	for name := range raw {
+		if !declared[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs = append(errs, &paramError{name, "unknown parameter for service type " + t.Name})
+	}
+	return Params{values: values}, errors.Join(errs...)
+}
+
+// convertParam 把配置值（JSON/TOML 解码得到的 string、float64、bool、[]any）或 Go 值转换为参数类型
+func convertParam(kind ParamKind, v any) (any, error) {
+	switch kind {
+	case ParamString:
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+	case ParamInt:
+		switch n := v.(type) {
+		case int:
+			return n, nil
+		case int64:
+			return int(n), nil
+		case float64:
+			if n != math.Trunc(n) || math.Abs(n) > math.MaxInt32 {
+				return nil, fmt.Errorf("%v is not an integer", n)
+			}
+			return int(n), nil
+		}
+	case ParamBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+	case ParamDuration:
+		switch d := v.(type) {
+		case time.Duration:
+			return d, nil
+		case string:
+			parsed, err := time.ParseDuration(d)
+			if err != nil {
+				return nil, err
+			}
+			return parsed, nil
+		}
+	case ParamStrings:
+		switch s := v.(type) {
+		case []string:
+			return s, nil
+		case []any:
+			out := make([]string, len(s))
+			for i, item := range s {
+				str, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("item %d: want string, got %T", i, item)
+				}
+				out[i] = str
+			}
+			return out, nil
+		}
+	}
+	return nil, fmt.Errorf("want %s, got %T", kind, v)
+}
@@ -0,0 +1,379 @@
+package microkernel
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EventDependencyMissing 注册的服务依赖尚未注册的服务
+const EventDependencyMissing = "service.dependency_missing"
+
+// Graph 服务依赖图，边从服务指向它依赖的服务
+type Graph struct {
+	// 按名称排序的服务
+	Services []string
+	// 服务 -> 依赖的服务，保持声明顺序，重复的依赖只保留第一次
+	Dependencies map[string][]string
+	// 服务 -> 直接依赖它的服务，按名称排序
+	Dependents map[string][]string
+	// 未注册的服务 -> 依赖它的服务，按名称排序
+	Missing map[string][]string
+	// 服务最近一次启动的耗时，用于计算关键启动路径；都没有记录时关键路径为最长的依赖链
+	StartDurations map[string]time.Duration
+}
+
+// NewGraph 由服务及其依赖创建依赖图
+func NewGraph(deps map[string][]string) *Graph {
+	g := &Graph{
+		Dependencies:   make(map[string][]string, len(deps)),
+		Dependents:     make(map[string][]string, len(deps)),
+		Missing:        make(map[string][]string),
+		StartDurations: make(map[string]time.Duration),
+	}
+	for name, ds := range deps {
+		g.Services = append(g.Services, name)
+		g.Dependencies[name] = uniqueDeps(ds)
+		g.Dependents[name] = []string{}
+	}
+	sort.Strings(g.Services)
+	for _, name := range g.Services {
+		for _, dep := range g.Dependencies[name] {
+			if _, ok := deps[dep]; !ok {
+				g.Missing[dep] = append(g.Missing[dep], name)
+				continue
+			}
+			g.Dependents[dep] = append(g.Dependents[dep], name)
+		}
+	}
+	return g
+}
+
+// uniqueDeps 去掉重复的依赖，否则每条重复的边都会多报一次循环和缺失
+func uniqueDeps(deps []string) []string {
+	seen := make(map[string]bool, len(deps))
+	out := make([]string, 0, len(deps))
+	for _, d := range deps {
+		if !seen[d] {
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// Graph 返回已注册服务的依赖图
+func (k *MicroKernel) Graph() *Graph {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	deps := make(map[string][]string, len(k.services))
+	for name, meta := range k.services {
+		deps[name] = meta.deps
+	}
+	g := NewGraph(deps)
+	for name, meta := range k.services {
+		if meta.startTime > 0 {
+			g.StartDurations[name] = meta.startTime
+		}
+	}
+	return g
+}
+
+// GraphError 依赖图中的全部循环和缺失的依赖
+type GraphError struct {
+	Cycles  [][]string
+	Missing map[string][]string
+}
+
+func (e *GraphError) Error() string {
+	var parts []string
+	for _, c := range e.Cycles {
+		parts = append(parts, "dependency cycle: "+strings.Join(c, " -> "))
+	}
+	missing := make([]string, 0, len(e.Missing))
+	for dep := range e.Missing {
+		missing = append(missing, dep)
+	}
+	sort.Strings(missing)
+	for _, dep := range missing {
+		for _, name := range e.Missing[dep] {
+			parts = append(parts, fmt.Sprintf("service %s depends on unknown service %s", name, dep))
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// Cycles 返回全部循环依赖，每个循环从名称最小的服务开始并回到该服务，例如 [a b c a]
+func (g *Graph) Cycles() [][]string {
+	index := make(map[string]int, len(g.Services))
+	for i, name := range g.Services {
+		index[name] = i
+	}
+	var cycles [][]string
+	for i, start := range g.Services {
+		// 只经过序号不小于 start 的服务，每个循环只在其最小的服务处找到一次
+		// 并且只进入能回到 start 的服务，避免搜索不会成环的分支
+		back := g.reaching(start, func(name string) bool { return index[name] >= i })
+		onPath := map[string]bool{start: true}
+		var path []string
+		var walk func(name string)
+		walk = func(name string) {
+			path = append(path, name)
+			for _, dep := range g.Dependencies[name] {
+				switch {
+				case dep == start:
+					cycles = append(cycles, append(slices.Clone(path), start))
+				case back[dep] && !onPath[dep]:
+					onPath[dep] = true
+					walk(dep)
+					onPath[dep] = false
+				}
+			}
+			path = path[:len(path)-1]
+		}
+		walk(start)
+	}
+	return cycles
+}
+
+// reaching 沿依赖边能到达 target 的服务（只经过 allowed 的服务）
+func (g *Graph) reaching(target string, allowed func(string) bool) map[string]bool {
+	seen := map[string]bool{target: true}
+	queue := []string{target}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		for _, d := range g.Dependents[name] {
+			if !seen[d] && allowed(d) {
+				seen[d] = true
+				queue = append(queue, d)
+			}
+		}
+	}
+	return seen
+}
+
+// Validate 依赖都已注册且没有循环时返回 nil，否则返回 *GraphError
+func (g *Graph) Validate() error {
+	cycles := g.Cycles()
+	if len(cycles) == 0 && len(g.Missing) == 0 {
+		return nil
+	}
+	return &GraphError{Cycles: cycles, Missing: g.Missing}
+}
+
+// StartOrder 按依赖排序，被依赖的服务在前；依赖缺失或有循环时返回 *GraphError
+func (g *Graph) StartOrder() ([]string, error) {
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	return g.order(), nil
+}
+
+// order 深度优先的依赖顺序，调用方保证没有循环；缺失的依赖被忽略
+func (g *Graph) order() []string {
+	visited := make(map[string]bool, len(g.Services))
+	order := make([]string, 0, len(g.Services))
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+		for _, dep := range g.Dependencies[name] {
+			if _, ok := g.Dependencies[dep]; ok {
+				visit(dep)
+			}
+		}
+		order = append(order, name)
+	}
+	for _, name := range g.Services {
+		visit(name)
+	}
+	return order
+}
+
+// Impact 停止 name 时受影响的服务：直接或间接依赖它的服务，按停止顺序排列（依赖方在前）
+// 有循环时按名称排序
+func (g *Graph) Impact(name string) []string {
+	affected := g.reaching(name, func(string) bool { return true })
+	delete(affected, name)
+	impact := make([]string, 0, len(affected))
+	if len(g.Cycles()) > 0 {
+		for _, s := range g.Services {
+			if affected[s] {
+				impact = append(impact, s)
+			}
+		}
+		return impact
+	}
+	order := g.order()
+	for i := len(order) - 1; i >= 0; i-- {
+		if affected[order[i]] {
+			impact = append(impact, order[i])
+		}
+	}
+	return impact
+}
+
+// CriticalPath 启动耗时最长的依赖链，按启动顺序排列，以及链上服务的启动耗时之和
+// 没有记录启动耗时的服务不计时间，耗时相同时取最长的链；有循环时返回 nil
+func (g *Graph) CriticalPath() ([]string, time.Duration) {
+	if len(g.Cycles()) > 0 {
+		return nil, 0
+	}
+	type best struct {
+		cost time.Duration
+		n    int
+		prev string
+	}
+	longer := func(a, b best) bool {
+		return a.cost > b.cost || (a.cost == b.cost && a.n > b.n)
+	}
+	bests := make(map[string]best, len(g.Services))
+	var last string
+	for _, name := range g.order() {
+		var b best
+		for _, dep := range g.Dependencies[name] {
+			if d, ok := bests[dep]; ok && longer(best{d.cost, d.n, dep}, b) {
+				b = best{d.cost, d.n, dep}
+			}
+		}
+		b.cost += g.StartDurations[name]
+		b.n++
+		bests[name] = b
+		if last == "" || longer(b, bests[last]) {
+			last = name
+		}
+	}
+	if last == "" {
+		return nil, 0
+	}
+	var path []string
+	for name := last; name != ""; name = bests[name].prev {
+		path = append(path, name)
+	}
+	slices.Reverse(path)
+	return path, bests[last].cost
+}
+
+// edgeSet 路径上相邻服务组成的边
+func (g *Graph) edgeSet(paths ...[]string) map[[2]string]bool {
+	edges := make(map[[2]string]bool)
+	for _, p := range paths {
+		for i := 0; i+1 < len(p); i++ {
+			edges[[2]string{p[i], p[i+1]}] = true
+		}
+	}
+	return edges
+}
+
+// highlights 循环边和关键路径边（关键路径按启动顺序，边方向与依赖相反）
+func (g *Graph) highlights() (cycle, critical map[[2]string]bool) {
+	cycle = g.edgeSet(g.Cycles()...)
+	path, _ := g.CriticalPath()
+	critical = make(map[[2]string]bool)
+	for i := 0; i+1 < len(path); i++ {
+		critical[[2]string{path[i+1], path[i]}] = true
+	}
+	return cycle, critical
+}
+
+// missingNames 按名称排序的缺失服务
+func (g *Graph) missingNames() []string {
+	names := make([]string, 0, len(g.Missing))
+	for name := range g.Missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DOT 导出为 Graphviz DOT，边从服务指向它依赖的服务
+// 循环依赖的边为红色，关键启动路径加粗，缺失的服务以红色虚线框表示
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	cycle, critical := g.highlights()
+	quote := func(s string) string {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+	}
+	b.WriteString("digraph services {\n\tnode [shape=box];\n")
+	for _, name := range g.Services {
+		label := name
+		if d := g.StartDurations[name]; d > 0 {
+			label += "\n" + d.Round(time.Microsecond).String()
+		}
+		fmt.Fprintf(&b, "\t%s [label=%s];\n", quote(name), quote(label))
+	}
+	for _, name := range g.missingNames() {
+		fmt.Fprintf(&b, "\t%s [style=dashed, color=red, label=%s];\n", quote(name), quote(name+"\n(missing)"))
+	}
+	for _, name := range g.Services {
+		for _, dep := range g.Dependencies[name] {
+			var attrs []string
+			if cycle[[2]string{name, dep}] {
+				attrs = append(attrs, "color=red")
+			}
+			if critical[[2]string{name, dep}] {
+				attrs = append(attrs, "penwidth=2")
+			}
+			if _, ok := g.Missing[dep]; ok {
+				attrs = append(attrs, "style=dashed", "color=red")
+			}
+			fmt.Fprintf(&b, "\t%s -> %s", quote(name), quote(dep))
+			if len(attrs) > 0 {
+				b.WriteString(" [" + strings.Join(attrs, ", ") + "]")
+			}
+			b.WriteString(";\n")
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid 导出为 Mermaid 流程图，样式规则与 DOT 相同
+// 服务名称可能包含 Mermaid 不允许的字符，节点 ID 使用 s0、s1…，名称作为标签
+func (g *Graph) Mermaid() string {
+	var b strings.Builder
+	cycle, critical := g.highlights()
+	ids := make(map[string]string)
+	node := func(name, label string) {
+		ids[name] = fmt.Sprintf("s%d", len(ids))
+		fmt.Fprintf(&b, "    %s[\"%s\"]\n", ids[name], strings.ReplaceAll(label, `"`, "#quot;"))
+	}
+	b.WriteString("graph TD\n")
+	for _, name := range g.Services {
+		node(name, name)
+	}
+	for _, name := range g.missingNames() {
+		node(name, name+" (missing)")
+		fmt.Fprintf(&b, "    class %s missing\n", ids[name])
+	}
+	edge := 0
+	var styles []string
+	for _, name := range g.Services {
+		for _, dep := range g.Dependencies[name] {
+			arrow := "-->"
+			if _, ok := g.Missing[dep]; ok {
+				arrow = "-.->"
+			}
+			fmt.Fprintf(&b, "    %s %s %s\n", ids[name], arrow, ids[dep])
+			switch {
+			case cycle[[2]string{name, dep}]:
+				styles = append(styles, fmt.Sprintf("    linkStyle %d stroke:red", edge))
+			case critical[[2]string{name, dep}]:
+				styles = append(styles, fmt.Sprintf("    linkStyle %d stroke-width:3px", edge))
+			}
+			edge++
+		}
+	}
+	for _, s := range styles {
+		b.WriteString(s + "\n")
+	}
+	if len(g.Missing) > 0 {
+		b.WriteString("    classDef missing stroke:red,stroke-dasharray:5 5\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,63 @@
+package microkernel
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGraphCycles(t *testing.T) {
+	tests := []struct {
+		name string
+		deps map[string][]string
+		want [][]string
+	}{
+		{
+			name: "acyclic",
+			deps: map[string][]string{"a": {"b", "c"}, "b": {"c"}, "c": nil},
+		},
+		{
+			name: "self loop",
+			deps: map[string][]string{"a": {"a"}, "b": {"a"}},
+			want: [][]string{{"a", "a"}},
+		},
+		{
+			name: "overlapping",
+			// a -> b -> a 与 a -> b -> c -> a 共享边 a -> b
+			deps: map[string][]string{"a": {"b"}, "b": {"a", "c"}, "c": {"a"}},
+			want: [][]string{{"a", "b", "a"}, {"a", "b", "c", "a"}},
+		},
+		{
+			name: "disjoint",
+			deps: map[string][]string{"a": {"b"}, "b": {"a"}, "c": {"d"}, "d": {"c"}},
+			want: [][]string{{"a", "b", "a"}, {"c", "d", "c"}},
+		},
+		{
+			name: "starts at smallest",
+			deps: map[string][]string{"c": {"a"}, "a": {"b"}, "b": {"c"}},
+			want: [][]string{{"a", "b", "c", "a"}},
+		},
+		{
+			name: "duplicate deps",
+			deps: map[string][]string{"a": {"b", "b", "a", "a"}, "b": {"a"}},
+			want: [][]string{{"a", "b", "a"}, {"a", "a"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewGraph(tt.deps).Cycles(); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Cycles = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphDuplicateMissing(t *testing.T) {
+	g := NewGraph(map[string][]string{"a": {"x", "x"}})
+	if want := map[string][]string{"x": {"a"}}; !reflect.DeepEqual(g.Missing, want) {
+		t.Fatalf("Missing = %v, want %v", g.Missing, want)
+	}
+	want := "service a depends on unknown service x"
+	if err := g.Validate(); err == nil || err.Error() != want {
+		t.Fatalf("Validate = %v, want %s", err, want)
+	}
+}
@@ -0,0 +1,364 @@
+package microkernel
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// KeyProvider 密钥来源
+// 返回的密钥由调用方持有，用完后调用 Zero 清除
+type KeyProvider interface {
+	Key() ([]byte, error)
+}
+
+// Zero 清除内存中的密钥材料
+func Zero(b []byte) {
+	clear(b)
+}
+
+// validKeyLen AES-128/192/256
+func validKeyLen(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+func checkKeyLen(key []byte) error {
+	if !validKeyLen(len(key)) {
+		return fmt.Errorf("invalid AES key length %d, want 16, 24 or 32 bytes", len(key))
+	}
+	return nil
+}
+
+// FileKeyProvider 从文件读取原始密钥（16/24/32 字节）
+// 文件不能被属主以外的用户访问（权限必须为 0600 或更严格）
+type FileKeyProvider struct {
+	Path string
+}
+
+func (p FileKeyProvider) Key() ([]byte, error) {
+	st, err := os.Stat(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	if perm := st.Mode().Perm(); perm&0o077 != 0 {
+		return nil, fmt.Errorf("key file %s has permissions %04o, want 0600 or stricter", p.Path, perm)
+	}
+	key, err := os.ReadFile(p.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkKeyLen(key); err != nil {
+		Zero(key)
+		return nil, fmt.Errorf("key file %s: %w", p.Path, err)
+	}
+	return key, nil
+}
+
+// EnvKeyProvider 从环境变量读取密钥
+// 值为原始密钥字符串，或带 "base64:" 前缀的 base64 编码密钥
+type EnvKeyProvider struct {
+	Name string
+}
+
+func (p EnvKeyProvider) Key() ([]byte, error) {
+	val, ok := os.LookupEnv(p.Name)
+	if !ok || val == "" {
+		return nil, fmt.Errorf("environment variable %s not set", p.Name)
+	}
+	key := []byte(val)
+	if encoded, ok := strings.CutPrefix(val, "base64:"); ok {
+		var err error
+		if key, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+			return nil, fmt.Errorf("environment variable %s: %w", p.Name, err)
+		}
+	}
+	if err := checkKeyLen(key); err != nil {
+		Zero(key)
+		return nil, fmt.Errorf("environment variable %s: %w", p.Name, err)
+	}
+	return key, nil
+}
+
+// PassphraseKeyProvider 使用 PBKDF2-HMAC-SHA256 从口令派生密钥
+// 盐保存在 SaltPath（每个状态存储一个），不存在时自动生成
+type PassphraseKeyProvider struct {
+	// Passphrase 返回口令，调用后口令会被清除
+	Passphrase func() ([]byte, error)
+	SaltPath   string
+	// 迭代次数，0 使用 DefaultPBKDF2Iterations
+	Iterations int
+	// 密钥长度，0 为 32 字节
+	KeyLen int
+}
+
+// DefaultPBKDF2Iterations PBKDF2-HMAC-SHA256 默认迭代次数
+const DefaultPBKDF2Iterations = 600000
+
+func (p PassphraseKeyProvider) Key() ([]byte, error) {
+	keyLen := p.KeyLen
+	if keyLen == 0 {
+		keyLen = 32
+	}
+	if !validKeyLen(keyLen) {
+		return nil, checkKeyLen(make([]byte, keyLen))
+	}
+	iter := p.Iterations
+	if iter == 0 {
+		iter = DefaultPBKDF2Iterations
+	}
+	salt, err := loadOrCreateSalt(p.SaltPath)
+	if err != nil {
+		return nil, err
+	}
+	pass, err := p.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	defer Zero(pass)
+	if len(pass) == 0 {
+		return nil, errors.New("empty passphrase")
+	}
+	return pbkdf2SHA256(pass, salt, iter, keyLen), nil
+}
+
+// PassphraseFromEnv 从环境变量读取口令
+func PassphraseFromEnv(name string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return nil, fmt.Errorf("environment variable %s not set", name)
+		}
+		return []byte(val), nil
+	}
+}
+
+func loadOrCreateSalt(path string) ([]byte, error) {
+	salt, err := os.ReadFile(path)
+	if err == nil {
+		if len(salt) < 16 {
+			return nil, fmt.Errorf("salt file %s too short", path)
+		}
+		return salt, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	salt = make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// O_EXCL：并发创建时以先写入的盐为准
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return loadOrCreateSalt(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Write(salt); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return salt, f.Close()
+}
+
+// pbkdf2SHA256 RFC 8018 PBKDF2，PRF 为 HMAC-SHA256
+func pbkdf2SHA256(password, salt []byte, iter, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var counter [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	defer Zero(u)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], uint32(block))
+		prf.Write(counter[:])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for i := range u {
+				t[i] ^= u[i]
+			}
+		}
+	}
+	key := make([]byte, keyLen)
+	copy(key, dk)
+	Zero(dk[:cap(dk)])
+	return key
+}
+
+// AgentKeyProvider 通过本地 Unix socket 向密钥代理请求密钥
+//
+// 协议为单行文本：
+//
+//	请求：GET <name>\n
+//	应答：OK <base64 密钥>\n 或 ERR <原因>\n
+type AgentKeyProvider struct {
+	Socket string
+	Name   string
+	// 连接和读写超时，0 为 5 秒
+	Timeout time.Duration
+}
+
+func (p AgentKeyProvider) Key() ([]byte, error) {
+	timeout := p.Timeout
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+	conn, err := net.DialTimeout("unix", p.Socket, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+
+	if _, err := fmt.Fprintf(conn, "GET %s\n", p.Name); err != nil {
+		return nil, err
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	defer Zero(line)
+	if err != nil {
+		return nil, fmt.Errorf("key agent: %w", err)
+	}
+	resp := strings.TrimSpace(string(line))
+	if msg, ok := strings.CutPrefix(resp, "ERR "); ok {
+		return nil, fmt.Errorf("key agent: %s", msg)
+	}
+	encoded, ok := strings.CutPrefix(resp, "OK ")
+	if !ok {
+		return nil, errors.New("key agent: malformed response")
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("key agent: %w", err)
+	}
+	if err := checkKeyLen(key); err != nil {
+		Zero(key)
+		return nil, fmt.Errorf("key agent: %w", err)
+	}
+	return key, nil
+}
+
+// ServeKeyAgent 密钥代理服务端，lookup 返回的密钥发送后会被清除
+// 用于测试或简单部署，生产环境可以替换为任意实现同一协议的代理
+func ServeKeyAgent(l net.Listener, lookup func(name string) ([]byte, error)) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func(conn net.Conn) {
+			defer conn.Close()
+			_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err != nil {
+				return
+			}
+			name, ok := strings.CutPrefix(strings.TrimSpace(line), "GET ")
+			if !ok {
+				fmt.Fprint(conn, "ERR bad request\n")
+				return
+			}
+			key, err := lookup(name)
+			if err != nil {
+				fmt.Fprintf(conn, "ERR %v\n", err)
+				return
+			}
+			defer Zero(key)
+			fmt.Fprintf(conn, "OK %s\n", base64.StdEncoding.EncodeToString(key))
+		}(conn)
+	}
+}
+
+// ChainKeyProvider 依次尝试多个密钥来源，返回第一个成功的
+type ChainKeyProvider []KeyProvider
+
+func (c ChainKeyProvider) Key() ([]byte, error) {
+	var errs []error
+	for _, p := range c {
+		key, err := p.Key()
+		if err == nil {
+			return key, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, fmt.Errorf("no key source available: %w", errors.Join(errs...))
+}
+
+// ParseKeySource 解析密钥来源描述，命令行工具和配置文件共用
+//
+//	env:NAME                        环境变量
+//	file:PATH                       原始密钥文件
+//	passphrase:ENV_NAME,salt=PATH   口令来自环境变量，盐保存在 PATH
+//	agent:SOCKET#NAME               本地密钥代理
+func ParseKeySource(spec string) (KeyProvider, error) {
+	kind, arg, ok := strings.Cut(spec, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("invalid key source %q", spec)
+	}
+	switch kind {
+	case "env":
+		return EnvKeyProvider{Name: arg}, nil
+	case "file":
+		return FileKeyProvider{Path: arg}, nil
+	case "passphrase":
+		name, salt, ok := strings.Cut(arg, ",salt=")
+		if !ok || name == "" || salt == "" {
+			return nil, fmt.Errorf("invalid passphrase key source %q, want passphrase:ENV_NAME,salt=PATH", spec)
+		}
+		return PassphraseKeyProvider{Passphrase: PassphraseFromEnv(name), SaltPath: salt}, nil
+	case "agent":
+		socket, name, ok := strings.Cut(arg, "#")
+		if !ok || socket == "" || name == "" {
+			return nil, fmt.Errorf("invalid agent key source %q, want agent:SOCKET#NAME", spec)
+		}
+		return AgentKeyProvider{Socket: socket, Name: name}, nil
+	default:
+		return nil, fmt.Errorf("unknown key source type %q", kind)
+	}
+}
+
+// DefaultKeyProvider 内核和离线工具默认使用的密钥来源：
+// 环境变量 MICROKERNEL_KEY，或权限为 0600 的 ./state.key 文件
+func DefaultKeyProvider() KeyProvider {
+	return ChainKeyProvider{
+		EnvKeyProvider{Name: "MICROKERNEL_KEY"},
+		FileKeyProvider{Path: "./state.key"},
+	}
+}
+
+// NewAESCrypterFromProvider 从密钥来源创建加密器，读取到的密钥在复制后清除
+func NewAESCrypterFromProvider(p KeyProvider) (*AESCrypter, error) {
+	key, err := p.Key()
+	if err != nil {
+		return nil, err
+	}
+	defer Zero(key)
+	return NewAESCrypter(key)
+}
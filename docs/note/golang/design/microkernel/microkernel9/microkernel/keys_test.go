@@ -0,0 +1,164 @@
+package microkernel
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// RFC 7914 §11 的测试向量（后两个），以及按 RFC 6070 的输入计算的 HMAC-SHA256 结果
+	tests := []struct {
+		password, salt string
+		iter, keyLen   int
+		want           string
+	}{
+		{"password", "salt", 1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{"password", "salt", 2, 32, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{"password", "salt", 4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{"passwd", "salt", 1, 64, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783"},
+		{"Password", "NaCl", 80000, 64, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(pbkdf2SHA256([]byte(tt.password), []byte(tt.salt), tt.iter, tt.keyLen))
+		if got != tt.want {
+			t.Errorf("pbkdf2(%q, %q, %d, %d) = %s, want %s", tt.password, tt.salt, tt.iter, tt.keyLen, got, tt.want)
+		}
+	}
+}
+
+func TestPassphraseKeyProvider(t *testing.T) {
+	t.Setenv("MK_TEST_PASS", "correct horse")
+	p := PassphraseKeyProvider{
+		Passphrase: PassphraseFromEnv("MK_TEST_PASS"),
+		SaltPath:   filepath.Join(t.TempDir(), "state.salt"),
+		Iterations: 1000,
+	}
+	k1, err := p.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 盐已经保存，同一口令派生出同一密钥
+	k2, err := p.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(k1) != 32 || !bytes.Equal(k1, k2) {
+		t.Fatalf("keys differ: %x %x", k1, k2)
+	}
+	t.Setenv("MK_TEST_PASS", "")
+	if _, err := p.Key(); err == nil {
+		t.Fatal("empty passphrase accepted")
+	}
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{5}, 32)
+	path := writeKeyFile(t, dir, "state.key", key)
+	got, err := FileKeyProvider{Path: path}.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatal("key differs")
+	}
+
+	for _, perm := range []os.FileMode{0640, 0604, 0644} {
+		if err := os.Chmod(path, perm); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := (FileKeyProvider{Path: path}).Key(); err == nil || !strings.Contains(err.Error(), "permissions") {
+			t.Errorf("permissions %04o: err = %v, want permission error", perm, err)
+		}
+	}
+
+	short := writeKeyFile(t, dir, "short.key", []byte("too short"))
+	if _, err := (FileKeyProvider{Path: short}).Key(); err == nil || !strings.Contains(err.Error(), "invalid AES key length") {
+		t.Fatalf("short key: err = %v", err)
+	}
+}
+
+func TestEnvKeyProvider(t *testing.T) {
+	key := bytes.Repeat([]byte{0xff}, 16)
+	tests := []struct {
+		name  string
+		value string
+		want  []byte
+		err   string
+	}{
+		{"raw", strings.Repeat("k", 24), []byte(strings.Repeat("k", 24)), ""},
+		{"base64", "base64:" + base64.StdEncoding.EncodeToString(key), key, ""},
+		{"bad base64", "base64:not base64!", nil, "illegal base64"},
+		{"raw wrong length", "short", nil, "invalid AES key length 5"},
+		{"base64 wrong length", "base64:" + base64.StdEncoding.EncodeToString(key[:10]), nil, "invalid AES key length 10"},
+		{"empty", "", nil, "not set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MK_TEST_KEY", tt.value)
+			got, err := EnvKeyProvider{Name: "MK_TEST_KEY"}.Key()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Fatalf("key = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAgentKeyProvider(t *testing.T) {
+	// Unix socket 路径长度有限，不使用 t.TempDir 的长路径
+	dir, err := os.MkdirTemp("", "mk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	key := bytes.Repeat([]byte{3}, 32)
+	go ServeKeyAgent(l, func(name string) ([]byte, error) {
+		switch name {
+		case "state":
+			return bytes.Clone(key), nil
+		case "short":
+			return []byte("short"), nil
+		}
+		return nil, errors.New("no such key")
+	})
+
+	p, err := ParseKeySource("agent:" + socket + "#state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := p.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, key) {
+		t.Fatalf("key = %x, want %x", got, key)
+	}
+	if _, err := (AgentKeyProvider{Socket: socket, Name: "missing"}).Key(); err == nil || !strings.Contains(err.Error(), "no such key") {
+		t.Fatalf("missing key: err = %v", err)
+	}
+	if _, err := (AgentKeyProvider{Socket: socket, Name: "short"}).Key(); err == nil || !strings.Contains(err.Error(), "invalid AES key length") {
+		t.Fatalf("short key: err = %v", err)
+	}
+}
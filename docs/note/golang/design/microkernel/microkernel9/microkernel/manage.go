@@ -0,0 +1,156 @@
+package microkernel
+
+import (
+	"errors"
+	"sort"
+)
+
+// ServiceInfo 服务的运行信息
+type ServiceInfo struct {
+	Name  string
+	State ServiceState
+	// 状态 schema 版本，见 Versioned
+	StateVersion int
+	Dependencies []string
+	// 依赖此服务的服务
+	Dependents []string
+	Restart    RestartPolicy
+	Restarts   int
+	Persist    PersistPolicy
+	// 服务实现的可选接口
+	Streaming    bool
+	Exportable   bool
+	Reconfigures bool
+	// 服务声明的事件类型，见 EventTyper
+	EventTypes []string
+}
+
+// Services 按名称排序返回所有已注册服务的信息
+func (k *MicroKernel) Services() []ServiceInfo {
+	k.mu.RLock()
+	infos := make([]ServiceInfo, 0, len(k.services))
+	for name := range k.services {
+		infos = append(infos, k.serviceInfoLocked(name))
+	}
+	k.mu.RUnlock()
+	for i := range infos {
+		infos[i].Persist = k.persistPolicy(infos[i].Name)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// ServiceInfo 返回服务的信息
+func (k *MicroKernel) ServiceInfo(name string) (ServiceInfo, bool) {
+	k.mu.RLock()
+	_, ok := k.services[name]
+	var info ServiceInfo
+	if ok {
+		info = k.serviceInfoLocked(name)
+	}
+	k.mu.RUnlock()
+	if ok {
+		info.Persist = k.persistPolicy(name)
+	}
+	return info, ok
+}
+
+// serviceInfoLocked 调用方持有 k.mu；持久化策略由调用方在释放锁后填写
+func (k *MicroKernel) serviceInfoLocked(name string) ServiceInfo {
+	meta := k.services[name]
+	_, streaming := meta.svc.(StreamHandler)
+	_, reconfigures := meta.svc.(Reconfigurable)
+	info := ServiceInfo{
+		Name:         name,
+		State:        meta.state,
+		StateVersion: stateVersion(meta.svc),
+		Dependencies: append([]string{}, meta.deps...),
+		Dependents:   []string{},
+		Restart:      meta.restart,
+		Restarts:     meta.restarts,
+		Streaming:    streaming,
+		Exportable:   canExport(meta.svc),
+		Reconfigures: reconfigures,
+	}
+	if et, ok := meta.svc.(EventTyper); ok {
+		info.EventTypes = append([]string{}, et.EventTypes()...)
+	}
+	for other, m := range k.services {
+		for _, dep := range m.deps {
+			if dep == name {
+				info.Dependents = append(info.Dependents, other)
+			}
+		}
+	}
+	sort.Strings(info.Dependents)
+	return info
+}
+
+// RestartService 保存状态后停止并重新启动服务，已停止的服务直接启动
+func (k *MicroKernel) RestartService(name string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	meta, ok := k.services[name]
+	if !ok {
+		return errors.New("service not registered")
+	}
+	if meta.state == Running {
+		if err := k.stopLocked(meta); err != nil {
+			return err
+		}
+	}
+	if err := k.startLocked(meta); err != nil {
+		return err
+	}
+	k.log.Info("service restarted", "service", name, "took", meta.startTime)
+	return nil
+}
+
+// PersistService 立即保存服务的状态
+func (k *MicroKernel) PersistService(name string) (SaveReport, error) {
+	svc, ok := k.Service(name)
+	if !ok {
+		return SaveReport{}, errors.New("service not registered")
+	}
+	if k.stateStore == nil {
+		return SaveReport{}, errors.New("kernel has no state store")
+	}
+	if !canExport(svc) {
+		return SaveReport{}, errNotExportable
+	}
+	r := k.persistReport(svc)
+	return r, r.Err
+}
+
+// PersistAll 立即保存所有运行中且支持导出的服务的状态
+func (k *MicroKernel) PersistAll() []SaveReport {
+	k.mu.RLock()
+	var svcs []Service
+	for _, meta := range k.services {
+		if meta.state == Running && canExport(meta.svc) {
+			svcs = append(svcs, meta.svc)
+		}
+	}
+	k.mu.RUnlock()
+	if k.stateStore == nil {
+		return nil
+	}
+	sort.Slice(svcs, func(i, j int) bool { return svcs[i].Name() < svcs[j].Name() })
+	reports := make([]SaveReport, 0, len(svcs))
+	for _, svc := range svcs {
+		reports = append(reports, k.persistReport(svc))
+	}
+	return reports
+}
+
+// ExportState 导出服务当前的状态，返回未加密的信封，供管理工具查看
+func (k *MicroKernel) ExportState(name string) (*StateEnvelope, error) {
+	svc, ok := k.Service(name)
+	if !ok {
+		return nil, errors.New("service not registered")
+	}
+	if !canExport(svc) {
+		return nil, errNotExportable
+	}
+	return exportEnvelope(svc)
+}
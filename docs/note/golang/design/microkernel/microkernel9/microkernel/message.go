@@ -7,7 +7,8 @@ type Event struct {
 	Type    string
 	Content string
 	// 增加响应通道,使用 chan Reply，提高回复的灵活性
-	ReplyCh chan Reply
+	// 回复通道不参与序列化（例如发送给子进程服务时）
+	ReplyCh chan Reply `json:"-"`
 	// 可选：超时时间
 	TimeoutMs int
 }
@@ -0,0 +1,151 @@
+package microkernel
+
+import (
+	"errors"
+	"microkernel/metrics"
+	"strconv"
+	"time"
+)
+
+// MetricsRegistrar 服务可选实现：注册时在内核的指标注册表中注册自己的指标
+// 指标名称建议以服务类型为前缀，热替换后同名指标继续累计
+type MetricsRegistrar interface {
+	RegisterMetrics(reg *metrics.Registry) error
+}
+
+// sizeBuckets 状态大小的桶（字节），从 256B 到 64MiB
+var sizeBuckets = metrics.ExponentialBuckets(256, 4, 10)
+
+// kernelMetrics 内核内置的指标
+type kernelMetrics struct {
+	events    *metrics.CounterVec
+	replies   *metrics.CounterVec
+	latency   *metrics.HistogramVec
+	inflight  *metrics.Gauge
+	panics    *metrics.CounterVec
+	restarts  *metrics.CounterVec
+	saveTime  *metrics.HistogramVec
+	saveBytes *metrics.HistogramVec
+	loadTime  *metrics.HistogramVec
+	loadBytes *metrics.HistogramVec
+	stateErrs *metrics.CounterVec
+	crypto    *metrics.CounterVec
+	// 按原因统计的死信
+	deadLetters *metrics.CounterVec
+}
+
+// newKernelMetrics 在注册表中注册内核指标，名称固定，注册失败说明注册表中已有冲突的指标
+func newKernelMetrics(reg *metrics.Registry, k *MicroKernel) (*kernelMetrics, error) {
+	m := &kernelMetrics{}
+	var errs []error
+	counter := func(name, help string, labels ...string) *metrics.CounterVec {
+		v, err := reg.CounterVec(name, help, labels...)
+		errs = append(errs, err)
+		return v
+	}
+	histogram := func(name, help string, buckets []float64, labels ...string) *metrics.HistogramVec {
+		v, err := reg.HistogramVec(name, help, buckets, labels...)
+		errs = append(errs, err)
+		return v
+	}
+	m.events = counter("microkernel_events_handled_total", "Events handled by each service.", "service")
+	m.replies = counter("microkernel_replies_total", "Replies by service and reply code.", "service", "code")
+	m.latency = histogram("microkernel_handler_duration_seconds", "Time spent in service handlers.", metrics.DefBuckets, "service")
+	m.panics = counter("microkernel_service_panics_total", "Handler panics recovered by the kernel.", "service")
+	m.restarts = counter("microkernel_service_restarts_total", "Service restarts after a panic.", "service")
+	m.saveTime = histogram("microkernel_state_save_duration_seconds", "Time spent saving service state.", metrics.DefBuckets, "service")
+	m.saveBytes = histogram("microkernel_state_save_bytes", "Size of saved service state.", sizeBuckets, "service")
+	m.loadTime = histogram("microkernel_state_load_duration_seconds", "Time spent loading service state.", metrics.DefBuckets, "service")
+	m.loadBytes = histogram("microkernel_state_load_bytes", "Size of loaded service state.", sizeBuckets, "service")
+	m.stateErrs = counter("microkernel_state_errors_total", "Failed state saves and loads.", "service", "op")
+	m.crypto = counter("microkernel_crypto_failures_total", "Encryption and decryption failures by reason.", "op", "reason")
+	m.deadLetters = counter("microkernel_dead_letters_total", "Events moved to the dead letter queue.", "reason")
+	inflight, err := reg.Gauge("microkernel_inflight_handlers", "Handlers currently running.")
+	errs = append(errs, err)
+	m.inflight = inflight
+	errs = append(errs,
+		reg.GaugeFunc("microkernel_queue_depth", "Events waiting in the kernel queue.",
+			func() float64 { return float64(len(k.eventCh)) }),
+		reg.GaugeFunc("microkernel_queue_capacity", "Capacity of the kernel event queue.",
+			func() float64 { return float64(cap(k.eventCh)) }),
+		reg.GaugeFunc("microkernel_dead_letters", "Events currently in the dead letter queue.",
+			func() float64 {
+				k.dead.mu.Lock()
+				defer k.dead.mu.Unlock()
+				return float64(len(k.dead.letters))
+			}),
+	)
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// handleStarted 记录开始处理事件，返回的函数在处理结束时以回复码调用
+func (m *kernelMetrics) handleStarted(service string) func(code int) {
+	start := time.Now()
+	m.events.With(service).Inc()
+	m.inflight.Inc()
+	return func(code int) {
+		m.inflight.Dec()
+		m.latency.With(service).ObserveDuration(time.Since(start))
+		m.reply(service, code)
+	}
+}
+
+func (m *kernelMetrics) reply(service string, code int) {
+	m.replies.With(service, strconv.Itoa(code)).Inc()
+}
+
+// stateSaved 记录一次状态保存，不支持导出的服务不计
+func (m *kernelMetrics) stateSaved(r SaveReport) {
+	if errors.Is(r.Err, errNotExportable) {
+		return
+	}
+	m.observeState(r.Service, "save", r.Latency, r.Bytes, r.Err)
+}
+
+func (m *kernelMetrics) observeState(service, op string, d time.Duration, n int, err error) {
+	if err != nil {
+		m.stateErrs.With(service, op).Inc()
+		m.cryptoFailed(op, err)
+		return
+	}
+	if op == "save" {
+		m.saveTime.With(service).ObserveDuration(d)
+		m.saveBytes.With(service).Observe(float64(n))
+	} else {
+		m.loadTime.With(service).ObserveDuration(d)
+		m.loadBytes.With(service).Observe(float64(n))
+	}
+}
+
+// cryptoFailed 错误由加解密失败引起时计数，op 为发生错误的操作
+func (m *kernelMetrics) cryptoFailed(op string, err error) {
+	var reason string
+	switch {
+	case errors.Is(err, ErrIntegrity):
+		reason = "integrity"
+	case errors.Is(err, ErrUnknownKey):
+		reason = "unknown_key"
+	case errors.Is(err, ErrWriteOnly):
+		reason = "write_only"
+	default:
+		return
+	}
+	m.crypto.With(op, reason).Inc()
+}
+
+// Metrics 内核的指标注册表，包含内核指标和服务注册的指标
+func (k *MicroKernel) Metrics() *metrics.Registry {
+	return k.metricsReg
+}
+
+// injectMetrics 服务实现 MetricsRegistrar 时注册服务的指标，失败时只记录日志
+func (k *MicroKernel) injectMetrics(svc Service) {
+	if mr, ok := svc.(MetricsRegistrar); ok {
+		if err := mr.RegisterMetrics(k.metricsReg); err != nil {
+			k.log.Warn("service metrics not registered", "service", svc.Name(), "err", err)
+		}
+	}
+}
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"microkernel/logger"
+	"microkernel/metrics"
 	"os"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,24 +18,72 @@ type MicroKernel struct {
 	// 注册的服务通道
 	services map[string]*serviceMeta // 去除meta后可以直接修改，services map 就同步修改了
 	// 状态存储
-	stateStore *StateStore
+	stateStore StateStore
 	// 保护 services 的并发访问
 	// 重命名mutex 为mu
 	mu sync.RWMutex
 	// 全局事件总线
 	eventCh chan Event
-	// 日志
+	// 日志，SetLogger 可以替换；服务通过 LoggerSetter 得到派生的日志器
 	log *logger.Logger
+	// 内核事件订阅者
+	events eventHub
+	// 状态持久化策略和脏标记
+	persister persister
+	// 快照暂停事件分发的请求，由 Listen 处理
+	pauseCh chan pauseRequest
+	// 正在处理的事件
+	inflight sync.WaitGroup
+	// Listen 是否在运行
+	listening atomic.Bool
+	// 未能投递或处理的事件
+	dead deadLetters
+	// 指标注册表和内核指标
+	metricsReg *metrics.Registry
+	metrics    *kernelMetrics
 }
 
+// DefaultQueueSize 事件队列默认长度
+const DefaultQueueSize = 100
+
 // NewMicroKernel 创建微内核实例
-func NewMicroKernel(store *StateStore) *MicroKernel {
-	return &MicroKernel{
+func NewMicroKernel(store StateStore) *MicroKernel {
+	return NewMicroKernelWithQueue(store, DefaultQueueSize)
+}
+
+// NewMicroKernelWithQueue 创建微内核实例并指定事件队列长度
+func NewMicroKernelWithQueue(store StateStore, queueSize int) *MicroKernel {
+	k := &MicroKernel{
 		services:   make(map[string]*serviceMeta),
-		eventCh:    make(chan Event, 100),
+		eventCh:    make(chan Event, queueSize),
+		pauseCh:    make(chan pauseRequest),
 		stateStore: store,
-		log:        logger.NewLogger("kernel", logger.INFO, os.Stdout),
+		log:        logger.New("kernel", nil, logger.Options{Sink: newLogSinks()}),
+		metricsReg: metrics.NewRegistry(),
+	}
+	m, err := newKernelMetrics(k.metricsReg, k)
+	if err != nil {
+		// 新的注册表中不会冲突
+		panic(err)
+	}
+	k.metrics = m
+	k.persister.wake = make(chan struct{}, 1)
+	// 状态回退通过内核事件报告
+	if r, ok := store.(fallbackReporter); ok {
+		r.SetFallbackHandler(func(f StateFallback) {
+			msg := fmt.Sprintf("service %s: state fell back to generation %d: %v", f.Name, f.Generation, f.Err)
+			k.log.Warn("state fell back to older generation", "service", f.Name, "generation", f.Generation, "err", f.Err)
+			k.metrics.cryptoFailed("decrypt", f.Err)
+			k.emit(EventStateFallback, msg)
+		})
 	}
+	// 存储在内核创建之前打开，修复记录在这里报告
+	if r, ok := store.(repairReporter); ok {
+		if err := r.Repaired(); err != nil {
+			k.log.Warn("state store repaired", "err", err)
+		}
+	}
+	return k
 }
 
 // Register 注册服务
@@ -48,16 +99,13 @@ func (k *MicroKernel) Register(svc Service) error {
 		// 查看服务是否支持状态导入
 		// 状态导入不要求每个服务必须实现
 		// 如果没有实现，就直接忽略
-		if importer, ok := svc.(Importable); ok {
-			raw, err := k.stateStore.Load(name)
+		if canImport(svc) {
+			start := time.Now()
+			n, err := k.loadState(svc)
+			k.metrics.observeState(name, "load", time.Since(start), n, err)
 			if err != nil {
-				return fmt.Errorf("state load failed: %w\n", err)
-			}
-			err = importer.ImportState(raw)
-			if err != nil {
-				return fmt.Errorf("state import failed: %w\n", err)
+				return err
 			}
-			fmt.Printf("State migrated for service %s\n", name)
 		}
 	}
 	if _, ok := k.services[name]; ok {
@@ -68,7 +116,159 @@ func (k *MicroKernel) Register(svc Service) error {
 		state: Created,
 		deps:  svc.Dependencies(),
 	}
-	fmt.Println("Registered:", svc.Name())
+	k.trackPersist(svc)
+	k.injectLogger(svc)
+	k.injectMetrics(svc)
+	k.log.Info("service registered", "service", name)
+	// 依赖的服务可以稍后注册，这里只提示，StartAll 时仍缺失才返回错误
+	for _, dep := range k.services[name].deps {
+		if _, ok := k.services[dep]; !ok {
+			k.log.Warn("service depends on unregistered service", "service", name, "dependency", dep)
+			k.emit(EventDependencyMissing, fmt.Sprintf("service=%s dependency=%s", name, dep))
+		}
+	}
+	return nil
+}
+
+// loadState 从状态存储导入服务状态，返回状态大小
+func (k *MicroKernel) loadState(svc Service) (int, error) {
+	name := svc.Name()
+	// 流式保存的状态直接流式导入，否则走信封路径
+	n, err := k.loadStream(svc)
+	switch {
+	case err == nil:
+		k.log.Info("state streamed", "service", name)
+		return n, nil
+	case !errors.Is(err, ErrNotStream):
+		return n, fmt.Errorf("state import failed: %w", err)
+	}
+	raw, err := k.stateStore.Load(name)
+	if err != nil {
+		return 0, fmt.Errorf("state load failed: %w", err)
+	}
+	// 状态按信封中的版本执行迁移链后再导入
+	env, err := decodeEnvelope(name, raw)
+	if err != nil {
+		return 0, fmt.Errorf("state load failed: %w", err)
+	}
+	if err := importEnvelope(svc, env); err != nil {
+		return 0, fmt.Errorf("state import failed: %w", err)
+	}
+	k.log.Info("state migrated", "service", name)
+	return len(env.Data), nil
+}
+
+// Logger 内核的日志器，服务和第三方库（经 Handler 适配 slog）可以共用同一管道
+func (k *MicroKernel) Logger() *logger.Logger {
+	return k.log
+}
+
+// SetLogger 替换内核的日志器，应在注册服务之前调用，已注册服务的日志器不会改变
+// 嵌入到其他程序时可以传入 logger.Discard() 关闭内核输出
+func (k *MicroKernel) SetLogger(l *logger.Logger) {
+	k.log = l
+}
+
+// logStdout 默认日志器中标准输出的名称
+const logStdout = "stdout"
+
+// newLogSinks 默认日志器的输出，初始只有标准输出，可以通过 AddLogSink 添加
+func newLogSinks() *logger.FanOut {
+	fan := logger.NewFanOut()
+	fan.Add(logStdout, logger.NewWriterSink(os.Stdout, nil), logger.TRACE)
+	return fan
+}
+
+// AddLogSink 在内核日志管道中添加输出，服务的日志也会写入
+// SetLogger 替换的日志器输出不是 FanOut 时返回错误
+func (k *MicroKernel) AddLogSink(name string, sink logger.Sink, level logger.LogLevel) error {
+	fan, ok := k.log.Sink().(*logger.FanOut)
+	if !ok {
+		return errors.New("kernel logger does not support extra sinks")
+	}
+	return fan.Add(name, sink, level)
+}
+
+// RemoveLogSink 移除并关闭 AddLogSink 添加的输出
+func (k *MicroKernel) RemoveLogSink(name string) error {
+	fan, ok := k.log.Sink().(*logger.FanOut)
+	if !ok {
+		return errors.New("kernel logger does not support extra sinks")
+	}
+	return fan.Remove(name)
+}
+
+// ServiceLogger 从内核日志器派生服务的日志器，级别可以按服务单独设置
+func (k *MicroKernel) ServiceLogger(name string) *logger.Logger {
+	return k.log.Named(name)
+}
+
+// injectLogger 服务实现 LoggerSetter 时注入派生的日志器
+func (k *MicroKernel) injectLogger(svc Service) {
+	if ls, ok := svc.(LoggerSetter); ok {
+		ls.SetLogger(k.ServiceLogger(svc.Name()))
+	}
+}
+
+// eventLogger 带有事件字段的日志器
+func (k *MicroKernel) eventLogger(evt Event) *logger.Logger {
+	return k.log.With("type", evt.Type, "from", evt.From, "to", evt.To)
+}
+
+// Service 返回已注册的服务
+func (k *MicroKernel) Service(name string) (Service, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	meta, ok := k.services[name]
+	if !ok {
+		return nil, false
+	}
+	return meta.svc, true
+}
+
+// setDependencies 服务的依赖设置为服务自己声明的依赖加上 deps（来自配置文件）
+func (k *MicroKernel) setDependencies(name string, deps ...string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	meta, ok := k.services[name]
+	if !ok {
+		return
+	}
+	meta.deps = slices.Clone(meta.svc.Dependencies())
+	for _, dep := range deps {
+		if !slices.Contains(meta.deps, dep) {
+			meta.deps = append(meta.deps, dep)
+		}
+	}
+}
+
+// Unregister 注销服务，运行中的服务先停止（按持久化策略保存状态）
+// 仍有其他服务依赖它时返回错误
+func (k *MicroKernel) Unregister(name string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for other, m := range k.services {
+		if other != name && slices.Contains(m.deps, name) {
+			return fmt.Errorf("service %s depends on %s", other, name)
+		}
+	}
+	return k.unregisterLocked(name)
+}
+
+// unregisterLocked 停止并注销服务，不检查依赖它的服务，调用方持有 k.mu
+func (k *MicroKernel) unregisterLocked(name string) error {
+	meta, ok := k.services[name]
+	if !ok {
+		return errors.New("service not registered")
+	}
+	if meta.state == Running {
+		if err := k.stopLocked(meta); err != nil {
+			return err
+		}
+	}
+	delete(k.services, name)
+	k.untrackPersist(name)
+	k.log.Info("service unregistered", "service", name)
 	return nil
 }
 
@@ -82,11 +282,21 @@ func (k *MicroKernel) StartService(name string) error {
 	if meta.state == Running {
 		return errors.New("service already started")
 	}
+	if err := k.startLocked(meta); err != nil {
+		return err
+	}
+	k.log.Info("service started", "service", name, "took", meta.startTime)
+	return nil
+}
+
+// startLocked 启动服务并记录启动耗时，调用方持有 k.mu
+func (k *MicroKernel) startLocked(meta *serviceMeta) error {
+	start := time.Now()
 	if err := meta.svc.Start(); err != nil {
 		return err
 	}
+	meta.startTime = time.Since(start)
 	meta.state = Running
-	fmt.Println("Started:", meta.svc.Name())
 	return nil
 }
 
@@ -100,17 +310,21 @@ func (k *MicroKernel) StopService(name string) error {
 	if meta.state == Stopped {
 		return errors.New("service already stopped")
 	}
-	// 增加状态导出判断
-	if exporter, ok := meta.svc.(Exportable); ok && k.stateStore != nil {
-		if err := k.stateStore.Save(name, exporter.ExportState()); err == nil {
-			fmt.Printf("State persisted for %s\n", name)
-		}
+	return k.stopLocked(meta)
+}
+
+// stopLocked 保存状态后停止服务，调用方持有 k.mu
+func (k *MicroKernel) stopLocked(meta *serviceMeta) error {
+	name := meta.svc.Name()
+	// 增加状态导出判断，除 PersistNever 外停止时都保存一次
+	if canExport(meta.svc) && k.stateStore != nil && k.persistPolicy(name).Mode != PersistNever {
+		k.persistReport(meta.svc)
 	}
 	if err := meta.svc.Stop(); err != nil {
 		return err
 	}
 	meta.state = Stopped
-	fmt.Println("Stopped:", meta.svc.Name())
+	k.log.Info("service stopped", "service", name)
 	return nil
 }
 
@@ -120,8 +334,7 @@ func (k *MicroKernel) StartAll() error {
 	if err != nil {
 		return err
 	}
-	fmt.Println("Starting all services...")
-	fmt.Println("Services:", sorted)
+	k.log.Info("starting all services", "order", sorted)
 	for _, name := range sorted {
 		err := k.StartService(name)
 		if err != nil {
@@ -137,7 +350,7 @@ func (k *MicroKernel) StopAll() error {
 	if err != nil {
 		return err
 	}
-	fmt.Println("Stopping all services...")
+	k.log.Info("stopping all services")
 	// 逆序停止服务
 	for i := len(sorted) - 1; i >= 0; i-- {
 		err := k.StopService(sorted[i])
@@ -148,47 +361,9 @@ func (k *MicroKernel) StopAll() error {
 	return nil
 }
 
+// topoSort 启动顺序，依赖缺失或有循环时返回列出全部问题的 *GraphError
 func (k *MicroKernel) topoSort() ([]string, error) {
-	k.mu.RLock()
-	defer k.mu.RUnlock()
-
-	visited := make(map[string]bool)
-	temp := make(map[string]bool)
-	var result []string
-	var visit func(string) error
-
-	visit = func(name string) error {
-		if temp[name] {
-			return fmt.Errorf("circular dependency at %s", name)
-		}
-		if visited[name] {
-			return nil
-		}
-		temp[name] = true
-		meta, ok := k.services[name]
-		if !ok {
-			return fmt.Errorf("service %s not registered", name)
-		}
-		for _, dep := range meta.deps {
-			if err := visit(dep); err != nil {
-				return err
-			}
-		}
-		visited[name] = true
-		temp[name] = false
-		result = append(result, name)
-		return nil
-	}
-
-	for name := range k.services {
-		if !visited[name] {
-			if err := visit(name); err != nil {
-				return nil, err
-			}
-		}
-	}
-
-	return result, nil
+	return k.Graph().StartOrder()
 }
 
 // Push 发送事件到内核（模拟 IPC）
@@ -197,77 +372,164 @@ func (k *MicroKernel) Push(evt Event) {
 	k.eventCh <- evt
 }
 
+// TryPush 非阻塞地发送事件到内核，队列已满时丢弃并返回 false
+// 用于日志转发等不能阻塞调用方的场景
+func (k *MicroKernel) TryPush(evt Event) bool {
+	select {
+	case k.eventCh <- evt:
+		return true
+	default:
+		return false
+	}
+}
+
+// PushContext 发送事件到内核，队列已满时等待直到 ctx 结束，返回 ctx 的错误
+// 用于请求方可能放弃等待的场景（如 HTTP 请求），调用方不会因队列满一直阻塞
+func (k *MicroKernel) PushContext(ctx context.Context, evt Event) error {
+	select {
+	case k.eventCh <- evt:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Send 处理事件（模拟服务间通信）
 // HandleEvent 重命名为 Send
 func (k *MicroKernel) Send(evt Event) (msg Reply) {
 	k.mu.RLock()
 	defer k.mu.RUnlock()
-	fmt.Printf("[MicroKernel] Send Event to %s: %s\n", evt.To, evt.Content)
+	log := k.eventLogger(evt)
+	log.Debug("send event", "content", evt.Content)
 
 	if meta, ok := k.services[evt.To]; ok {
-		return meta.svc.Handle(evt)
+		reply := k.handle(meta, evt)
+		log.Debug("event handled", "code", reply.Code)
+		return reply
 	} else {
+		log.Warn("service not found", "code", 404)
 		return Reply{Code: 404, Message: "Not found service", Data: ""}
 	}
 }
 
+// Stream 流式调用服务
+// 服务实现了 StreamHandler 时逐条返回回复，否则只返回 Handle 的一条回复
+// 所有回复返回后关闭通道
+func (k *MicroKernel) Stream(ctx context.Context, evt Event) (<-chan Reply, error) {
+	k.mu.RLock()
+	meta, ok := k.services[evt.To]
+	running := ok && meta.state == Running
+	k.mu.RUnlock()
+	if !running {
+		return nil, fmt.Errorf("service %s unavailable", evt.To)
+	}
+
+	out := make(chan Reply)
+	m := k.metrics
+	m.events.With(evt.To).Inc()
+	m.inflight.Inc()
+	go func() {
+		defer m.inflight.Dec()
+		defer close(out)
+		sh, ok := meta.svc.(StreamHandler)
+		if !ok {
+			reply := meta.svc.Handle(evt)
+			m.reply(evt.To, reply.Code)
+			select {
+			case out <- reply:
+			case <-ctx.Done():
+			}
+			return
+		}
+		// 调用方放弃读取后继续消费服务的回复，避免服务阻塞
+		in := make(chan Reply)
+		go func() {
+			defer close(in)
+			sh.HandleStream(ctx, evt, in)
+		}()
+		for r := range in {
+			m.reply(evt.To, r.Code)
+			select {
+			case out <- r:
+			case <-ctx.Done():
+			}
+		}
+	}()
+	return out, nil
+}
+
 // Listen 事件循环（处理服务间通信）
 // 监听事件，处理服务间通信
 // 重命名 EventLoop 为 Listen
 func (k *MicroKernel) Listen(ctx context.Context) {
-	// 间隔可以加在kernel的struct中，也可以使用方法来获取
-	ticker := time.NewTicker(2 * time.Second)
+	// 状态持久化在独立的工作协程中进行，按服务的持久化策略保存
+	if k.stateStore != nil {
+		go k.persistLoop(ctx)
+	}
 
+	k.listening.Store(true)
+	defer k.listening.Store(false)
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-ticker.C:
-			fmt.Println("Timed writing state")
-			if k.stateStore != nil {
-				for name, meta := range k.services {
-					if meta.state == Running {
-						if exporter, ok := meta.svc.(Exportable); ok {
-							if err := k.stateStore.Save(name, exporter.ExportState()); err == nil {
-								fmt.Printf("State persisted for %s\n", name)
-							}
-						}
-					}
-				}
+		case req := <-k.pauseCh:
+			// 快照屏障：等待处理中的事件完成，交出队列中的事件，恢复后优先分发
+			k.inflight.Wait()
+			req.paused <- drainEvents(k.eventCh)
+			for _, evt := range <-req.resume {
+				k.dispatch(evt)
 			}
 		case evt := <-k.eventCh:
-			fmt.Printf("[MicroKernel] Event from %s: - %s\n", evt.From, evt.Content)
-			// 发送给 MicroKernel 自己
-			if evt.To == "" {
-				if evt.ReplyCh != nil {
-					evt.ReplyCh <- Reply{Code: 0, Message: "Handled by kernel", Data: "ok"}
-				}
-				continue
-			}
-			// 路由到目标服务
-			k.mu.RLock()
-			meta, ok := k.services[evt.To]
-			k.mu.RUnlock()
-
-			if !ok || meta.state != Running {
-				if evt.ReplyCh != nil {
-					evt.ReplyCh <- Reply{Code: 404, Message: "service unavailable", Data: ""}
+			k.dispatch(evt)
+		}
+	}
+}
+
+// dispatch 把事件路由到目标服务，服务在独立的协程中处理
+func (k *MicroKernel) dispatch(evt Event) {
+	log := k.eventLogger(evt)
+	log.Debug("dispatch event", "content", evt.Content)
+	// 发送给 MicroKernel 自己
+	if evt.To == "" {
+		if evt.ReplyCh != nil {
+			evt.ReplyCh <- Reply{Code: 0, Message: "Handled by kernel", Data: "ok"}
+		}
+		return
+	}
+	// 路由到目标服务
+	k.mu.RLock()
+	meta, ok := k.services[evt.To]
+	k.mu.RUnlock()
+
+	if !ok || meta.state != Running {
+		log.Warn("service unavailable", "code", 404)
+		k.deadLetter(evt, DeadUnavailable, 404, "service unavailable")
+		if evt.ReplyCh != nil {
+			evt.ReplyCh <- Reply{Code: 404, Message: "service unavailable", Data: ""}
+		}
+		return
+	}
+	// 调用目标服务处理，并返回
+	k.inflight.Add(1)
+	go func(meta *serviceMeta, m Event) {
+		defer k.inflight.Done()
+		result := k.handle(meta, m)
+		log.Debug("event handled", "code", result.Code)
+		if m.ReplyCh != nil {
+			select {
+			case m.ReplyCh <- result:
+			case <-time.After(time.Duration(m.TimeoutMs) * time.Millisecond):
+				log.Warn("reply timed out", "code", 408, "timeout_ms", m.TimeoutMs)
+				k.deadLetter(m, DeadReplyTimeout, 408, fmt.Sprintf("reply not received within %dms", m.TimeoutMs))
+				// 调用方已经放弃读取时不能阻塞，否则 inflight 永远不会完成，快照屏障会卡住事件循环
+				select {
+				case m.ReplyCh <- Reply{Code: 408, Message: "timeout", Data: ""}:
+				default:
 				}
-				continue
 			}
-			// 调用目标服务处理，并返回
-			go func(s Service, m Event) {
-				result := s.Handle(m)
-				if m.ReplyCh != nil {
-					select {
-					case m.ReplyCh <- result:
-					case <-time.After(time.Duration(m.TimeoutMs) * time.Millisecond):
-						m.ReplyCh <- Reply{Code: 408, Message: "timeout", Data: ""}
-					}
-				}
-			}(meta.svc, evt)
 		}
-	}
+	}(meta, evt)
 }
 
 //func (k *MicroKernel) ReplaceService(newSvc Service) error {
@@ -283,7 +545,7 @@ func (k *MicroKernel) Listen(ctx context.Context) {
 //			state = exporter.ExportState()
 //		}
 //		oldMeta.svc.Stop()
-//		fmt.Printf("Stopped old version of %s\n", name)
+//		k.log.Info("stopped old version", "service", name)
 //	}
 //
 //	// 状态迁移
@@ -313,6 +575,9 @@ func (k *MicroKernel) Listen(ctx context.Context) {
 //	return nil
 //}
 
+// hotReplaceStoreID 热替换时密文的存储 ID
+const hotReplaceStoreID = "hot-replace"
+
 func (k *MicroKernel) ReplaceServiceEncrypted(newSvc Service, crypter Crypter) error {
 	k.mu.Lock()
 	defer k.mu.Unlock()
@@ -320,44 +585,108 @@ func (k *MicroKernel) ReplaceServiceEncrypted(newSvc Service, crypter Crypter) e
 	name := newSvc.Name()
 	oldMeta, exists := k.services[name]
 	var encryptedState []byte
+	var aad StateAAD
 
+	streamed := false
 	if exists {
-		if exporter, ok := oldMeta.svc.(Exportable); ok {
-			rawState := exporter.ExportState()
-			cipher, err := crypter.Encrypt(rawState)
+		if exporter, importer, sc, ok := streamPair(oldMeta.svc, newSvc, crypter); ok {
+			aad = StateAAD{StoreID: hotReplaceStoreID, Service: name, Version: stateVersion(newSvc)}
+			if err := transferStream(exporter, importer, sc, aad); err != nil {
+				return fmt.Errorf("state stream failed: %w", err)
+			}
+			streamed = true
+			k.log.Info("encrypted state streamed", "service", name)
+		}
+		if canExport(oldMeta.svc) && !streamed {
+			env, err := exportEnvelope(oldMeta.svc)
 			if err != nil {
+				return err
+			}
+			// 附加认证数据绑定服务名称和 schema 版本，密文只能导入同名服务
+			aad = StateAAD{StoreID: hotReplaceStoreID, Service: name, Version: env.Version}
+			cipher, err := crypter.Encrypt(env, aad)
+			if err != nil {
+				k.metrics.cryptoFailed("encrypt", err)
 				return fmt.Errorf("state encryption failed: %w", err)
 			}
 			encryptedState = cipher
 		}
-		oldMeta.svc.Stop()
-		fmt.Printf("Stopped old version of %s\n", name)
 	}
 
-	// 状态导入（解密）
-	if importer, ok := newSvc.(Importable); ok && encryptedState != nil {
-		decrypted, err := crypter.Decrypt(encryptedState)
+	// 状态导入（解密 + 迁移）
+	// 导入失败时旧版本继续运行
+	if canImport(newSvc) && encryptedState != nil {
+		decrypted, err := crypter.Decrypt(encryptedState, StateAAD{StoreID: aad.StoreID, Service: newSvc.Name(), Version: aad.Version})
 		if err != nil {
+			k.metrics.cryptoFailed("decrypt", err)
 			return fmt.Errorf("state decryption failed: %w", err)
 		}
-		if err := importer.ImportState(decrypted); err != nil {
+		env, err := decodeEnvelope(name, decrypted)
+		if err != nil {
+			return fmt.Errorf("state decryption failed: %w", err)
+		}
+		if err := importEnvelope(newSvc, env); err != nil {
 			return fmt.Errorf("state import failed: %w", err)
 		}
-		fmt.Printf("Encrypted state migrated for service %s\n", name)
+		k.log.Info("encrypted state migrated", "service", name)
 	}
 
-	k.services[name] = &serviceMeta{
+	k.swapLocked(newSvc)
+	return nil
+}
+
+// restoreService 撤销热替换：用旧版本替换服务，原样导入替换前导出的状态信封
+// 信封与旧版本的 schema 版本相同，不经过加密和迁移
+func (k *MicroKernel) restoreService(svc Service, env *StateEnvelope) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if env != nil && canImport(svc) {
+		if err := importEnvelope(svc, env); err != nil {
+			return fmt.Errorf("state restore failed: %w", err)
+		}
+		k.log.Info("state restored", "service", svc.Name(), "version", env.Version)
+	}
+	k.swapLocked(svc)
+	return nil
+}
+
+// exportService 导出已注册服务的状态信封，服务不存在或不支持导出时返回 nil
+func (k *MicroKernel) exportService(name string) (*StateEnvelope, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	meta, ok := k.services[name]
+	if !ok || !canExport(meta.svc) {
+		return nil, nil
+	}
+	return exportEnvelope(meta.svc)
+}
+
+// swapLocked 停止同名的旧版本并换上新版本，旧版本运行中时启动新版本，调用方持有 k.mu
+// 旧版本的依赖（包括配置追加的依赖）和重启策略沿用到新版本，重启次数重新计数
+func (k *MicroKernel) swapLocked(newSvc Service) {
+	name := newSvc.Name()
+	meta := &serviceMeta{
 		svc:   newSvc,
 		deps:  newSvc.Dependencies(),
 		state: Created,
 	}
+	oldMeta, exists := k.services[name]
+	if exists {
+		oldMeta.svc.Stop()
+		k.log.Info("stopped old version", "service", name)
+		meta.deps = mergeDeps(meta.deps, oldMeta.deps)
+		meta.restart = oldMeta.restart
+	}
+
+	k.services[name] = meta
+	k.trackPersist(newSvc)
+	k.injectLogger(newSvc)
+	k.injectMetrics(newSvc)
 	if exists && oldMeta.state == Running {
 		newSvc.Start()
 		k.services[name].state = Running
-		fmt.Printf("Started new version of %s\n", name)
+		k.log.Info("started new version", "service", name)
 	} else {
-		fmt.Printf("Registered new version of %s (not started)\n", name)
+		k.log.Info("registered new version (not started)", "service", name)
 	}
-
-	return nil
 }
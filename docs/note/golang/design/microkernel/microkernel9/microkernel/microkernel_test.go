@@ -0,0 +1,69 @@
+package microkernel
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReplaceKeepsDepsAndRestartPolicy(t *testing.T) {
+	k := NewMicroKernel(NewMemoryStateStore())
+	for _, name := range []string{"db", "cache"} {
+		if err := k.Register(&counter{name: name, version: 1}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := k.Register(&counter{name: "a", version: 1, deps: []string{"db"}, n: 3}); err != nil {
+		t.Fatal(err)
+	}
+	// 配置追加的依赖和重启策略
+	k.setDependencies("a", "cache")
+	policy := RestartPolicy{Mode: RestartOnFailure, MaxRestarts: 3, Backoff: time.Second}
+	if err := k.SetRestartPolicy("a", policy); err != nil {
+		t.Fatal(err)
+	}
+
+	crypter, err := newEphemeralCrypter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer crypter.Close()
+	if err := k.ReplaceServiceEncrypted(&counter{name: "a", version: 2}, crypter); err != nil {
+		t.Fatal(err)
+	}
+
+	meta := k.services["a"]
+	if want := []string{"db", "cache"}; !reflect.DeepEqual(meta.deps, want) {
+		t.Fatalf("deps = %v, want %v", meta.deps, want)
+	}
+	if meta.restart != policy {
+		t.Fatalf("restart = %+v, want %+v", meta.restart, policy)
+	}
+	if n := meta.svc.(*counter).n; n != 3 {
+		t.Fatalf("state = %d, want 3", n)
+	}
+}
+
+func TestReplyTimeoutDoesNotBlockInflight(t *testing.T) {
+	k := NewMicroKernel(NewMemoryStateStore())
+	if err := k.Register(&counter{name: "a", version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+	defer k.StopAll()
+
+	// 调用方放弃读取的无缓冲回复通道
+	k.dispatch(Event{To: "a", ReplyCh: make(chan Reply), TimeoutMs: 10})
+	done := make(chan struct{})
+	go func() {
+		k.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("inflight event never completed after its reply timed out")
+	}
+}
@@ -0,0 +1,295 @@
+package microkernel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// PersistMode 状态持久化方式
+type PersistMode int
+
+const (
+	// PersistInterval 按固定间隔保存
+	PersistInterval PersistMode = iota
+	// PersistOnChange 服务标记状态变化后，经过防抖时间保存
+	PersistOnChange
+	// PersistOnStop 只在服务停止时保存
+	PersistOnStop
+	// PersistNever 从不保存
+	PersistNever
+)
+
+func (m PersistMode) String() string {
+	return [...]string{"interval", "on-change", "on-stop", "never"}[m]
+}
+
+// PersistPolicy 服务的状态持久化策略
+// 除 PersistNever 外，服务停止时都会保存一次状态
+type PersistPolicy struct {
+	Mode PersistMode
+	// PersistInterval 的保存间隔
+	Interval time.Duration
+	// PersistOnChange 的防抖时间：最后一次 MarkDirty 之后经过该时间才保存
+	Debounce time.Duration
+	// PersistInterval 时只保存被标记为脏的状态
+	// 不调用 MarkDirty 的旧服务保持为 false，每个间隔都保存
+	TrackDirty bool
+}
+
+// DefaultPersistPolicy 服务未声明策略时使用
+var DefaultPersistPolicy = PersistPolicy{Mode: PersistInterval, Interval: 2 * time.Second}
+
+// PersistPolicyProvider 服务可选实现：声明状态持久化策略
+type PersistPolicyProvider interface {
+	PersistPolicy() PersistPolicy
+}
+
+// SaveReport 一次状态保存的结果
+type SaveReport struct {
+	Service string
+	Bytes   int // 信封负载大小
+	Latency time.Duration
+	Err     error
+}
+
+// EventStateSaved 状态保存完成（包括失败），Content 为 SaveReport 的文本
+const EventStateSaved = "state.saved"
+
+// 持久化工作协程检查到期保存的间隔
+const persistTick = 100 * time.Millisecond
+
+type persistEntry struct {
+	policy    PersistPolicy
+	dirty     bool
+	lastDirty time.Time
+	lastSave  time.Time
+}
+
+// persister 记录每个服务的持久化策略和脏标记
+type persister struct {
+	mu       sync.Mutex
+	entries  map[string]*persistEntry
+	override map[string]PersistPolicy
+	// 服务未声明策略时使用，nil 时为 DefaultPersistPolicy
+	defaults *PersistPolicy
+	wake     chan struct{}
+}
+
+// SetDefaultPersistPolicy 设置服务未声明策略时使用的持久化策略，对之后注册的服务生效
+func (k *MicroKernel) SetDefaultPersistPolicy(policy PersistPolicy) {
+	p := &k.persister
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.defaults = &policy
+}
+
+// SetPersistPolicy 覆盖服务的持久化策略，优先于服务自己声明的策略
+func (k *MicroKernel) SetPersistPolicy(name string, policy PersistPolicy) {
+	p := &k.persister
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.override == nil {
+		p.override = make(map[string]PersistPolicy)
+	}
+	p.override[name] = policy
+	if e, ok := p.entries[name]; ok {
+		e.policy = policy
+	}
+}
+
+// MarkDirty 服务通知内核其状态已变化
+func (k *MicroKernel) MarkDirty(name string) {
+	p := &k.persister
+	p.mu.Lock()
+	if e, ok := p.entries[name]; ok {
+		e.dirty = true
+		e.lastDirty = time.Now()
+	}
+	p.mu.Unlock()
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// trackPersist 注册或热替换服务时确定其持久化策略
+func (k *MicroKernel) trackPersist(svc Service) {
+	p := &k.persister
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.entries == nil {
+		p.entries = make(map[string]*persistEntry)
+	}
+	p.entries[svc.Name()] = &persistEntry{policy: p.policyFor(svc), lastSave: time.Now()}
+}
+
+// policyFor 按默认策略、服务声明、覆盖的优先级确定策略，调用方持有 p.mu
+func (p *persister) policyFor(svc Service) PersistPolicy {
+	policy := DefaultPersistPolicy
+	if p.defaults != nil {
+		policy = *p.defaults
+	}
+	if pp, ok := svc.(PersistPolicyProvider); ok {
+		policy = pp.PersistPolicy()
+	}
+	if o, ok := p.override[svc.Name()]; ok {
+		policy = o
+	}
+	return policy
+}
+
+// clearPersistPolicy 取消 SetPersistPolicy 的覆盖，服务恢复为自己声明的或默认的策略
+func (k *MicroKernel) clearPersistPolicy(svc Service) {
+	p := &k.persister
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.override, svc.Name())
+	if e, ok := p.entries[svc.Name()]; ok {
+		e.policy = p.policyFor(svc)
+	}
+}
+
+// refreshPersistPolicy 默认策略变化后重新确定已注册服务的策略，保留脏标记
+func (k *MicroKernel) refreshPersistPolicy(svc Service) {
+	p := &k.persister
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.entries[svc.Name()]; ok {
+		e.policy = p.policyFor(svc)
+	}
+}
+
+// untrackPersist 服务注销后不再持久化
+func (k *MicroKernel) untrackPersist(name string) {
+	p := &k.persister
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.entries, name)
+	delete(p.override, name)
+}
+
+func (k *MicroKernel) persistPolicy(name string) PersistPolicy {
+	p := &k.persister
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.entries[name]; ok {
+		return e.policy
+	}
+	return DefaultPersistPolicy
+}
+
+// persistLoop 持久化工作协程，由 Listen 启动
+// 与事件分发相互独立，保存慢不会阻塞事件处理
+func (k *MicroKernel) persistLoop(ctx context.Context) {
+	ticker := time.NewTicker(persistTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-k.persister.wake:
+		}
+		for _, svc := range k.duePersist(time.Now()) {
+			if r := k.persistReport(svc); r.Err != nil {
+				k.persistFailed(r.Service)
+			}
+		}
+	}
+}
+
+// duePersist 返回到期需要保存的运行中服务，并清除其脏标记
+func (k *MicroKernel) duePersist(now time.Time) []Service {
+	k.mu.RLock()
+	running := make(map[string]Service)
+	for name, meta := range k.services {
+		if meta.state == Running && canExport(meta.svc) {
+			running[name] = meta.svc
+		}
+	}
+	k.mu.RUnlock()
+
+	p := &k.persister
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var due []Service
+	for name, svc := range running {
+		e, ok := p.entries[name]
+		if !ok {
+			continue
+		}
+		var ready bool
+		switch e.policy.Mode {
+		case PersistInterval:
+			ready = now.Sub(e.lastSave) >= e.policy.Interval && (e.dirty || !e.policy.TrackDirty)
+		case PersistOnChange:
+			ready = e.dirty && now.Sub(e.lastDirty) >= e.policy.Debounce
+		}
+		if ready {
+			// 保存前清除脏标记，保存期间的修改会再次标记，保存失败时由 persistFailed 恢复
+			e.dirty = false
+			e.lastSave = now
+			due = append(due, svc)
+		}
+	}
+	return due
+}
+
+// persistFailed 保存失败后重新标记为脏，按策略的间隔或防抖时间重试，状态变化不会因为一次写入错误丢失
+func (k *MicroKernel) persistFailed(name string) {
+	p := &k.persister
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if e, ok := p.entries[name]; ok {
+		e.dirty = true
+		e.lastDirty = time.Now()
+	}
+}
+
+// persistReport 保存状态并报告耗时和大小
+func (k *MicroKernel) persistReport(svc Service) SaveReport {
+	start := time.Now()
+	n, err := k.persist(svc)
+	r := SaveReport{Service: svc.Name(), Bytes: n, Latency: time.Since(start), Err: err}
+	k.metrics.stateSaved(r)
+	if err != nil {
+		k.log.Error("state persist failed", "service", r.Service, "err", err)
+	} else {
+		k.log.Debug("state persisted", "service", r.Service, "bytes", r.Bytes, "latency", r.Latency)
+	}
+	k.emit(EventStateSaved, r.String())
+	return r
+}
+
+func (r SaveReport) String() string {
+	if r.Err != nil {
+		return fmt.Sprintf("service=%s bytes=%d latency=%s err=%q", r.Service, r.Bytes, r.Latency, r.Err)
+	}
+	return fmt.Sprintf("service=%s bytes=%d latency=%s", r.Service, r.Bytes, r.Latency)
+}
+
+// persist 导出服务状态，封装为信封后写入状态存储，返回负载大小
+func (k *MicroKernel) persist(svc Service) (int, error) {
+	if !canExport(svc) || k.stateStore == nil {
+		return 0, errNotExportable
+	}
+	// 服务和存储都支持流式状态时不经过信封，状态不会整体驻留内存
+	if exporter, ok := svc.(StreamExporter); ok {
+		if store, ok := k.stateStore.(StreamStateStore); ok {
+			n, err := persistStream(store, svc, exporter)
+			if !errors.Is(err, ErrStreamUnsupported) {
+				return n, err
+			}
+		}
+	}
+	env, err := exportEnvelope(svc)
+	if err != nil {
+		return 0, err
+	}
+	return len(env.Data), k.stateStore.Save(svc.Name(), env)
+}
+
+var errNotExportable = errors.New("state not exportable")
@@ -0,0 +1,137 @@
+package microkernel
+
+import (
+	"testing"
+	"time"
+)
+
+// persistKernel 注册并启动使用 policy 的服务 a，保存时间从 t0 开始计算
+func persistKernel(t *testing.T, policy PersistPolicy, t0 time.Time) (*MicroKernel, *MemoryStateStore) {
+	t.Helper()
+	store := NewMemoryStateStore()
+	k := NewMicroKernel(store)
+	k.SetPersistPolicy("a", policy)
+	if err := k.Register(&counter{name: "a", version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.StartService("a"); err != nil {
+		t.Fatal(err)
+	}
+	k.persister.entries["a"].lastSave = t0
+	return k, store
+}
+
+// markDirtyAt 与 MarkDirty 相同，但使用指定的时间
+func markDirtyAt(k *MicroKernel, name string, at time.Time) {
+	k.persister.mu.Lock()
+	defer k.persister.mu.Unlock()
+	e := k.persister.entries[name]
+	e.dirty = true
+	e.lastDirty = at
+}
+
+func TestDuePersist(t *testing.T) {
+	type step struct {
+		at    time.Duration
+		dirty bool // true 时在 at 标记为脏，否则检查 at 时是否到期
+		due   bool
+	}
+	ms := time.Millisecond
+	tests := []struct {
+		name   string
+		policy PersistPolicy
+		steps  []step
+	}{
+		{"interval", PersistPolicy{Mode: PersistInterval, Interval: time.Second}, []step{
+			{at: 500 * ms},
+			{at: 1000 * ms, due: true},
+			{at: 1500 * ms},
+			{at: 2000 * ms, due: true},
+		}},
+		{"interval tracking dirty", PersistPolicy{Mode: PersistInterval, Interval: time.Second, TrackDirty: true}, []step{
+			{at: 1000 * ms},
+			{at: 1200 * ms, dirty: true},
+			{at: 1500 * ms, due: true},
+			{at: 1600 * ms, dirty: true},
+			// 距上次保存不到一个间隔
+			{at: 2000 * ms},
+			{at: 2500 * ms, due: true},
+			{at: 5000 * ms},
+		}},
+		{"on change with debounce", PersistPolicy{Mode: PersistOnChange, Debounce: 500 * ms}, []step{
+			{at: 1000 * ms},
+			{at: 1000 * ms, dirty: true},
+			{at: 1400 * ms},
+			// 防抖期间再次修改，重新计时
+			{at: 1400 * ms, dirty: true},
+			{at: 1800 * ms},
+			{at: 1900 * ms, due: true},
+			{at: 3000 * ms},
+		}},
+		{"on stop", PersistPolicy{Mode: PersistOnStop}, []step{
+			{at: 0, dirty: true},
+			{at: time.Hour},
+		}},
+		{"never", PersistPolicy{Mode: PersistNever}, []step{
+			{at: 0, dirty: true},
+			{at: time.Hour},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t0 := time.Now()
+			k, _ := persistKernel(t, tt.policy, t0)
+			for _, s := range tt.steps {
+				if s.dirty {
+					markDirtyAt(k, "a", t0.Add(s.at))
+					continue
+				}
+				if due := len(k.duePersist(t0.Add(s.at))) == 1; due != s.due {
+					t.Fatalf("at %s: due = %v, want %v", s.at, due, s.due)
+				}
+			}
+		})
+	}
+}
+
+func TestPersistRetriesAfterFailure(t *testing.T) {
+	t0 := time.Now()
+	k, _ := persistKernel(t, PersistPolicy{Mode: PersistInterval, Interval: time.Second, TrackDirty: true}, t0)
+	markDirtyAt(k, "a", t0)
+	if len(k.duePersist(t0.Add(time.Second))) != 1 {
+		t.Fatal("dirty state not due")
+	}
+	// 保存失败后重新标记为脏，下一个间隔重试
+	k.persistFailed("a")
+	if len(k.duePersist(t0.Add(1500*time.Millisecond))) != 0 {
+		t.Fatal("retried before the interval elapsed")
+	}
+	if len(k.duePersist(t0.Add(2*time.Second))) != 1 {
+		t.Fatal("failed save not retried")
+	}
+
+	// 变化时保存的策略在防抖时间后重试
+	now := time.Now()
+	k.SetPersistPolicy("a", PersistPolicy{Mode: PersistOnChange, Debounce: time.Second})
+	k.persistFailed("a")
+	if len(k.duePersist(now)) != 0 {
+		t.Fatal("retried before the debounce elapsed")
+	}
+	if len(k.duePersist(time.Now().Add(time.Second))) != 1 {
+		t.Fatal("failed save not retried after the debounce")
+	}
+}
+
+func TestPersistOnStop(t *testing.T) {
+	for _, mode := range []PersistMode{PersistInterval, PersistOnChange, PersistOnStop, PersistNever} {
+		t.Run(mode.String(), func(t *testing.T) {
+			k, store := persistKernel(t, PersistPolicy{Mode: mode, Interval: time.Hour}, time.Now())
+			if err := k.StopService("a"); err != nil {
+				t.Fatal(err)
+			}
+			if saved, want := store.Exists("a"), mode != PersistNever; saved != want {
+				t.Fatalf("saved on stop = %v, want %v", saved, want)
+			}
+		})
+	}
+}
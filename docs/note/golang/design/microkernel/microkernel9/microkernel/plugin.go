@@ -0,0 +1,588 @@
+package microkernel
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"plugin"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PluginFactorySymbol Go plugin（.so）中必须导出的工厂函数名
+// 函数签名为 PluginFactory：func(*microkernel.MicroKernel) microkernel.Service
+const PluginFactorySymbol = "NewService"
+
+// PluginFactory 插件工厂函数
+type PluginFactory = func(*MicroKernel) Service
+
+// PluginManifest 子进程服务的描述文件（<name>.json）
+//
+//	{
+//	  "name": "echo",
+//	  "exec": "./echo-bin",
+//	  "args": ["-v"],
+//	  "dependencies": ["logger"]
+//	}
+type PluginManifest struct {
+	Name         string   `json:"name"`
+	Exec         string   `json:"exec"` // 相对路径以插件目录为基准
+	Args         []string `json:"args"`
+	Dependencies []string `json:"dependencies"`
+	// 子进程中服务的状态 schema 版本，默认为 1
+	StateVersion int `json:"state_version"`
+}
+
+// PluginLoader 扫描插件目录，加载服务模块并注册到内核
+// 支持两种模块：
+//   - *.so：Go plugin，通过 PluginFactorySymbol 实例化
+//   - *.json：子进程服务描述文件，服务以子进程方式运行
+type PluginLoader struct {
+	kernel *MicroKernel
+	dir    string
+	// 热替换时用于状态迁移
+	crypter Crypter
+
+	mu sync.Mutex
+	// 模块文件路径 -> 已加载模块信息
+	loaded map[string]*loadedPlugin
+	// 加载、替换或注销失败的模块：路径 -> 失败时的指纹，文件变化前不再重试
+	failed map[string]string
+}
+
+// pluginRemoved 模块文件已删除但服务无法注销时记录的指纹
+const pluginRemoved = "removed"
+
+type loadedPlugin struct {
+	name        string
+	fingerprint string
+}
+
+// NewPluginLoader 创建插件加载器
+func NewPluginLoader(kernel *MicroKernel, dir string, crypter Crypter) *PluginLoader {
+	return &PluginLoader{
+		kernel:  kernel,
+		dir:     dir,
+		crypter: crypter,
+		loaded:  make(map[string]*loadedPlugin),
+		failed:  make(map[string]string),
+	}
+}
+
+// LoadAll 扫描目录并注册所有服务（不启动，由 StartAll 统一启动）
+func (l *PluginLoader) LoadAll() error {
+	paths, err := l.scan()
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, path := range paths {
+		if _, ok := l.loaded[path]; ok {
+			continue
+		}
+		if err := l.load(path, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Watch 轮询插件目录：
+//   - 新增的模块注册并启动
+//   - 发生变化的模块热替换（包括状态迁移）
+//   - 删除的模块注销其服务
+//
+// 失败的模块记录修改时间和大小，文件再次变化前不重试，错误只报告一次。
+//
+// 阻塞直到 ctx 取消
+func (l *PluginLoader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := l.reload(); err != nil {
+				l.kernel.log.Error("plugin reload failed", "err", err)
+			}
+		}
+	}
+}
+
+func (l *PluginLoader) reload() error {
+	paths, err := l.scan()
+	// 插件目录还不存在时等待创建
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var errs []error
+	present := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		present[path] = true
+		fp := l.stamp(path)
+		if l.failed[path] == fp {
+			continue
+		}
+		delete(l.failed, path)
+		var err error
+		info, ok := l.loaded[path]
+		switch {
+		case !ok:
+			err = l.load(path, true)
+		case fp != info.fingerprint:
+			err = l.replace(path, fp)
+		}
+		if err != nil {
+			l.failed[path] = fp
+			errs = append(errs, err)
+		}
+	}
+	for path := range l.failed {
+		if _, ok := l.loaded[path]; !ok && !present[path] {
+			delete(l.failed, path)
+		}
+	}
+	for path, info := range l.loaded {
+		if present[path] {
+			continue
+		}
+		// 仍有服务依赖它时注销失败，每次轮询重试，错误只报告一次
+		if err := l.kernel.Unregister(info.name); err != nil {
+			if l.failed[path] != pluginRemoved {
+				l.failed[path] = pluginRemoved
+				errs = append(errs, fmt.Errorf("plugin %s removed: %w", path, err))
+			}
+			continue
+		}
+		delete(l.loaded, path)
+		delete(l.failed, path)
+		l.kernel.log.Info("plugin unloaded", "service", info.name, "file", filepath.Base(path))
+	}
+	return errors.Join(errs...)
+}
+
+// stamp 用于比较模块是否变化的指纹，描述文件无法解析时使用描述文件本身的修改时间和大小
+func (l *PluginLoader) stamp(path string) string {
+	if fp, err := l.fingerprint(path); err == nil {
+		return fp
+	}
+	return statStamp(path)
+}
+
+// load 实例化并注册一个模块，start 为 true 时立即启动
+func (l *PluginLoader) load(path string, start bool) error {
+	fp, err := l.fingerprint(path)
+	if err != nil {
+		return err
+	}
+	svc, err := l.instantiate(path)
+	if err != nil {
+		return err
+	}
+	if err := l.kernel.Register(svc); err != nil {
+		return fmt.Errorf("plugin %s: %w", path, err)
+	}
+	if start {
+		if err := l.kernel.StartService(svc.Name()); err != nil {
+			// 注销后文件变化时可以重新加载
+			if uerr := l.kernel.Unregister(svc.Name()); uerr != nil {
+				err = errors.Join(err, uerr)
+			}
+			return fmt.Errorf("plugin %s: %w", path, err)
+		}
+	}
+	l.loaded[path] = &loadedPlugin{name: svc.Name(), fingerprint: fp}
+	l.kernel.log.Info("plugin loaded", "service", svc.Name(), "file", filepath.Base(path))
+	return nil
+}
+
+// replace 重新实例化模块并热替换内核中的旧版本
+func (l *PluginLoader) replace(path, fp string) error {
+	svc, err := l.instantiate(path)
+	if err != nil {
+		return err
+	}
+	if name := l.loaded[path].name; svc.Name() != name {
+		return fmt.Errorf("plugin %s: service name changed from %s to %s", path, name, svc.Name())
+	}
+	if err := l.kernel.ReplaceServiceEncrypted(svc, l.crypter); err != nil {
+		return fmt.Errorf("plugin %s: %w", path, err)
+	}
+	l.loaded[path].fingerprint = fp
+	l.kernel.log.Info("plugin replaced", "service", svc.Name(), "file", filepath.Base(path))
+	return nil
+}
+
+func (l *PluginLoader) scan() ([]string, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch filepath.Ext(e.Name()) {
+		case ".so", ".json":
+			paths = append(paths, filepath.Join(l.dir, e.Name()))
+		}
+	}
+	return paths, nil
+}
+
+// fingerprint 模块文件的修改时间和大小，子进程服务还包括其可执行文件
+// 文件不存在时记为 missing，文件出现后指纹变化
+func (l *PluginLoader) fingerprint(path string) (string, error) {
+	files := []string{path}
+	if filepath.Ext(path) == ".json" {
+		m, err := l.readManifest(path)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, m.Exec)
+	}
+	var sb strings.Builder
+	for _, f := range files {
+		sb.WriteString(statStamp(f))
+	}
+	return sb.String(), nil
+}
+
+func statStamp(path string) string {
+	st, err := os.Stat(path)
+	if err != nil {
+		return path + ":missing;"
+	}
+	return fmt.Sprintf("%s:%d:%d;", path, st.ModTime().UnixNano(), st.Size())
+}
+
+func (l *PluginLoader) instantiate(path string) (Service, error) {
+	if filepath.Ext(path) == ".json" {
+		m, err := l.readManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		return newProcessService(m), nil
+	}
+	return l.openPlugin(path)
+}
+
+// openPlugin 打开 Go plugin
+// plugin.Open 会按路径缓存已加载的模块，并且模块无法卸载，
+// 因此每次加载前先复制为唯一文件名，保证文件更新后能加载到新代码
+func (l *PluginLoader) openPlugin(path string) (Service, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp("", "mk-plugin-*.so")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	p, err := plugin.Open(tmp.Name())
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", path, err)
+	}
+	sym, err := p.Lookup(PluginFactorySymbol)
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s: %w", path, err)
+	}
+	// 导出的函数可能是函数本身，也可能是函数变量的指针
+	switch factory := sym.(type) {
+	case PluginFactory:
+		return factory(l.kernel), nil
+	case *PluginFactory:
+		return (*factory)(l.kernel), nil
+	default:
+		return nil, fmt.Errorf("plugin %s: symbol %s has type %T, want %T",
+			path, PluginFactorySymbol, sym, PluginFactory(nil))
+	}
+}
+
+func (l *PluginLoader) readManifest(path string) (PluginManifest, error) {
+	var m PluginManifest
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("manifest %s: %w", path, err)
+	}
+	if m.Name == "" || m.Exec == "" {
+		return m, fmt.Errorf("manifest %s: name and exec are required", path)
+	}
+	if !filepath.IsAbs(m.Exec) {
+		m.Exec = filepath.Join(l.dir, m.Exec)
+	}
+	return m, nil
+}
+
+// 子进程服务协议：stdin/stdout 上逐行传输 JSON
+// 子进程的 stderr 直接输出到内核进程的 stderr
+// 状态以编码后的字节传输，保留服务选择的编解码器
+type processRequest struct {
+	Op       string `json:"op"` // start, stop, handle, export, import
+	Event    *Event `json:"event,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	State    []byte `json:"state,omitempty"`
+}
+
+type processResponse struct {
+	Reply    *Reply `json:"reply,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	State    []byte `json:"state,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// processService 以子进程方式运行的服务
+type processService struct {
+	manifest PluginManifest
+
+	// 保护与子进程的请求/应答，保证同一时刻只有一个请求
+	mu    sync.Mutex
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+	enc   *json.Encoder
+	dec   *json.Decoder
+	// Start 前导入的状态，子进程启动后再下发
+	pending *processRequest
+}
+
+func newProcessService(m PluginManifest) *processService {
+	return &processService{manifest: m}
+}
+
+func (p *processService) Name() string {
+	return p.manifest.Name
+}
+
+func (p *processService) Dependencies() []string {
+	return p.manifest.Dependencies
+}
+
+func (p *processService) StateVersion() int {
+	if p.manifest.StateVersion == 0 {
+		return 1
+	}
+	return p.manifest.StateVersion
+}
+
+func (p *processService) Start() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd != nil {
+		return errors.New("process already started")
+	}
+	cmd := exec.Command(p.manifest.Exec, p.manifest.Args...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	p.cmd = cmd
+	p.stdin = stdin
+	p.enc = json.NewEncoder(stdin)
+	p.dec = json.NewDecoder(bufio.NewReader(stdout))
+
+	if p.pending != nil {
+		if _, err := p.call(*p.pending); err != nil {
+			p.kill()
+			return fmt.Errorf("state import failed: %w", err)
+		}
+		p.pending = nil
+	}
+	if _, err := p.call(processRequest{Op: "start"}); err != nil {
+		p.kill()
+		return err
+	}
+	return nil
+}
+
+func (p *processService) Stop() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		return nil
+	}
+	_, err := p.call(processRequest{Op: "stop"})
+	p.stdin.Close()
+	if werr := p.cmd.Wait(); err == nil {
+		err = werr
+	}
+	p.cmd = nil
+	return err
+}
+
+func (p *processService) Handle(evt Event) Reply {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		return Reply{Code: 503, Message: "process not running"}
+	}
+	resp, err := p.call(processRequest{Op: "handle", Event: &evt})
+	if err != nil {
+		return Reply{Code: 500, Message: err.Error()}
+	}
+	if resp.Reply == nil {
+		return Reply{Code: 500, Message: "empty reply from process"}
+	}
+	return *resp.Reply
+}
+
+// TypedState 子进程服务的状态通过协议导出/导入，由子进程中的服务决定编码
+func (p *processService) TypedState() StateAdapter {
+	return processState{p}
+}
+
+type processState struct {
+	p *processService
+}
+
+func (s processState) exportState() (string, []byte, error) {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd == nil {
+		if p.pending == nil {
+			return "", nil, errors.New("process not running")
+		}
+		return p.pending.Encoding, p.pending.State, nil
+	}
+	resp, err := p.call(processRequest{Op: "export"})
+	if err != nil {
+		return "", nil, err
+	}
+	return resp.Encoding, resp.State, nil
+}
+
+func (s processState) importState(encoding string, data []byte) error {
+	p := s.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	req := processRequest{Op: "import", Encoding: encoding, State: data}
+	if p.cmd == nil {
+		p.pending = &req
+		return nil
+	}
+	_, err := p.call(req)
+	return err
+}
+
+// call 发送请求并等待应答，调用方需持有 p.mu
+func (p *processService) call(req processRequest) (processResponse, error) {
+	var resp processResponse
+	if err := p.enc.Encode(req); err != nil {
+		return resp, err
+	}
+	if err := p.dec.Decode(&resp); err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, errors.New(resp.Error)
+	}
+	return resp, nil
+}
+
+func (p *processService) kill() {
+	p.stdin.Close()
+	_ = p.cmd.Process.Kill()
+	_ = p.cmd.Wait()
+	p.cmd = nil
+}
+
+// ServeProcess 子进程一侧的协议实现
+// 子进程服务的 main 中调用，把 svc 通过 stdin/stdout 暴露给内核：
+//
+//	func main() {
+//		if err := microkernel.ServeProcess(service.NewEchoServiceV2(nil)); err != nil {
+//			os.Exit(1)
+//		}
+//	}
+//
+// 子进程中没有内核，服务需要能在 kernel 为 nil 时运行
+// stdout 被协议占用，服务中直接打印到 os.Stdout 的内容会被重定向到 stderr
+func ServeProcess(svc Service) error {
+	out := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = out }()
+
+	dec := json.NewDecoder(bufio.NewReader(os.Stdin))
+	enc := json.NewEncoder(out)
+	for {
+		var req processRequest
+		if err := dec.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		var resp processResponse
+		var err error
+		switch req.Op {
+		case "start":
+			err = svc.Start()
+		case "stop":
+			err = svc.Stop()
+		case "handle":
+			if req.Event == nil {
+				err = errors.New("missing event")
+				break
+			}
+			reply := svc.Handle(*req.Event)
+			resp.Reply = &reply
+		case "export":
+			if canExport(svc) {
+				resp.Encoding, resp.State, err = stateAdapterFor(svc).exportState()
+			} else {
+				err = errors.New("state not exportable")
+			}
+		case "import":
+			if canImport(svc) {
+				err = stateAdapterFor(svc).importState(req.Encoding, req.State)
+			}
+		default:
+			err = fmt.Errorf("unknown op %q", req.Op)
+		}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+		if req.Op == "stop" {
+			return nil
+		}
+	}
+}
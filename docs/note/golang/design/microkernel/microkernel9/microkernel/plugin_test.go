@@ -0,0 +1,71 @@
+package microkernel
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestPluginHelperProcess 作为子进程服务运行，由 writeManifest 写出的描述文件启动
+func TestPluginHelperProcess(t *testing.T) {
+	if os.Getenv("MK_PLUGIN_HELPER") == "" {
+		t.Skip("helper process")
+	}
+	if err := ServeProcess(&counter{name: "p", version: 1}); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func writeManifest(t *testing.T, path string, m PluginManifest) {
+	t.Helper()
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPluginWatchFailuresAndRemoval(t *testing.T) {
+	t.Setenv("MK_PLUGIN_HELPER", "1")
+	dir := t.TempDir()
+	k := NewMicroKernel(NewMemoryStateStore())
+	l := NewPluginLoader(k, dir, nil)
+	defer k.StopAll()
+
+	// 可执行文件不存在：只报告一次，文件变化前不重试
+	broken := filepath.Join(dir, "broken.json")
+	writeManifest(t, broken, PluginManifest{Name: "broken", Exec: "./missing"})
+	if err := l.reload(); err == nil {
+		t.Fatal("loading a broken plugin succeeded")
+	}
+	if err := l.reload(); err != nil {
+		t.Fatalf("broken plugin retried without changes: %v", err)
+	}
+	if _, ok := k.Service("broken"); ok {
+		t.Fatal("broken plugin left registered")
+	}
+
+	good := filepath.Join(dir, "p.json")
+	writeManifest(t, good, PluginManifest{Name: "p", Exec: os.Args[0], Args: []string{"-test.run=^TestPluginHelperProcess$"}})
+	if err := l.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if k.serviceState("p") != Running {
+		t.Fatal("plugin p is not running")
+	}
+
+	// 删除模块文件后注销服务
+	if err := os.Remove(good); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := k.Service("p"); ok {
+		t.Fatal("plugin p still registered after its file was removed")
+	}
+}
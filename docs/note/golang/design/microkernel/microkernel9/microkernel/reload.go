@@ -0,0 +1,481 @@
+package microkernel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// 内核事件类型
+const (
+	// EventConfigReloaded 配置重载成功，Content 为 ReloadReport 的文本
+	EventConfigReloaded = "config.reloaded"
+	// EventConfigReloadFailed 配置重载失败，原配置继续生效
+	EventConfigReloadFailed = "config.reload_failed"
+)
+
+// ErrReconfigureUnsupported Reconfigure 无法在运行中应用参数，重载时改为热替换服务
+var ErrReconfigureUnsupported = errors.New("reconfigure unsupported")
+
+// Reconfigurable 服务可选实现：运行中应用新的参数
+// 返回错误时服务应保持原来的参数；返回 ErrReconfigureUnsupported 时重载改为热替换
+type Reconfigurable interface {
+	Reconfigure(p Params) error
+}
+
+// ReloadReport 一次重载的变更
+type ReloadReport struct {
+	Added        []string
+	Removed      []string
+	Replaced     []string
+	Reconfigured []string
+	// 只有依赖、重启或持久化策略变化
+	Updated []string
+	// 内核默认持久化策略是否变化
+	Kernel bool
+}
+
+func (r ReloadReport) String() string {
+	var parts []string
+	for _, p := range []struct {
+		label string
+		names []string
+	}{
+		{"added", r.Added}, {"removed", r.Removed}, {"replaced", r.Replaced},
+		{"reconfigured", r.Reconfigured}, {"updated", r.Updated},
+	} {
+		if len(p.names) > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%s", p.label, strings.Join(p.names, ",")))
+		}
+	}
+	if r.Kernel {
+		parts = append(parts, "kernel.persist")
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, " ")
+}
+
+// Config 当前生效的配置
+func (rt *Runtime) Config() *Config {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.config
+}
+
+// Reload 重新读取当前配置文件并应用
+func (rt *Runtime) Reload() (ReloadReport, error) {
+	cfg, err := LoadConfigWith(rt.Config().File, rt.registry)
+	if err != nil {
+		rt.reloadFailed(err)
+		return ReloadReport{}, err
+	}
+	return rt.ReloadConfig(cfg)
+}
+
+// ReloadConfig 与当前配置比较后按依赖顺序应用变更：
+// 注册并启动新增的服务，停止并注销删除的服务，类型或参数变化的服务热替换（迁移状态），
+// 实现 Reconfigurable 的服务直接应用新参数。
+// 任何一步失败时撤销已应用的变更，原配置继续生效。
+func (rt *Runtime) ReloadConfig(cfg *Config) (ReloadReport, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.reloadLocked(cfg)
+}
+
+// UpdateService 修改一个服务声明的类型和参数并按 ReloadConfig 的规则应用，
+// typ 为空时保留原类型，params 为 nil 时保留原参数。
+// 修改只在内存中生效，之后重载配置文件时以文件为准。
+func (rt *Runtime) UpdateService(name, typ string, params map[string]any) (ReloadReport, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	cfg := *rt.config
+	cfg.Services = slices.Clone(rt.config.Services)
+	i := slices.IndexFunc(cfg.Services, func(sc ServiceConfig) bool { return sc.Name == name })
+	if i < 0 {
+		return ReloadReport{}, fmt.Errorf("service %s is not declared in the config", name)
+	}
+	if typ != "" {
+		cfg.Services[i].Type = typ
+	}
+	if params != nil {
+		cfg.Services[i].Params = params
+	}
+	return rt.reloadLocked(&cfg)
+}
+
+// ServiceType 按服务声明的类型从注册表查找服务类型，自定义 BuildFunc 或服务不在配置中时返回 false
+func (rt *Runtime) ServiceType(name string) (ServiceType, bool) {
+	if rt.registry == nil {
+		return ServiceType{}, false
+	}
+	for _, sc := range rt.Config().Services {
+		if sc.Name == name {
+			return rt.registry.Lookup(sc.Type)
+		}
+	}
+	return ServiceType{}, false
+}
+
+// reloadLocked 调用方持有 rt.mu
+func (rt *Runtime) reloadLocked(cfg *Config) (ReloadReport, error) {
+	report, err := rt.reconcile(cfg)
+	if err != nil {
+		rt.reloadFailed(err)
+		return ReloadReport{}, err
+	}
+	rt.config = cfg
+	rt.Kernel.log.Info("config reloaded", "changes", report.String())
+	rt.Kernel.emit(EventConfigReloaded, report.String())
+	return report, nil
+}
+
+func (rt *Runtime) reloadFailed(err error) {
+	rt.Kernel.log.Error("config reload failed, keeping previous config", "err", err)
+	rt.Kernel.emit(EventConfigReloadFailed, err.Error())
+}
+
+// ReloadOnSignal 收到信号（通常是 SIGHUP）时重载配置文件，直到 ctx 取消
+func (rt *Runtime) ReloadOnSignal(ctx context.Context, sigs ...os.Signal) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ch:
+			// 结果已打印并作为内核事件广播
+			rt.Reload()
+		}
+	}
+}
+
+// 服务的变更类型
+const (
+	changeNone = iota
+	changeAdd
+	changeReplace
+	changeReconfigure
+	changeUpdate
+)
+
+type serviceChange struct {
+	kind int
+	sc   ServiceConfig
+	prev ServiceConfig
+	// changeAdd、changeReplace 预先创建的服务
+	svc Service
+	// changeReconfigure 校验后的新参数
+	params Params
+}
+
+// reconcile 计算并应用变更，调用方持有 rt.mu
+func (rt *Runtime) reconcile(cfg *Config) (ReloadReport, error) {
+	old, k := rt.config, rt.Kernel
+	var report ReloadReport
+	if err := checkStatic(old, cfg); err != nil {
+		return report, err
+	}
+
+	// 1. 比较服务声明，预先创建新增和替换的服务，参数错误在改动内核之前发现
+	prevByName := make(map[string]ServiceConfig, len(old.Services))
+	for _, sc := range old.Services {
+		prevByName[sc.Name] = sc
+	}
+	changes := make(map[string]*serviceChange, len(cfg.Services))
+	var errs []error
+	for _, sc := range cfg.Services {
+		c, err := rt.diffService(sc, prevByName)
+		if err != nil {
+			errs = append(errs, cfg.serviceError(sc, err))
+			continue
+		}
+		changes[sc.Name] = c
+	}
+	if err := errors.Join(errs...); err != nil {
+		return report, err
+	}
+	var removed []ServiceConfig
+	for _, prev := range old.Services {
+		if _, ok := changes[prev.Name]; !ok {
+			removed = append(removed, prev)
+		}
+	}
+
+	// 2. 检查变更后的依赖图：依赖都存在且没有循环
+	current := k.dependencyGraph()
+	graph := make(map[string][]string, len(current)+len(changes))
+	for name, deps := range current {
+		graph[name] = deps
+	}
+	for _, prev := range removed {
+		delete(graph, prev.Name)
+	}
+	for name, c := range changes {
+		svc := c.svc
+		if svc == nil {
+			svc, _ = k.Service(name)
+		}
+		graph[name] = mergeDeps(svc.Dependencies(), c.sc.Deps)
+	}
+	order, err := sortGraph(graph)
+	if err != nil {
+		return report, fmt.Errorf("%s: %w", cfg.File, err)
+	}
+	oldOrder, err := sortGraph(current)
+	if err != nil {
+		return report, err
+	}
+
+	// 3. 按依赖顺序应用，记录撤销操作
+	var undo []func() error
+	rollback := func(cause error) (ReloadReport, error) {
+		for i := len(undo) - 1; i >= 0; i-- {
+			if err := undo[i](); err != nil {
+				k.log.Error("reload rollback failed", "err", err)
+			}
+		}
+		return ReloadReport{}, cause
+	}
+
+	if !reflect.DeepEqual(old.Kernel.Persist, cfg.Kernel.Persist) {
+		rt.setDefaultPersist(cfg.Kernel.Persist)
+		undo = append(undo, func() error {
+			rt.setDefaultPersist(old.Kernel.Persist)
+			return nil
+		})
+		report.Kernel = true
+	}
+
+	// 删除的服务按原依赖顺序逆序停止，依赖它的服务先停止
+	removedSet := make(map[string]ServiceConfig, len(removed))
+	for _, prev := range removed {
+		removedSet[prev.Name] = prev
+	}
+	for i := len(oldOrder) - 1; i >= 0; i-- {
+		prev, ok := removedSet[oldOrder[i]]
+		if !ok {
+			continue
+		}
+		running := k.serviceState(prev.Name) == Running
+		if err := k.unregister(prev.Name); err != nil {
+			return rollback(old.serviceError(prev, err))
+		}
+		undo = append(undo, func() error {
+			svc, err := rt.newService(prev)
+			if err != nil {
+				return err
+			}
+			if err := rt.register(svc, prev); err != nil {
+				return err
+			}
+			if running {
+				return k.StartService(prev.Name)
+			}
+			return nil
+		})
+		report.Removed = append(report.Removed, prev.Name)
+	}
+
+	// 其余变更按新的依赖顺序应用，被依赖的服务先就绪
+	for _, name := range order {
+		c, ok := changes[name]
+		if !ok || c.kind == changeNone {
+			continue
+		}
+		if c.kind == changeReconfigure {
+			svc, _ := k.Service(name)
+			err := svc.(Reconfigurable).Reconfigure(c.params)
+			if errors.Is(err, ErrReconfigureUnsupported) {
+				c.kind = changeReplace
+				if c.svc, err = rt.newService(c.sc); err != nil {
+					return rollback(cfg.serviceError(c.sc, err))
+				}
+			} else if err != nil {
+				return rollback(cfg.serviceError(c.sc, err))
+			} else {
+				rt.applyPolicies(svc, c.sc)
+				prevParams, _ := rt.registry.Params(c.prev.Type, c.prev.Params)
+				undo = append(undo, func() error {
+					rt.applyPolicies(svc, c.prev)
+					return svc.(Reconfigurable).Reconfigure(prevParams)
+				})
+				report.Reconfigured = append(report.Reconfigured, name)
+				continue
+			}
+		}
+		switch c.kind {
+		case changeAdd:
+			if err := rt.register(c.svc, c.sc); err != nil {
+				return rollback(cfg.serviceError(c.sc, err))
+			}
+			undo = append(undo, func() error { return k.unregister(name) })
+			if err := k.StartService(name); err != nil {
+				return rollback(cfg.serviceError(c.sc, err))
+			}
+			report.Added = append(report.Added, name)
+		case changeReplace:
+			// 撤销时原样恢复替换前的状态：新版本的状态可能已迁移到更高的版本，旧版本无法导入
+			prevState, err := k.exportService(name)
+			if err != nil {
+				return rollback(cfg.serviceError(c.sc, err))
+			}
+			if err := rt.replace(c.svc, c.sc); err != nil {
+				return rollback(cfg.serviceError(c.sc, err))
+			}
+			undo = append(undo, func() error {
+				svc, err := rt.newService(c.prev)
+				if err != nil {
+					return err
+				}
+				if err := k.restoreService(svc, prevState); err != nil {
+					return err
+				}
+				rt.applyPolicies(svc, c.prev)
+				return nil
+			})
+			report.Replaced = append(report.Replaced, name)
+		case changeUpdate:
+			svc, _ := k.Service(name)
+			rt.applyPolicies(svc, c.sc)
+			undo = append(undo, func() error {
+				rt.applyPolicies(svc, c.prev)
+				return nil
+			})
+			report.Updated = append(report.Updated, name)
+		}
+	}
+	return report, nil
+}
+
+// checkStatic 检查只能在重启后生效的配置
+func checkStatic(old, cfg *Config) error {
+	var errs []error
+	if old.Kernel.QueueSize != cfg.Kernel.QueueSize {
+		errs = append(errs, errors.New("kernel.queue_size cannot change without restart"))
+	}
+	if old.Store != cfg.Store {
+		errs = append(errs, errors.New("store cannot change without restart"))
+	}
+	if !reflect.DeepEqual(old.Key, cfg.Key) {
+		errs = append(errs, errors.New("key cannot change without restart"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %w", cfg.File, errors.Join(errs...))
+	}
+	return nil
+}
+
+// diffService 比较一个服务的新旧声明
+func (rt *Runtime) diffService(sc ServiceConfig, prevByName map[string]ServiceConfig) (*serviceChange, error) {
+	c := &serviceChange{sc: sc}
+	prev, ok := prevByName[sc.Name]
+	current, registered := rt.Kernel.Service(sc.Name)
+	switch {
+	case !ok:
+		if registered {
+			return nil, errors.New("service already registered outside the config")
+		}
+		c.kind = changeAdd
+	case !registered:
+		// 在配置之外（如管理接口）被注销，按声明重新注册
+		c.kind = changeAdd
+	case prev.Type != sc.Type:
+		c.kind = changeReplace
+	case !reflect.DeepEqual(prev.Params, sc.Params):
+		c.kind = changeReplace
+		if _, live := current.(Reconfigurable); live && rt.registry != nil {
+			params, err := rt.registry.Params(sc.Type, sc.Params)
+			if err != nil {
+				return nil, err
+			}
+			c.kind, c.params = changeReconfigure, params
+		}
+	case !slices.Equal(prev.Deps, sc.Deps) || !reflect.DeepEqual(prev.Restart, sc.Restart) ||
+		!reflect.DeepEqual(prev.Persist, sc.Persist):
+		c.kind = changeUpdate
+	}
+	c.prev = prev
+	if c.kind == changeAdd || c.kind == changeReplace {
+		svc, err := rt.newService(sc)
+		if err != nil {
+			return nil, err
+		}
+		c.svc = svc
+	}
+	return c, nil
+}
+
+// replace 热替换服务并迁移状态，然后按声明设置策略
+func (rt *Runtime) replace(svc Service, sc ServiceConfig) error {
+	if err := rt.Kernel.ReplaceServiceEncrypted(svc, rt.Crypter); err != nil {
+		return err
+	}
+	rt.applyPolicies(svc, sc)
+	return nil
+}
+
+// setDefaultPersist 设置默认持久化策略并应用到已注册的服务
+func (rt *Runtime) setDefaultPersist(policy *PersistPolicy) {
+	k := rt.Kernel
+	if policy == nil {
+		policy = &DefaultPersistPolicy
+	}
+	k.SetDefaultPersistPolicy(*policy)
+	for name := range k.dependencyGraph() {
+		if svc, ok := k.Service(name); ok {
+			k.refreshPersistPolicy(svc)
+		}
+	}
+}
+
+// dependencyGraph 已注册服务及其依赖的副本
+func (k *MicroKernel) dependencyGraph() map[string][]string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	graph := make(map[string][]string, len(k.services))
+	for name, meta := range k.services {
+		graph[name] = slices.Clone(meta.deps)
+	}
+	return graph
+}
+
+// serviceState 服务当前状态，未注册时为 Stopped
+func (k *MicroKernel) serviceState(name string) ServiceState {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if meta, ok := k.services[name]; ok {
+		return meta.state
+	}
+	return Stopped
+}
+
+// unregister 注销服务，不检查依赖它的服务（重载已检查变更后的依赖图）
+func (k *MicroKernel) unregister(name string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.unregisterLocked(name)
+}
+
+// mergeDeps 服务自己声明的依赖加上配置中追加的依赖
+func mergeDeps(own, extra []string) []string {
+	deps := slices.Clone(own)
+	for _, dep := range extra {
+		if !slices.Contains(deps, dep) {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// sortGraph 按依赖排序，被依赖的服务在前；依赖不存在或有循环时返回 *GraphError
+func sortGraph(graph map[string][]string) ([]string, error) {
+	return NewGraph(graph).StartOrder()
+}
@@ -0,0 +1,148 @@
+package microkernel
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// counter 测试用的有状态服务，version 为状态 schema 版本
+type counter struct {
+	name    string
+	version int
+	deps    []string
+	n       int
+	// 非空时 Start 返回该错误
+	startErr error
+}
+
+func (c *counter) Start() error           { return c.startErr }
+func (c *counter) Stop() error            { return nil }
+func (c *counter) Name() string           { return c.name }
+func (c *counter) Handle(Event) Reply     { c.n++; return Reply{} }
+func (c *counter) Dependencies() []string { return c.deps }
+func (c *counter) StateVersion() int      { return c.version }
+func (c *counter) ExportState() any       { return c.n }
+func (c *counter) Migrations() map[int]MigrateFunc {
+	steps := make(map[int]MigrateFunc)
+	for v := 1; v < c.version; v++ {
+		steps[v] = func(data []byte) ([]byte, error) { return data, nil }
+	}
+	return steps
+}
+
+func (c *counter) ImportState(state any) error {
+	n, ok := state.(float64)
+	if !ok {
+		return fmt.Errorf("unexpected state %T", state)
+	}
+	c.n = int(n)
+	return nil
+}
+
+func init() {
+	RegisterServiceType(ServiceType{
+		Name: "counter",
+		Params: []ParamSpec{
+			{Name: "name", Kind: ParamString, Required: true},
+			{Name: "version", Kind: ParamInt, Default: 1},
+			{Name: "fail", Kind: ParamBool},
+		},
+		New: func(k *MicroKernel, p Params) (Service, error) {
+			c := &counter{name: p.String("name"), version: p.Int("version")}
+			if p.Bool("fail") {
+				c.startErr = errors.New("start failed")
+			}
+			return c, nil
+		},
+	})
+}
+
+// testRuntime 使用内存存储，按 services 中的服务声明创建并启动内核
+func testRuntime(t *testing.T, services string) *Runtime {
+	t.Helper()
+	cfg, err := ParseConfig("kernel.toml", []byte("[store]\nbackend = \"memory\"\n"+services))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt, err := cfg.Apply(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { rt.Close() })
+	if err := rt.Kernel.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+	return rt
+}
+
+func reloadConfig(t *testing.T, rt *Runtime, services string) (ReloadReport, error) {
+	t.Helper()
+	cfg, err := ParseConfig("kernel.toml", []byte("[store]\nbackend = \"memory\"\n"+services))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt.ReloadConfig(cfg)
+}
+
+func TestReloadReregistersUnregisteredService(t *testing.T) {
+	services := `
+[[services]]
+name = "a"
+type = "counter"
+params = { name = "a" }
+`
+	rt := testRuntime(t, services)
+	if err := rt.Kernel.Unregister("a"); err != nil {
+		t.Fatal(err)
+	}
+	report, err := reloadConfig(t, rt, services)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Added) != 1 || report.Added[0] != "a" {
+		t.Fatalf("report = %s, want a added", report)
+	}
+	if rt.Kernel.serviceState("a") != Running {
+		t.Fatal("a is not running")
+	}
+}
+
+func TestReloadRollbackRestoresReplacedState(t *testing.T) {
+	rt := testRuntime(t, `
+[[services]]
+name = "a"
+type = "counter"
+params = { name = "a" }
+`)
+	svc, _ := rt.Kernel.Service("a")
+	svc.(*counter).n = 5
+
+	// a 升级到版本 2 后，依赖它的 z 启动失败，整个重载撤销
+	_, err := reloadConfig(t, rt, `
+[[services]]
+name = "a"
+type = "counter"
+params = { name = "a", version = 2 }
+
+[[services]]
+name = "z"
+type = "counter"
+params = { name = "z", fail = true }
+deps = ["a"]
+`)
+	if err == nil {
+		t.Fatal("reload succeeded")
+	}
+	svc, _ = rt.Kernel.Service("a")
+	a := svc.(*counter)
+	if a.version != 1 || a.n != 5 {
+		t.Fatalf("a = version %d state %d, want version 1 state 5", a.version, a.n)
+	}
+	if rt.Kernel.serviceState("a") != Running {
+		t.Fatal("a is not running")
+	}
+	if _, ok := rt.Kernel.Service("z"); ok {
+		t.Fatal("z is still registered")
+	}
+}
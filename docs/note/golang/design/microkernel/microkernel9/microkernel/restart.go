@@ -0,0 +1,119 @@
+package microkernel
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+// RestartMode 服务处理事件时 panic 后的处理方式
+type RestartMode int
+
+const (
+	// RestartNever 只恢复 panic 并返回错误回复，服务继续运行
+	RestartNever RestartMode = iota
+	// RestartOnFailure panic 后停止并重新启动服务
+	RestartOnFailure
+)
+
+func (m RestartMode) String() string {
+	return [...]string{"never", "on-failure"}[m]
+}
+
+// RestartPolicy 服务的重启策略
+type RestartPolicy struct {
+	Mode RestartMode
+	// 最多重启次数，超过后服务停止；0 表示不限
+	MaxRestarts int
+	// 重启前等待的时间
+	Backoff time.Duration
+}
+
+// 内核事件类型
+const (
+	// EventServicePanic 服务处理事件时 panic
+	EventServicePanic = "service.panic"
+	// EventServiceRestarted 服务按重启策略重新启动
+	EventServiceRestarted = "service.restarted"
+	// EventServiceFailed 服务重启失败或超过最多重启次数，已停止
+	EventServiceFailed = "service.failed"
+)
+
+// SetRestartPolicy 设置服务的重启策略
+func (k *MicroKernel) SetRestartPolicy(name string, policy RestartPolicy) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	meta, ok := k.services[name]
+	if !ok {
+		return fmt.Errorf("service %s not registered", name)
+	}
+	meta.restart = policy
+	return nil
+}
+
+// handle 调用服务处理事件，服务 panic 时恢复并按重启策略处理
+func (k *MicroKernel) handle(meta *serviceMeta, evt Event) (reply Reply) {
+	done := k.metrics.handleStarted(meta.svc.Name())
+	// 先注册，在恢复 panic 之后执行，记录最终的回复码
+	defer func() { done(reply.Code) }()
+	defer func() {
+		if r := recover(); r != nil {
+			name := meta.svc.Name()
+			k.metrics.panics.With(name).Inc()
+			k.deadLetter(evt, DeadPanic, 500, fmt.Sprint(r))
+			k.log.Error("service panicked", "service", name, "type", evt.Type, "from", evt.From,
+				"code", 500, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+			k.emit(EventServicePanic, fmt.Sprintf("service=%s panic=%q", name, fmt.Sprint(r)))
+			reply = Reply{Code: 500, Message: fmt.Sprintf("service %s panicked", name), Data: ""}
+			go k.restartAfterPanic(meta)
+		}
+	}()
+	return meta.svc.Handle(evt)
+}
+
+// restartAfterPanic 按重启策略重新启动服务
+// 重启不保存状态：panic 后服务的状态可能已经不一致
+func (k *MicroKernel) restartAfterPanic(meta *serviceMeta) {
+	k.mu.Lock()
+	policy := meta.restart
+	if policy.Mode != RestartOnFailure || meta.state != Running || meta.restarting {
+		k.mu.Unlock()
+		return
+	}
+	name := meta.svc.Name()
+	if policy.MaxRestarts > 0 && meta.restarts >= policy.MaxRestarts {
+		meta.svc.Stop()
+		meta.state = Stopped
+		restarts := meta.restarts
+		k.mu.Unlock()
+		msg := fmt.Sprintf("service=%s restarts=%d reason=%q", name, restarts, "too many restarts")
+		k.log.Error("service failed", "service", name, "restarts", restarts, "reason", "too many restarts")
+		k.emit(EventServiceFailed, msg)
+		return
+	}
+	meta.restarting = true
+	meta.restarts++
+	k.metrics.restarts.With(name).Inc()
+	k.mu.Unlock()
+
+	time.Sleep(policy.Backoff)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	meta.restarting = false
+	// 等待期间服务可能已被停止或热替换
+	if k.services[name] != meta || meta.state != Running {
+		return
+	}
+	meta.svc.Stop()
+	if err := meta.svc.Start(); err != nil {
+		meta.state = Stopped
+		msg := fmt.Sprintf("service=%s restarts=%d reason=%q", name, meta.restarts, err.Error())
+		k.log.Error("service failed", "service", name, "restarts", meta.restarts, "reason", err)
+		k.emit(EventServiceFailed, msg)
+		return
+	}
+	msg := fmt.Sprintf("service=%s restarts=%d", name, meta.restarts)
+	k.log.Info("service restarted", "service", name, "restarts", meta.restarts)
+	k.emit(EventServiceRestarted, msg)
+}
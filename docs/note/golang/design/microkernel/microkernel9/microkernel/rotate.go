@@ -0,0 +1,182 @@
+package microkernel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// RotationProgress 密钥轮换进度，每处理完一个状态文件报告一次
+type RotationProgress struct {
+	Done       int
+	Total      int
+	Service    string
+	Generation int
+	// 已使用当前密钥加密，无需处理
+	Skipped bool
+	Err     error
+}
+
+// Rotation 后台密钥轮换任务
+type Rotation struct {
+	progress chan RotationProgress
+	done     chan struct{}
+	err      error
+}
+
+// Progress 返回进度通道，轮换结束后关闭
+func (r *Rotation) Progress() <-chan RotationProgress {
+	return r.progress
+}
+
+// Wait 等待轮换结束，返回所有失败文件的错误
+func (r *Rotation) Wait() error {
+	<-r.done
+	return r.err
+}
+
+// Rotate 在后台使用当前密钥重新加密状态目录中的所有状态文件（包括旧的代）
+//
+// 更换密钥的步骤：
+//  1. keyring.Add 新密钥并 SetActive
+//  2. store.Rotate 重新加密所有状态文件
+//  3. 轮换成功后 keyring.Remove 旧密钥
+//
+// 旧格式的密文同时升级为绑定附加认证数据的格式，全部文件处理成功后开启严格模式。
+// 加密器需要实现 Sealer；实现了 Active() 的加密器（如 Keyring）会跳过已使用当前密钥和当前格式的文件
+func (s *FileStateStore) Rotate(ctx context.Context) (*Rotation, error) {
+	sealer, ok := s.crypter.(Sealer)
+	if !ok {
+		return nil, fmt.Errorf("crypter %T does not support key rotation", s.crypter)
+	}
+	names, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+	type file struct {
+		name string
+		gen  int
+	}
+	var files []file
+	for _, name := range names {
+		for _, gen := range s.Generations(name) {
+			files = append(files, file{name, gen})
+		}
+	}
+
+	r := &Rotation{
+		// 缓冲全部进度，调用方不读取进度也不会阻塞轮换
+		progress: make(chan RotationProgress, len(files)),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(r.done)
+		defer close(r.progress)
+		var errs []error
+		for i, f := range files {
+			if err := ctx.Err(); err != nil {
+				errs = append(errs, err)
+				break
+			}
+			skipped, err := s.reencrypt(sealer, f.name, f.gen)
+			if err != nil {
+				err = fmt.Errorf("%s generation %d: %w", f.name, f.gen, err)
+				errs = append(errs, err)
+			}
+			r.progress <- RotationProgress{
+				Done:       i + 1,
+				Total:      len(files),
+				Service:    f.name,
+				Generation: f.gen,
+				Skipped:    skipped,
+				Err:        err,
+			}
+		}
+		// 旧格式已全部升级，之后放回的旧格式文件不再被接受
+		if len(errs) == 0 {
+			errs = append(errs, s.SetStrictAAD(true))
+		}
+		r.err = errors.Join(errs...)
+	}()
+	return r, nil
+}
+
+// reencrypt 使用当前密钥重新加密一个状态文件，原子替换
+func (s *FileStateStore) reencrypt(sealer Sealer, name string, gen int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encrypted, version, err := s.readGeneration(name, gen)
+	if errors.Is(err, ErrNotEnvelopeState) {
+		return false, s.reencryptStream(name, gen)
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		// 轮换过程中被新的 Save 轮转掉了
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if a, ok := sealer.(interface{ Active() string }); ok {
+		if h, ok := parseCipherHeader(encrypted); ok && h.id == a.Active() {
+			return true, nil
+		}
+	}
+	aad := StateAAD{Service: name, Version: version}
+	if aad.StoreID, err = s.loadStoreID(); err != nil {
+		return false, err
+	}
+	plaintext, err := sealer.Open(encrypted, aad)
+	if err != nil {
+		return false, err
+	}
+	defer Zero(plaintext)
+	resealed, err := sealer.Seal(plaintext, aad)
+	if err != nil {
+		return false, err
+	}
+	tmp, err := s.writeTemp(name, version, resealed)
+	if err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, s.genPath(name, gen)); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return false, syncDir(s.dir)
+}
+
+// reencryptStream 流式解密后重新流式加密，调用方需持有 s.mu
+func (s *FileStateStore) reencryptStream(name string, gen int) error {
+	storeID, err := s.loadStoreID()
+	if err != nil {
+		return err
+	}
+	r, version, err := s.openStreamGeneration(name, gen, storeID)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	aad := StateAAD{StoreID: storeID, Service: name, Version: version}
+	sc := s.crypter.(StreamCrypter)
+	tmp, err := s.writeStreamTemp(name, version, func(w io.Writer) error {
+		ew, err := sc.EncryptStream(w, aad)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(ew, r); err != nil {
+			return err
+		}
+		return ew.Close()
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.genPath(name, gen)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(s.dir)
+}
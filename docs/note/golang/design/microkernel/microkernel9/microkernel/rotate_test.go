@@ -0,0 +1,153 @@
+package microkernel
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestRotateReencryptsAllGenerations(t *testing.T) {
+	kr := NewKeyring()
+	defer kr.Close()
+	if err := kr.Add("k1", bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	s := NewFileStateStore(t.TempDir(), kr)
+	for _, v := range []string{"v1", "v2"} {
+		if err := s.Save("a", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SaveStream("b", 1, func(w io.Writer) error {
+		_, err := io.WriteString(w, "streamed")
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := kr.Add("k2", bytes.Repeat([]byte{2}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if err := kr.SetActive("k2"); err != nil {
+		t.Fatal(err)
+	}
+	r, err := s.Rotate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	var done int
+	for p := range r.Progress() {
+		if p.Err != nil || p.Skipped {
+			t.Fatalf("progress = %+v", p)
+		}
+		done++
+	}
+	if done != 3 {
+		t.Fatalf("rotated %d files, want 3", done)
+	}
+
+	// 旧密钥移除后全部状态仍然可以读取
+	if err := kr.Remove("k1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []struct {
+		name string
+		gen  int
+	}{{"a", 0}, {"a", 1}, {"b", 0}} {
+		info, err := s.Stat(f.name, f.gen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.KeyID != "k2" {
+			t.Fatalf("%s generation %d key = %q, want k2", f.name, f.gen, info.KeyID)
+		}
+	}
+	mustLoad(t, s, "a", "v2")
+	if got, err := s.LoadGeneration("a", 1); err != nil || got != "v1" {
+		t.Fatalf("generation 1 = %v, %v", got, err)
+	}
+	rc, _, err := s.LoadStream("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != "streamed" {
+		t.Fatalf("stream = %q, %v", data, err)
+	}
+
+	// 已使用当前密钥的文件跳过
+	r, err = s.Rotate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	for p := range r.Progress() {
+		if p.Service == "a" && !p.Skipped {
+			t.Fatalf("%s generation %d rotated again", p.Service, p.Generation)
+		}
+	}
+}
+
+func TestRotateUpgradesLegacyState(t *testing.T) {
+	key := bytes.Repeat([]byte{6}, 32)
+	c, err := NewAESCrypter(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	dir := t.TempDir()
+
+	// 最早的格式：没有文件头，密文不绑定附加认证数据
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, _ := json.Marshal("old")
+	nonce := make([]byte, aesgcm.NonceSize())
+	s := NewFileStateStore(dir, c)
+	if err := os.WriteFile(s.path("a"), aesgcm.Seal(bytes.Clone(nonce), nonce, plaintext, nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// 已有状态文件的存储不会自动开启严格模式
+	if strict, err := s.StrictAAD(); err != nil || strict {
+		t.Fatalf("StrictAAD = %v, %v", strict, err)
+	}
+	mustLoad(t, s, "a", "old")
+
+	r, err := s.Rotate(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	encrypted, _, err := s.readGeneration("a", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !BindsAAD(encrypted) {
+		t.Fatal("legacy state not upgraded")
+	}
+	if strict, _ := s.StrictAAD(); !strict {
+		t.Fatal("strict mode not enabled after rotation")
+	}
+	mustLoad(t, s, "a", "old")
+
+	// 严格模式下放回的旧格式文件被拒绝
+	if err := os.WriteFile(s.path("a"), aesgcm.Seal(bytes.Clone(nonce), nonce, plaintext, nil), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.LoadGeneration("a", 0); !errors.Is(err, ErrUnboundCiphertext) {
+		t.Fatalf("Load: err = %v, want ErrUnboundCiphertext", err)
+	}
+}
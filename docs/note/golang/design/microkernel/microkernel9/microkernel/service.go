@@ -1,5 +1,11 @@
 package microkernel
 
+import (
+	"context"
+	"microkernel/logger"
+	"time"
+)
+
 // Service 定义微内核的服务接口
 // 使用接口定义代替固定的struct,低耦合设计。
 type Service interface {
@@ -11,6 +17,24 @@ type Service interface {
 	Dependencies() []string // 新增接口
 }
 
+// StreamHandler 服务可选实现：流式处理事件
+// 服务把多条回复依次写入 out，返回后由内核关闭 out；ctx 取消时应尽快返回
+type StreamHandler interface {
+	HandleStream(ctx context.Context, evt Event, out chan<- Reply)
+}
+
+// LoggerSetter 服务可选实现：注册时接收内核派生的日志器
+// 日志器与内核共用输出，服务名称作为 service 字段
+type LoggerSetter interface {
+	SetLogger(*logger.Logger)
+}
+
+// EventTyper 服务可选实现：声明处理的事件类型，供管理工具补全和提示
+// 处理任意类型的服务不需要实现
+type EventTyper interface {
+	EventTypes() []string
+}
+
 // ServiceState 定义微内核服务状态
 type ServiceState int
 
@@ -34,4 +58,10 @@ type serviceMeta struct {
 	state ServiceState
 	// 依赖服务名称
 	deps []string
+	// 重启策略和已重启次数
+	restart    RestartPolicy
+	restarts   int
+	restarting bool
+	// 最近一次 StartService 或 RestartService 的启动耗时
+	startTime time.Duration
 }
@@ -0,0 +1,324 @@
+package microkernel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// KernelSnapshot 内核一致性快照：同一屏障时刻所有服务的状态和队列中尚未分发的事件
+type KernelSnapshot struct {
+	Version  int               `json:"version"`
+	Created  time.Time         `json:"created"`
+	Services []ServiceSnapshot `json:"services"`
+	// 队列中尚未分发的事件，回复通道不保存
+	Events []Event `json:"events"`
+}
+
+// ServiceSnapshot 快照中的一个服务
+type ServiceSnapshot struct {
+	Name  string         `json:"name"`
+	State string         `json:"state"` // Created/Running/Stopped
+	Env   *StateEnvelope `json:"envelope,omitempty"`
+}
+
+// 快照归档格式：
+//
+//	"MKSNAP" | 模式(1 字节) | 密文
+//
+// 模式 's' 为流式密文（加密器实现 StreamCrypter 时使用），'b' 为 Crypter.Encrypt 的整块密文
+var snapshotMagic = []byte("MKSNAP")
+
+const snapshotVersion = 1
+
+// snapshotAAD 快照归档的附加认证数据，归档不能当作某个服务的状态文件使用
+var snapshotAAD = StateAAD{StoreID: "snapshot", Service: "kernel", Version: snapshotVersion}
+
+// pauseRequest 快照请求 Listen 暂停分发
+type pauseRequest struct {
+	// Listen 在处理中的事件完成后发送队列中的事件
+	paused chan []Event
+	// 快照完成后把事件交还给 Listen，优先分发
+	resume chan []Event
+}
+
+// drainEvents 非阻塞地取出队列中的全部事件
+func drainEvents(ch chan Event) []Event {
+	var events []Event
+	for {
+		select {
+		case evt := <-ch:
+			events = append(events, evt)
+		default:
+			return events
+		}
+	}
+}
+
+// pause 暂停事件分发，返回队列中的事件和恢复函数
+// 恢复函数把事件按原顺序交还内核
+func (k *MicroKernel) pause() ([]Event, func([]Event)) {
+	req := pauseRequest{paused: make(chan []Event), resume: make(chan []Event)}
+	for k.listening.Load() {
+		select {
+		case k.pauseCh <- req:
+			queued := <-req.paused
+			return queued, func(events []Event) { req.resume <- events }
+		case <-time.After(10 * time.Millisecond):
+			// Listen 可能刚好退出，重新检查
+		}
+	}
+	// 没有事件循环：队列中的事件不会被消费，取出后原样放回
+	k.inflight.Wait()
+	queued := drainEvents(k.eventCh)
+	return queued, func(events []Event) {
+		go func() {
+			for _, evt := range events {
+				k.eventCh <- evt
+			}
+		}()
+	}
+}
+
+// Snapshot 在一个屏障时刻导出所有服务的状态和队列中的事件，加密写入 w
+//
+// 快照期间暂停事件分发并等待处理中的事件完成，同时阻止 Send 直接调用服务，
+// 得到的各服务状态是一致的；快照完成后事件按原顺序继续分发。
+func (k *MicroKernel) Snapshot(w io.Writer, crypter Crypter) error {
+	queued, resume := k.pause()
+	snap, err := k.snapshotLocked(queued)
+	resume(queued)
+	if err != nil {
+		return err
+	}
+	k.log.Info("snapshot taken", "services", len(snap.Services), "queued_events", len(snap.Events))
+	if err := writeSnapshot(w, crypter, snap); err != nil {
+		k.metrics.cryptoFailed("encrypt", err)
+		return err
+	}
+	return nil
+}
+
+func (k *MicroKernel) snapshotLocked(queued []Event) (*KernelSnapshot, error) {
+	// 写锁等待正在进行的 Send 完成
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	snap := &KernelSnapshot{Version: snapshotVersion, Created: time.Now().UTC(), Events: queued}
+	names := make([]string, 0, len(k.services))
+	for name := range k.services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		meta := k.services[name]
+		ss := ServiceSnapshot{Name: name, State: meta.state.String()}
+		if canExport(meta.svc) {
+			env, err := exportEnvelope(meta.svc)
+			if err != nil {
+				return nil, fmt.Errorf("snapshot %s: %w", name, err)
+			}
+			ss.Env = env
+		}
+		snap.Services = append(snap.Services, ss)
+	}
+	return snap, nil
+}
+
+func writeSnapshot(w io.Writer, crypter Crypter, snap *KernelSnapshot) error {
+	if sc, ok := crypter.(StreamCrypter); ok {
+		if _, err := w.Write(append(bytes.Clone(snapshotMagic), 's')); err != nil {
+			return err
+		}
+		bw := bufio.NewWriter(w)
+		ew, err := sc.EncryptStream(bw, snapshotAAD)
+		if err != nil {
+			return err
+		}
+		if err := json.NewEncoder(ew).Encode(snap); err != nil {
+			return err
+		}
+		if err := ew.Close(); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	sealed, err := crypter.Encrypt(json.RawMessage(data), snapshotAAD)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(append(bytes.Clone(snapshotMagic), 'b'), sealed...))
+	return err
+}
+
+// ReadSnapshot 解密快照归档
+func ReadSnapshot(r io.Reader, crypter Crypter) (*KernelSnapshot, error) {
+	header := make([]byte, len(snapshotMagic)+1)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("read snapshot header: %w", err)
+	}
+	if !bytes.Equal(header[:len(snapshotMagic)], snapshotMagic) {
+		return nil, errors.New("not a kernel snapshot")
+	}
+	var snap KernelSnapshot
+	switch header[len(snapshotMagic)] {
+	case 's':
+		sc, ok := crypter.(StreamCrypter)
+		if !ok {
+			return nil, fmt.Errorf("%w: %T", ErrStreamUnsupported, crypter)
+		}
+		dr, err := sc.DecryptStream(r, snapshotAAD)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.NewDecoder(dr).Decode(&snap); err != nil {
+			return nil, fmt.Errorf("decode snapshot: %w", err)
+		}
+		// 读到最后一块，确认归档没有被截断
+		if _, err := io.Copy(io.Discard, dr); err != nil {
+			return nil, err
+		}
+	case 'b':
+		sealed, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		// 快照格式晚于附加认证数据，总是要求绑定
+		if err := requireAAD(sealed, snapshotAAD); err != nil {
+			return nil, err
+		}
+		raw, err := crypter.Decrypt(sealed, snapshotAAD)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.Marshal(raw)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, fmt.Errorf("decode snapshot: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown snapshot mode %q", header[len(snapshotMagic)])
+	}
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	return &snap, nil
+}
+
+// Restore 从快照归档恢复内核
+//
+// 快照中的服务必须已经注册（服务的代码不在快照中）；状态经过迁移链导入服务，
+// 同时写入状态存储，队列中的事件按原顺序重新入队（没有回复通道）。
+// 恢复后由调用方 StartAll 并启动 Listen。
+func (k *MicroKernel) Restore(r io.Reader, crypter Crypter) error {
+	snap, err := ReadSnapshot(r, crypter)
+	if err != nil {
+		k.metrics.cryptoFailed("decrypt", err)
+		return err
+	}
+
+	if err := k.restoreLocked(snap); err != nil {
+		return err
+	}
+
+	// 事件循环可能还没有启动，队列放不下时在后台按顺序入队
+	go func() {
+		for _, evt := range snap.Events {
+			k.eventCh <- evt
+		}
+	}()
+	k.log.Info("snapshot restored", "created", snap.Created.Format(time.RFC3339),
+		"services", len(snap.Services), "queued_events", len(snap.Events))
+	return nil
+}
+
+// restoreLocked 检查快照中的服务都已注册后导入状态
+// 导入前导出各服务的当前状态，任何一个服务导入或保存失败时恢复已处理的服务，快照全部生效或都不生效
+func (k *MicroKernel) restoreLocked(snap *KernelSnapshot) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	var missing []string
+	for _, ss := range snap.Services {
+		meta, ok := k.services[ss.Name]
+		if !ok {
+			missing = append(missing, ss.Name)
+			continue
+		}
+		if ss.Env != nil && !canImport(meta.svc) {
+			return fmt.Errorf("restore %s: service cannot import state", ss.Name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("restore: services not registered: %s", strings.Join(missing, ", "))
+	}
+
+	prev := make(map[string]*StateEnvelope)
+	for _, ss := range snap.Services {
+		if svc := k.services[ss.Name].svc; ss.Env != nil && canExport(svc) {
+			env, err := exportEnvelope(svc)
+			if err != nil {
+				return fmt.Errorf("restore %s: export current state: %w", ss.Name, err)
+			}
+			prev[ss.Name] = env
+		}
+	}
+	var imported, saved []string
+	rollback := func(cause error) error {
+		errs := []error{cause}
+		for _, name := range saved {
+			if env, ok := prev[name]; ok {
+				errs = append(errs, k.stateStore.Save(name, env))
+			}
+		}
+		for _, name := range imported {
+			env, ok := prev[name]
+			if !ok {
+				k.log.Warn("restore rollback: service cannot export, state not reverted", "service", name)
+				continue
+			}
+			if err := importEnvelope(k.services[name].svc, env); err != nil {
+				errs = append(errs, fmt.Errorf("revert %s: %w", name, err))
+			}
+		}
+		if len(errs) > 1 {
+			k.log.Error("restore rollback failed", "err", errors.Join(errs[1:]...))
+		}
+		return errors.Join(errs...)
+	}
+
+	// 先全部导入，都成功后再写入存储
+	for _, ss := range snap.Services {
+		if ss.Env == nil {
+			continue
+		}
+		env := *ss.Env
+		if err := importEnvelope(k.services[ss.Name].svc, &env); err != nil {
+			return rollback(fmt.Errorf("restore %s: %w", ss.Name, err))
+		}
+		imported = append(imported, ss.Name)
+	}
+	if k.stateStore == nil {
+		return nil
+	}
+	for _, ss := range snap.Services {
+		if ss.Env == nil {
+			continue
+		}
+		if err := k.stateStore.Save(ss.Name, ss.Env); err != nil {
+			return rollback(fmt.Errorf("restore %s: %w", ss.Name, err))
+		}
+		saved = append(saved, ss.Name)
+	}
+	return nil
+}
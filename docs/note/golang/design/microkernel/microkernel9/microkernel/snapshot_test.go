@@ -0,0 +1,38 @@
+package microkernel
+
+import "testing"
+
+func TestRestoreIsAllOrNothing(t *testing.T) {
+	store := NewMemoryStateStore()
+	k := NewMicroKernel(store)
+	a := &counter{name: "a", version: 1, n: 1}
+	b := &counter{name: "b", version: 1, n: 2}
+	for _, svc := range []Service{a, b} {
+		if err := k.Register(svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// b 的状态版本比服务新，无法导入
+	snap := &KernelSnapshot{Version: snapshotVersion, Services: []ServiceSnapshot{
+		{Name: "a", Env: &StateEnvelope{Service: "a", Version: 1, Encoding: EncodingJSON, Data: []byte("10")}},
+		{Name: "b", Env: &StateEnvelope{Service: "b", Version: 5, Encoding: EncodingJSON, Data: []byte("20")}},
+	}}
+	if err := k.restoreLocked(snap); err == nil {
+		t.Fatal("restore succeeded")
+	}
+	if a.n != 1 || b.n != 2 {
+		t.Fatalf("state = a %d b %d, want a 1 b 2", a.n, b.n)
+	}
+	if store.Exists("a") || store.Exists("b") {
+		t.Fatal("failed restore wrote to the state store")
+	}
+
+	snap.Services[1].Env.Version = 1
+	if err := k.restoreLocked(snap); err != nil {
+		t.Fatal(err)
+	}
+	if a.n != 10 || b.n != 20 {
+		t.Fatalf("state = a %d b %d, want a 10 b 20", a.n, b.n)
+	}
+}
@@ -1,8 +1,14 @@
 package microkernel
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 )
 
 // Exportable 旧服务可选实现：导出状态
@@ -15,46 +21,150 @@ type Importable interface {
 	ImportState(state any) error
 }
 
-// 也可以用一个单独的接口，然后实现接口，从而可以支持多种后端存储
-//
-//	type StateStore interface {
-//		Save(name string, state any) error
-//		Load(name string) (any, error)
-//		Exists(name string) bool
-//	}
-type StateStore struct {
-	dir     string
-	crypter Crypter
+// StateStore 状态存储接口，内核可以使用任意后端
+// 内置实现：
+//   - FileStateStore：每个服务一个加密文件
+//   - MemoryStateStore：内存存储，用于测试
+//   - LogStateStore：单文件追加日志，支持压缩
+type StateStore interface {
+	Save(name string, state any) error
+	Load(name string) (any, error)
+	Exists(name string) bool
+	Delete(name string) error
+	// List 返回所有已保存状态的服务名称
+	List() ([]string, error)
+	// Watch 订阅状态变化，ctx 取消后关闭通道
+	Watch(ctx context.Context) (<-chan StateChange, error)
 }
 
-func NewStateStore(dir string, crypter Crypter) *StateStore {
-	return &StateStore{dir: dir, crypter: crypter}
+// StateOp 状态变化类型
+type StateOp int
+
+const (
+	StateSaved StateOp = iota
+	StateDeleted
+)
+
+func (op StateOp) String() string {
+	return [...]string{"Saved", "Deleted"}[op]
 }
 
-func (s *StateStore) path(name string) string {
-	return filepath.Join(s.dir, name+".state")
+// StateChange 状态变化通知
+type StateChange struct {
+	Name string
+	Op   StateOp
 }
 
-func (s *StateStore) Save(name string, state any) error {
-	encrypted, err := s.crypter.Encrypt(state)
-	if err != nil {
-		return err
+// watchHub 管理 Watch 订阅者，供存储实现复用
+// 通知不阻塞写入：订阅者处理不过来时丢弃通知
+type watchHub struct {
+	mu   sync.Mutex
+	subs map[chan StateChange]struct{}
+}
+
+func (h *watchHub) subscribe(ctx context.Context) <-chan StateChange {
+	ch := make(chan StateChange, 16)
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[chan StateChange]struct{})
 	}
-	if err := os.MkdirAll(s.dir, 0755); err != nil {
-		return err
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		h.mu.Lock()
+		delete(h.subs, ch)
+		h.mu.Unlock()
+		close(ch)
+	}()
+	return ch
+}
+
+func (h *watchHub) notify(c StateChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- c:
+		default:
+		}
 	}
-	return os.WriteFile(s.path(name), encrypted, 0600)
 }
 
-func (s *StateStore) Load(name string) (any, error) {
-	data, err := os.ReadFile(s.path(name))
+// loadOrCreateStoreID 读取存储 ID 文件，不存在时生成随机 ID，created 表示本次生成
+// 存储 ID 绑定到状态密文的附加认证数据中，其他存储的状态文件复制过来无法解密
+func loadOrCreateStoreID(path string) (id string, created bool, err error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		id = strings.TrimSpace(string(data))
+		if id == "" {
+			return "", false, errors.New("empty store id file " + path)
+		}
+		return id, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return "", false, err
+	}
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", false, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", false, err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, os.ErrExist) {
+		return loadOrCreateStoreID(path)
+	}
 	if err != nil {
-		return nil, err
+		return "", false, err
+	}
+	id = hex.EncodeToString(buf)
+	if _, err := f.WriteString(id + "\n"); err != nil {
+		f.Close()
+		return "", false, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", false, err
+	}
+	return id, true, f.Close()
+}
+
+// 严格模式标记文件 <存储 ID 文件>.strict：存在时存储只解密绑定了附加认证数据的密文，
+// 旧格式的状态文件被放回存储目录也无法加载（防止替换和重放）
+// 新建的存储自动开启；旧的存储在全部状态升级为新格式后开启（FileStateStore.Rotate、LogStateStore.Compact）
+func strictMarker(idPath string) string {
+	return idPath + ".strict"
+}
+
+func loadStrictAAD(idPath string) (bool, error) {
+	_, err := os.Stat(strictMarker(idPath))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
 	}
-	return s.crypter.Decrypt(data)
+	return err == nil, err
 }
 
-func (s *StateStore) Exists(name string) bool {
-	_, err := os.Stat(s.path(name))
-	return err == nil
+func saveStrictAAD(idPath string, on bool) error {
+	if !on {
+		err := os.Remove(strictMarker(idPath))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	return os.WriteFile(strictMarker(idPath), []byte("aad-required\n"), 0644)
+}
+
+// envelopeVersion 状态为信封时返回其 schema 版本，用于附加认证数据
+func envelopeVersion(state any) int {
+	switch env := state.(type) {
+	case *StateEnvelope:
+		return env.Version
+	case StateEnvelope:
+		return env.Version
+	}
+	return 0
 }
@@ -0,0 +1,175 @@
+package microkernel
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFileStore(t *testing.T) (*FileStateStore, *AESCrypter) {
+	t.Helper()
+	c, err := NewAESCrypter(bytes.Repeat([]byte{4}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return NewFileStateStore(t.TempDir(), c), c
+}
+
+func TestFileStateStoreGenerations(t *testing.T) {
+	s, _ := newTestFileStore(t)
+	for _, v := range []string{"v1", "v2", "v3", "v4"} {
+		if err := s.Save("a", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustLoad(t, s, "a", "v4")
+	for gen, want := range []string{"v4", "v3", "v2"} {
+		got, err := s.LoadGeneration("a", gen)
+		if err != nil || got != want {
+			t.Fatalf("generation %d = %v, %v, want %s", gen, got, err, want)
+		}
+	}
+	// 默认保留 3 代，更旧的被轮转掉
+	if gens := s.Generations("a"); len(gens) != 3 {
+		t.Fatalf("generations = %v", gens)
+	}
+
+	// 临时文件写入后原子改名，不会留在目录中
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Name(), ".tmp-") {
+			t.Fatalf("temporary file %s left behind", e.Name())
+		}
+		if info, _ := e.Info(); strings.HasPrefix(e.Name(), "a.state") && info.Mode().Perm() != 0600 {
+			t.Fatalf("%s has permissions %04o", e.Name(), info.Mode().Perm())
+		}
+	}
+
+	// 崩溃时留下的临时文件不影响加载和列表
+	if err := os.WriteFile(filepath.Join(s.dir, "a.tmp-123"), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if names, _ := s.List(); len(names) != 1 || names[0] != "a" {
+		t.Fatalf("List = %v, want [a]", names)
+	}
+	mustLoad(t, s, "a", "v4")
+}
+
+func TestFileStateStoreChecksumFallback(t *testing.T) {
+	s, _ := newTestFileStore(t)
+	k := NewMicroKernel(s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := k.Subscribe(ctx, EventStateFallback)
+
+	for _, v := range []string{"v1", "v2"} {
+		if err := s.Save("a", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := s.genPath("a", 0)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 1
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.LoadGeneration("a", 0); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("LoadGeneration: err = %v, want checksum mismatch", err)
+	}
+	mustLoad(t, s, "a", "v1")
+	select {
+	case evt := <-events:
+		if !strings.Contains(evt.Content, "service a") || !strings.Contains(evt.Content, "generation 1") {
+			t.Fatalf("event = %q", evt.Content)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no state fallback event")
+	}
+
+	// 所有代都不可用时返回错误，不回退到空状态
+	if err := os.WriteFile(s.genPath("a", 1), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load("a"); err == nil {
+		t.Fatal("Load succeeded with every generation corrupted")
+	}
+}
+
+// writeStateFile 写入指定魔数和版本的状态文件，MKS1 没有版本字段
+func writeStateFile(t *testing.T, s *FileStateStore, c Crypter, name string, magic []byte, version int, state any) {
+	t.Helper()
+	id, err := s.StoreID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, err := c.Encrypt(state, StateAAD{StoreID: id, Service: name, Version: version})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(ct)
+	data := append([]byte{}, magic...)
+	if !bytes.Equal(magic, stateFileMagicV1) {
+		data = binary.BigEndian.AppendUint32(data, uint32(version))
+	}
+	data = append(append(data, sum[:]...), ct...)
+	if err := os.WriteFile(s.path(name), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileStateStoreHeaders(t *testing.T) {
+	s, c := newTestFileStore(t)
+	writeStateFile(t, s, c, "v1", stateFileMagicV1, 0, "old")
+	writeStateFile(t, s, c, "v2", stateFileMagic, 3, "new")
+
+	mustLoad(t, s, "v1", "old")
+	mustLoad(t, s, "v2", "new")
+	for name, want := range map[string]StateFileInfo{
+		"v1": {Format: "MKS1", Version: 0, KeyID: c.id},
+		"v2": {Format: "MKS2", Version: 3, KeyID: c.id},
+	} {
+		info, err := s.Stat(name, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Format != want.Format || info.Version != want.Version || info.KeyID != want.KeyID {
+			t.Fatalf("Stat(%s) = %s version %d key %q, want %s version %d key %q",
+				name, info.Format, info.Version, info.KeyID, want.Format, want.Version, want.KeyID)
+		}
+	}
+
+	// 文件头中的版本参与附加认证数据，被改写后解密失败
+	data, err := os.ReadFile(s.path("v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.BigEndian.PutUint32(data[4:8], 4)
+	if err := os.WriteFile(s.path("v2"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load("v2"); err == nil {
+		t.Fatal("Load succeeded after the header version was changed")
+	}
+
+	// 截断到文件头以内
+	if err := os.WriteFile(s.path("v1"), stateFileMagicV1, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Load("v1"); err == nil || !strings.Contains(err.Error(), "truncated") {
+		t.Fatalf("Load: err = %v, want truncated", err)
+	}
+}
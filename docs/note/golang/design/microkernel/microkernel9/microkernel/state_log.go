@@ -0,0 +1,440 @@
+package microkernel
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// LogStateStore 单文件追加日志状态存储
+//
+// 每次 Save/Delete 都在文件末尾追加一条记录，内存中只保存每个服务最新记录的位置。
+// 记录格式：[4 字节长度][4 字节 CRC32][JSON 负载]
+// 打开时回放日志，末尾写了一半的记录会被截断；中间的记录损坏时拒绝打开，不修改文件。
+// 无效记录（被覆盖或删除）占用超过一半空间时自动压缩，也可以手动调用 Compact。
+// 存储 ID 保存在 <path>.id，与服务名称、schema 版本一起作为附加认证数据绑定到密文。
+// 严格模式下（新建的存储默认开启）不绑定附加认证数据的旧格式密文无法加载，压缩时旧格式的记录会被升级。
+type LogStateStore struct {
+	mu      sync.Mutex
+	path    string
+	crypter Crypter
+	file    *os.File
+	// 文件当前大小，即下一条记录的写入位置
+	size int64
+	// 服务名称 -> 最新记录的位置
+	index map[string]logEntry
+	// 有效记录占用的字节数
+	live int64
+	// 文件超过该大小才考虑自动压缩
+	compactMinSize int64
+	storeID        string
+	hub            watchHub
+	// 打开时截断了写了一半的记录
+	repaired error
+	// 严格模式：拒绝没有绑定附加认证数据的旧格式密文，见 SetStrictAAD
+	strict bool
+}
+
+type logEntry struct {
+	offset int64 // 记录起始位置（包括记录头）
+	length int64 // 记录总长度（包括记录头）
+}
+
+type logRecord struct {
+	Op      string `json:"op"` // put, del
+	Name    string `json:"name"`
+	Version int    `json:"version,omitempty"` // schema 版本，用于附加认证数据
+	Data    []byte `json:"data,omitempty"`
+}
+
+const logRecordHeaderSize = 8
+
+// NewLogStateStore 打开（不存在时创建）日志文件
+func NewLogStateStore(path string, crypter Crypter) (*LogStateStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	storeID, created, err := loadOrCreateStoreID(path + ".id")
+	if err != nil {
+		return nil, err
+	}
+	// 新建的存储开启严格模式
+	if st, err := os.Stat(path); created && (errors.Is(err, os.ErrNotExist) || err == nil && st.Size() == 0) {
+		if err := saveStrictAAD(path+".id", true); err != nil {
+			return nil, err
+		}
+	}
+	strict, err := loadStrictAAD(path + ".id")
+	if err != nil {
+		return nil, err
+	}
+	s := &LogStateStore{
+		path:           path,
+		crypter:        crypter,
+		compactMinSize: 1 << 20,
+		storeID:        storeID,
+		strict:         strict,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Repaired 返回打开时修复日志的原因（截断了末尾写了一半的记录），没有修复时为 nil
+func (s *LogStateStore) Repaired() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.repaired
+}
+
+// open 打开日志文件并回放记录，重建索引
+func (s *LogStateStore) open() error {
+	f, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.index = make(map[string]logEntry)
+	s.live = 0
+
+	r := bufio.NewReader(f)
+	var offset int64
+	for {
+		rec, n, err := readLogRecord(r, st.Size()-offset)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// 只有一直延伸到文件末尾的记录才可能是崩溃时写了一半的记录
+			// 中间的记录损坏时截断会丢掉之后全部的有效记录
+			torn := errors.Is(err, errTornRecord) || errors.Is(err, errRecordChecksum) && offset+n == st.Size()
+			if !torn {
+				f.Close()
+				return fmt.Errorf("%w: %s corrupted at offset %d: %v", ErrIntegrity, s.path, offset, err)
+			}
+			// 截断到最后一条完整记录，由内核报告
+			s.repaired = fmt.Errorf("truncated %s at offset %d: %w", s.path, offset, err)
+			if err := f.Truncate(offset); err != nil {
+				f.Close()
+				return err
+			}
+			break
+		}
+		s.apply(rec, logEntry{offset: offset, length: n})
+		offset += n
+	}
+	s.size = offset
+	return nil
+}
+
+func (s *LogStateStore) apply(rec logRecord, e logEntry) {
+	if old, ok := s.index[rec.Name]; ok {
+		s.live -= old.length
+		delete(s.index, rec.Name)
+	}
+	if rec.Op == "put" {
+		s.index[rec.Name] = e
+		s.live += e.length
+	}
+}
+
+var (
+	// errTornRecord 记录超出文件末尾
+	errTornRecord = errors.New("torn record")
+	// errRecordChecksum 记录的校验和不匹配
+	errRecordChecksum = errors.New("record checksum mismatch")
+)
+
+// readLogRecord 读取一条记录，remaining 为记录起点之后剩余的字节数
+// 长度超出剩余字节数的记录头按写了一半的记录处理，损坏的长度不会导致分配大块内存
+// 校验和不匹配时仍返回记录长度，由调用方判断记录是否位于文件末尾
+func readLogRecord(r io.Reader, remaining int64) (logRecord, int64, error) {
+	var rec logRecord
+	var header [logRecordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return rec, 0, fmt.Errorf("%w: short record header", errTornRecord)
+		}
+		return rec, 0, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+	if int64(length) > remaining-logRecordHeaderSize {
+		return rec, 0, fmt.Errorf("%w: record length %d exceeds remaining %d bytes", errTornRecord, length, remaining-logRecordHeaderSize)
+	}
+	n := logRecordHeaderSize + int64(length)
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return rec, 0, fmt.Errorf("%w: short record payload", errTornRecord)
+	}
+	if crc32.ChecksumIEEE(payload) != sum {
+		return rec, n, errRecordChecksum
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, 0, err
+	}
+	return rec, n, nil
+}
+
+func encodeLogRecord(rec logRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, logRecordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(payload))
+	copy(buf[logRecordHeaderSize:], payload)
+	return buf, nil
+}
+
+// appendRecord 追加记录并同步到磁盘，调用方需持有 s.mu
+func (s *LogStateStore) appendRecord(rec logRecord) error {
+	buf, err := encodeLogRecord(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.apply(rec, logEntry{offset: s.size, length: int64(len(buf))})
+	s.size += int64(len(buf))
+	return nil
+}
+
+func (s *LogStateStore) Save(name string, state any) error {
+	version := envelopeVersion(state)
+	encrypted, err := s.crypter.Encrypt(state, StateAAD{StoreID: s.storeID, Service: name, Version: version})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	err = s.appendRecord(logRecord{Op: "put", Name: name, Version: version, Data: encrypted})
+	if err == nil {
+		err = s.maybeCompact()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.hub.notify(StateChange{Name: name, Op: StateSaved})
+	return nil
+}
+
+func (s *LogStateStore) Load(name string) (any, error) {
+	s.mu.Lock()
+	rec, err := s.read(name)
+	strict := s.strict
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if rec.Name != name {
+		return nil, fmt.Errorf("%w: record for %s found at index of %s", ErrIntegrity, rec.Name, name)
+	}
+	aad := StateAAD{StoreID: s.storeID, Service: name, Version: rec.Version}
+	if strict {
+		if err := requireAAD(rec.Data, aad); err != nil {
+			return nil, err
+		}
+	}
+	return s.crypter.Decrypt(rec.Data, aad)
+}
+
+// read 读取服务的最新记录，调用方需持有 s.mu
+func (s *LogStateStore) read(name string) (logRecord, error) {
+	e, ok := s.index[name]
+	if !ok {
+		return logRecord{}, fmt.Errorf("state %s not found", name)
+	}
+	rec, _, err := readLogRecord(io.NewSectionReader(s.file, e.offset, e.length), e.length)
+	return rec, err
+}
+
+func (s *LogStateStore) Exists(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.index[name]
+	return ok
+}
+
+func (s *LogStateStore) Delete(name string) error {
+	s.mu.Lock()
+	if _, ok := s.index[name]; !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	err := s.appendRecord(logRecord{Op: "del", Name: name})
+	if err == nil {
+		err = s.maybeCompact()
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	s.hub.notify(StateChange{Name: name, Op: StateDeleted})
+	return nil
+}
+
+func (s *LogStateStore) List() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	names := make([]string, 0, len(s.index))
+	for name := range s.index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *LogStateStore) Watch(ctx context.Context) (<-chan StateChange, error) {
+	return s.hub.subscribe(ctx), nil
+}
+
+// StrictAAD 是否为严格模式
+func (s *LogStateStore) StrictAAD() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.strict
+}
+
+// SetStrictAAD 开启或关闭严格模式并记录在 <path>.id.strict
+// 开启前先用 Compact 把旧格式的记录升级
+func (s *LogStateStore) SetStrictAAD(on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := saveStrictAAD(s.path+".id", on); err != nil {
+		return err
+	}
+	s.strict = on
+	return nil
+}
+
+// Compact 只保留每个服务的最新记录，重写日志文件
+// 加密器实现 Sealer 时，旧格式的记录重新加密为绑定附加认证数据的格式，完成后开启严格模式
+func (s *LogStateStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upgraded, err := s.upgradeRecords()
+	if err != nil {
+		return err
+	}
+	if err := s.compact(); err != nil {
+		return err
+	}
+	if upgraded && !s.strict {
+		if err := saveStrictAAD(s.path+".id", true); err != nil {
+			return err
+		}
+		s.strict = true
+	}
+	return nil
+}
+
+// upgradeRecords 把旧格式的有效记录重新加密后追加，返回是否全部记录都已绑定附加认证数据
+// 调用方需持有 s.mu，随后的压缩会丢弃旧记录
+func (s *LogStateStore) upgradeRecords() (bool, error) {
+	sealer, ok := s.crypter.(Sealer)
+	if !ok {
+		return false, nil
+	}
+	names := make([]string, 0, len(s.index))
+	for name := range s.index {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rec, err := s.read(name)
+		if err != nil {
+			return false, err
+		}
+		if BindsAAD(rec.Data) {
+			continue
+		}
+		aad := StateAAD{StoreID: s.storeID, Service: name, Version: rec.Version}
+		plaintext, err := sealer.Open(rec.Data, aad)
+		if err != nil {
+			return false, fmt.Errorf("upgrade %s: %w", name, err)
+		}
+		rec.Data, err = sealer.Seal(plaintext, aad)
+		Zero(plaintext)
+		if err != nil {
+			return false, err
+		}
+		if err := s.appendRecord(rec); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// maybeCompact 无效记录超过一半时压缩，调用方需持有 s.mu
+func (s *LogStateStore) maybeCompact() error {
+	if s.size < s.compactMinSize || s.live*2 > s.size {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact 把有效记录写入临时文件，同步后原子替换原文件，调用方需持有 s.mu
+func (s *LogStateStore) compact() error {
+	tmpPath := s.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpPath)
+
+	w := bufio.NewWriter(tmp)
+	for name := range s.index {
+		e := s.index[name]
+		if _, err := io.Copy(w, io.NewSectionReader(s.file, e.offset, e.length)); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(s.path)); err != nil {
+		return err
+	}
+	s.file.Close()
+	return s.open()
+}
+
+// Close 关闭日志文件
+func (s *LogStateStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
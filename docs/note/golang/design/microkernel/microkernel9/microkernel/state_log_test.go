@@ -0,0 +1,194 @@
+package microkernel
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openLogStore(t *testing.T, path string) *LogStateStore {
+	t.Helper()
+	crypter, err := NewAESCrypter(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewLogStateStore(path, crypter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func mustLoad(t *testing.T, s StateStore, name string, want any) {
+	t.Helper()
+	got, err := s.Load(name)
+	if err != nil {
+		t.Fatalf("Load(%s): %v", name, err)
+	}
+	if got != want {
+		t.Fatalf("Load(%s) = %v, want %v", name, got, want)
+	}
+}
+
+func TestLogStateStoreReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.log")
+	s := openLogStore(t, path)
+	for _, v := range []string{"a1", "a2"} {
+		if err := s.Save("a", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Save("b", "b1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Exists("b") {
+		t.Fatal("b exists after Delete")
+	}
+	s.Close()
+
+	// 重新打开后回放得到每个服务最新的记录，删除的服务不再存在
+	s = openLogStore(t, path)
+	mustLoad(t, s, "a", "a2")
+	if s.Exists("b") {
+		t.Fatal("b exists after replay")
+	}
+	if _, err := s.Load("b"); err == nil {
+		t.Fatal("Load of deleted state succeeded")
+	}
+	if names, _ := s.List(); len(names) != 1 || names[0] != "a" {
+		t.Fatalf("List = %v, want [a]", names)
+	}
+	if err := s.Repaired(); err != nil {
+		t.Fatalf("Repaired = %v", err)
+	}
+}
+
+func TestLogStateStoreTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.log")
+	s := openLogStore(t, path)
+	if err := s.Save("a", "a1"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	good, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, err := encodeLogRecord(logRecord{Op: "put", Name: "a", Data: []byte("x")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := bytes.Clone(last)
+	corrupt[len(corrupt)-1] ^= 1
+
+	tails := map[string][]byte{
+		"short header":      last[:3],
+		"short payload":     last[:len(last)-2],
+		"checksum mismatch": corrupt,
+	}
+	for name, tail := range tails {
+		t.Run(name, func(t *testing.T) {
+			if err := os.WriteFile(path, append(bytes.Clone(good), tail...), 0600); err != nil {
+				t.Fatal(err)
+			}
+			s := openLogStore(t, path)
+			if s.Repaired() == nil {
+				t.Fatal("torn tail not reported")
+			}
+			mustLoad(t, s, "a", "a1")
+			if st, _ := os.Stat(path); st.Size() != int64(len(good)) {
+				t.Fatalf("size = %d, want %d", st.Size(), len(good))
+			}
+			// 截断后可以继续追加
+			if err := s.Save("a", "a2"); err != nil {
+				t.Fatal(err)
+			}
+			s.Close()
+			mustLoad(t, openLogStore(t, path), "a", "a2")
+		})
+	}
+}
+
+func TestLogStateStoreRefusesMidFileCorruption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.log")
+	s := openLogStore(t, path)
+	for _, v := range []string{"a1", "a2"} {
+		if err := s.Save("a", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.Close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 损坏第一条记录的负载，之后的记录仍然完好
+	data[logRecordHeaderSize+2] ^= 1
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	crypter, err := NewAESCrypter(bytes.Repeat([]byte{7}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer crypter.Close()
+	if _, err := NewLogStateStore(path, crypter); !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("open: err = %v, want ErrIntegrity", err)
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(after, data) {
+		t.Fatal("corrupted log was modified")
+	}
+}
+
+func TestLogStateStoreCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.log")
+	s := openLogStore(t, path)
+	for i := 0; i < 10; i++ {
+		if err := s.Save("a", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Save("b", "b1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save("c", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete("c"); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.Stat(path)
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(path)
+	if after.Size() >= before.Size() {
+		t.Fatalf("size %d after compaction, was %d", after.Size(), before.Size())
+	}
+	if after.Size() != s.live {
+		t.Fatalf("size = %d, live = %d", after.Size(), s.live)
+	}
+	if _, err := os.Stat(path + ".compact"); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("temporary file left behind: %v", err)
+	}
+	mustLoad(t, s, "a", float64(9))
+	s.Close()
+
+	s = openLogStore(t, path)
+	mustLoad(t, s, "a", float64(9))
+	mustLoad(t, s, "b", "b1")
+	if s.Exists("c") {
+		t.Fatal("deleted state survived compaction")
+	}
+}
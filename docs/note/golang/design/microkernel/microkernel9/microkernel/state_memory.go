@@ -0,0 +1,80 @@
+package microkernel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryStateStore 内存状态存储，用于测试
+// 状态以 JSON 保存，Load 得到的值与文件存储一致（例如数字统一为 float64）
+type MemoryStateStore struct {
+	mu     sync.RWMutex
+	states map[string][]byte
+	hub    watchHub
+}
+
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{states: make(map[string][]byte)}
+}
+
+func (s *MemoryStateStore) Save(name string, state any) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.states[name] = data
+	s.mu.Unlock()
+	s.hub.notify(StateChange{Name: name, Op: StateSaved})
+	return nil
+}
+
+func (s *MemoryStateStore) Load(name string) (any, error) {
+	s.mu.RLock()
+	data, ok := s.states[name]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("state %s not found", name)
+	}
+	var result any
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *MemoryStateStore) Exists(name string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.states[name]
+	return ok
+}
+
+func (s *MemoryStateStore) Delete(name string) error {
+	s.mu.Lock()
+	_, ok := s.states[name]
+	delete(s.states, name)
+	s.mu.Unlock()
+	if ok {
+		s.hub.notify(StateChange{Name: name, Op: StateDeleted})
+	}
+	return nil
+}
+
+func (s *MemoryStateStore) List() ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.states))
+	for name := range s.states {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *MemoryStateStore) Watch(ctx context.Context) (<-chan StateChange, error) {
+	return s.hub.subscribe(ctx), nil
+}
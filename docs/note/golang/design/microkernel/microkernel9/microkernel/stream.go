@@ -0,0 +1,490 @@
+package microkernel
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// StreamExporter 服务可选实现：把状态流式写入 w
+// 状态很大的服务实现该接口，避免整个状态在内存中编码和加密
+type StreamExporter interface {
+	ExportStateTo(w io.Writer) error
+}
+
+// StreamImporter 服务可选实现：从 r 流式读取状态
+// r 在读到被篡改或截断的数据时返回 ErrIntegrity，服务应放弃已读取的部分状态
+type StreamImporter interface {
+	ImportStateFrom(r io.Reader) error
+}
+
+// StreamCrypter 流式加解密，AESCrypter、Keyring 和 EnvelopeCrypter 都实现了该接口
+// EncryptStream 返回的 Writer 必须 Close，最后一块在 Close 时写出
+type StreamCrypter interface {
+	EncryptStream(w io.Writer, aad StateAAD) (io.WriteCloser, error)
+	DecryptStream(r io.Reader, aad StateAAD) (io.Reader, error)
+}
+
+// StreamStateStore 支持流式保存的状态存储，FileStateStore 实现了该接口
+// 内核在服务实现 StreamExporter/StreamImporter 时优先使用流式接口
+type StreamStateStore interface {
+	// SaveStream 保存 write 写出的状态，version 为状态 schema 版本
+	SaveStream(name string, version int, write func(w io.Writer) error) error
+	// LoadStream 打开最新一代的流式状态，返回状态 schema 版本
+	// 状态不是流式保存的返回 ErrNotStream
+	LoadStream(name string) (io.ReadCloser, int, error)
+}
+
+var (
+	// ErrNotStream 状态不是流式保存的
+	ErrNotStream = errors.New("state is not stored as a stream")
+	// ErrStreamUnsupported 加密器不支持流式加密
+	ErrStreamUnsupported = errors.New("crypter does not support streaming")
+)
+
+// EncodingStream 流式状态缓冲到信封中时使用的编码（原始字节）
+const EncodingStream = "stream"
+
+// 流式密文格式（STREAM 构造）：
+//
+//	"MKT" | 版本(1 字节) | 密钥 ID 长度(1 字节) | 密钥 ID | 盐(16 字节) | 块 0 | 块 1 | ...
+//
+// 每个流使用 HKDF(密钥, 盐) 派生独立的块密钥和 7 字节 nonce 前缀，
+// 块的 nonce 为 前缀 | 块序号(4 字节) | 最后一块标记(1 字节)，附加认证数据为 文件头 + StateAAD。
+// 块被重排、删除或在块边界截断都会导致认证失败。
+var streamMagic = []byte("MKT")
+
+const (
+	streamVersion   = 1
+	streamSaltSize  = 16
+	streamChunkSize = 64 << 10
+)
+
+// streamKeySource 按 ID 派生流密钥，id 为空时使用当前密钥，返回实际使用的密钥 ID
+type streamKeySource interface {
+	deriveStreamKey(id string, salt []byte, aad StateAAD) (string, []byte, error)
+}
+
+func deriveStreamKey(key, salt []byte) []byte {
+	return hkdfSHA256(key, salt, []byte("microkernel state stream v1"), 32+7)
+}
+
+func (a *AESCrypter) deriveStreamKey(id string, salt []byte, _ StateAAD) (string, []byte, error) {
+	if id != "" && id != a.id {
+		return "", nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return a.id, deriveStreamKey(a.key, salt), nil
+}
+
+func (k *Keyring) deriveStreamKey(id string, salt []byte, _ StateAAD) (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if id == "" {
+		id = k.active
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return "", nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return id, deriveStreamKey(key, salt), nil
+}
+
+func (e *EnvelopeCrypter) deriveStreamKey(id string, salt []byte, aad StateAAD) (string, []byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if id == "" {
+		dk, err := e.activeKey(aad.Service)
+		if err != nil {
+			return "", nil, err
+		}
+		return dk.id, deriveStreamKey(dk.key, salt), nil
+	}
+	key, err := e.dataKey(aad.Service, id)
+	if err != nil {
+		return "", nil, err
+	}
+	return id, deriveStreamKey(key, salt), nil
+}
+
+func (a *AESCrypter) EncryptStream(w io.Writer, aad StateAAD) (io.WriteCloser, error) {
+	return encryptStream(a, w, aad)
+}
+
+func (a *AESCrypter) DecryptStream(r io.Reader, aad StateAAD) (io.Reader, error) {
+	return decryptStream(a, r, aad)
+}
+
+func (k *Keyring) EncryptStream(w io.Writer, aad StateAAD) (io.WriteCloser, error) {
+	return encryptStream(k, w, aad)
+}
+
+func (k *Keyring) DecryptStream(r io.Reader, aad StateAAD) (io.Reader, error) {
+	return decryptStream(k, r, aad)
+}
+
+func (e *EnvelopeCrypter) EncryptStream(w io.Writer, aad StateAAD) (io.WriteCloser, error) {
+	return encryptStream(e, w, aad)
+}
+
+func (e *EnvelopeCrypter) DecryptStream(r io.Reader, aad StateAAD) (io.Reader, error) {
+	return decryptStream(e, r, aad)
+}
+
+// streamCipher 一个流的块密钥和 nonce 状态
+type streamCipher struct {
+	aead    cipher.AEAD
+	nonce   [12]byte
+	counter uint32
+	ad      []byte
+}
+
+func newStreamCipher(derived, header []byte, aad StateAAD) (*streamCipher, error) {
+	defer Zero(derived)
+	block, err := aes.NewCipher(derived[:32])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	c := &streamCipher{aead: aead, ad: append(bytes.Clone(header), aad.Bytes()...)}
+	copy(c.nonce[:7], derived[32:])
+	return c, nil
+}
+
+// next 返回下一块的 nonce
+func (c *streamCipher) next(last bool) ([]byte, error) {
+	if c.counter == ^uint32(0) {
+		return nil, errors.New("state stream too long")
+	}
+	binary.BigEndian.PutUint32(c.nonce[7:11], c.counter)
+	c.nonce[11] = 0
+	if last {
+		c.nonce[11] = 1
+	}
+	c.counter++
+	return c.nonce[:], nil
+}
+
+func streamHeader(id string, salt []byte) []byte {
+	header := make([]byte, 0, len(streamMagic)+2+len(id)+len(salt))
+	header = append(header, streamMagic...)
+	header = append(header, streamVersion, byte(len(id)))
+	header = append(header, id...)
+	return append(header, salt...)
+}
+
+func encryptStream(src streamKeySource, w io.Writer, aad StateAAD) (io.WriteCloser, error) {
+	salt := make([]byte, streamSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	id, derived, err := src.deriveStreamKey("", salt, aad)
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > 255 {
+		Zero(derived)
+		return nil, errors.New("key id too long")
+	}
+	header := streamHeader(id, salt)
+	c, err := newStreamCipher(derived, header, aad)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &streamWriter{w: w, c: c, buf: make([]byte, 0, streamChunkSize)}, nil
+}
+
+// streamWriter 缓冲一块明文，下一次写入或 Close 时才加密写出，保证最后一块带有结束标记
+type streamWriter struct {
+	w   io.Writer
+	c   *streamCipher
+	buf []byte
+	out []byte
+	err error
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	n := 0
+	for len(p) > 0 {
+		if len(s.buf) == streamChunkSize {
+			if s.err = s.flush(false); s.err != nil {
+				return n, s.err
+			}
+		}
+		m := copy(s.buf[len(s.buf):cap(s.buf)], p)
+		s.buf = s.buf[:len(s.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+func (s *streamWriter) flush(last bool) error {
+	nonce, err := s.c.next(last)
+	if err != nil {
+		return err
+	}
+	s.out = s.c.aead.Seal(s.out[:0], nonce, s.buf, s.c.ad)
+	Zero(s.buf)
+	s.buf = s.buf[:0]
+	_, err = s.w.Write(s.out)
+	return err
+}
+
+// Close 写出最后一块，不关闭底层 Writer
+func (s *streamWriter) Close() error {
+	if s.err != nil {
+		return s.err
+	}
+	s.err = s.flush(true)
+	if s.err == nil {
+		s.err = errors.New("state stream closed")
+		return nil
+	}
+	return s.err
+}
+
+// StreamKeyID 返回流式密文头中的密钥 ID
+func StreamKeyID(header []byte) (string, bool) {
+	n := len(streamMagic)
+	if len(header) < n+2 || !bytes.Equal(header[:n], streamMagic) || header[n] != streamVersion {
+		return "", false
+	}
+	end := n + 2 + int(header[n+1])
+	if len(header) < end {
+		return "", false
+	}
+	return string(header[n+2 : end]), true
+}
+
+func decryptStream(src streamKeySource, r io.Reader, aad StateAAD) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	prefix := make([]byte, len(streamMagic)+2)
+	if _, err := io.ReadFull(br, prefix); err != nil {
+		return nil, fmt.Errorf("%w: state stream header: %v", ErrIntegrity, err)
+	}
+	n := len(streamMagic)
+	if !bytes.Equal(prefix[:n], streamMagic) || prefix[n] != streamVersion {
+		return nil, errors.New("not an encrypted state stream")
+	}
+	rest := make([]byte, int(prefix[n+1])+streamSaltSize)
+	if _, err := io.ReadFull(br, rest); err != nil {
+		return nil, fmt.Errorf("%w: state stream header: %v", ErrIntegrity, err)
+	}
+	id, salt := string(rest[:len(rest)-streamSaltSize]), rest[len(rest)-streamSaltSize:]
+	_, derived, err := src.deriveStreamKey(id, salt, aad)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newStreamCipher(derived, append(prefix, rest...), aad)
+	if err != nil {
+		return nil, err
+	}
+	return &streamReader{r: br, c: c, aad: aad, buf: make([]byte, streamChunkSize+c.aead.Overhead())}, nil
+}
+
+// streamReader 逐块解密，只有带结束标记的最后一块通过认证后才返回 io.EOF
+type streamReader struct {
+	r     *bufio.Reader
+	c     *streamCipher
+	aad   StateAAD
+	buf   []byte
+	plain []byte
+	done  bool
+	err   error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.readChunk()
+	}
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+func (s *streamReader) readChunk() error {
+	n, err := io.ReadFull(s.r, s.buf)
+	last := false
+	switch {
+	case err == io.EOF:
+		// 在块边界截断：缺少带结束标记的最后一块
+		return fmt.Errorf("%w: state stream truncated after chunk %d for %s", ErrIntegrity, s.c.counter, s.aad)
+	case err == io.ErrUnexpectedEOF:
+		last = true
+	case err != nil:
+		return err
+	default:
+		// 整块：后面没有数据时才是最后一块
+		if _, err := s.r.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return err
+		}
+	}
+	nonce, err := s.c.next(last)
+	if err != nil {
+		return err
+	}
+	plain, err := s.c.aead.Open(s.buf[:0], nonce, s.buf[:n], s.c.ad)
+	if err != nil {
+		return fmt.Errorf("%w: state stream chunk %d for %s is truncated or tampered", ErrIntegrity, s.c.counter-1, s.aad)
+	}
+	s.plain = plain
+	s.done = last
+	return nil
+}
+
+// streamState 只实现了流式接口的服务在信封路径中的状态桥接
+// 状态缓冲为原始字节，用于不支持流式保存的存储和需要迁移的状态
+type streamState struct {
+	svc Service
+}
+
+func (s streamState) exportState() (string, []byte, error) {
+	exporter, ok := s.svc.(StreamExporter)
+	if !ok {
+		return untypedState(s).exportState()
+	}
+	var buf bytes.Buffer
+	if err := exporter.ExportStateTo(&buf); err != nil {
+		return "", nil, err
+	}
+	return EncodingStream, buf.Bytes(), nil
+}
+
+func (s streamState) importState(encoding string, data []byte) error {
+	importer, ok := s.svc.(StreamImporter)
+	if !ok || encoding != EncodingStream {
+		return untypedState(s).importState(encoding, data)
+	}
+	return importer.ImportStateFrom(bytes.NewReader(data))
+}
+
+// countingWriter 统计写入的字节数
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// countingReader 统计读取的字节数
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+// persistStream 流式保存服务状态，返回明文大小
+// 加密器不支持流式加密时返回 ErrStreamUnsupported，由调用方回退到信封路径
+func persistStream(store StreamStateStore, svc Service, exporter StreamExporter) (int, error) {
+	var n int
+	err := store.SaveStream(svc.Name(), stateVersion(svc), func(w io.Writer) error {
+		cw := &countingWriter{w: w}
+		err := exporter.ExportStateTo(cw)
+		n = cw.n
+		return err
+	})
+	return n, err
+}
+
+// loadStream 从流式状态导入，版本与服务不一致时缓冲后走迁移链
+// 服务或存储不支持流式状态、或状态不是流式保存的返回 ErrNotStream
+// 返回读取的明文大小
+func (k *MicroKernel) loadStream(svc Service) (int, error) {
+	importer, ok := svc.(StreamImporter)
+	if !ok {
+		return 0, ErrNotStream
+	}
+	store, ok := k.stateStore.(StreamStateStore)
+	if !ok {
+		return 0, ErrNotStream
+	}
+	r, version, err := store.LoadStream(svc.Name())
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	if version == stateVersion(svc) {
+		cr := &countingReader{r: r}
+		if err := importer.ImportStateFrom(cr); err != nil {
+			return cr.n, err
+		}
+		// 服务可能在自己的结束标记处停止读取，读到流结尾才能确认最后一块存在
+		if _, err := io.Copy(io.Discard, cr); err != nil {
+			return cr.n, err
+		}
+		return cr.n, nil
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return len(data), err
+	}
+	return len(data), importEnvelope(svc, &StateEnvelope{Service: svc.Name(), Version: version, Encoding: EncodingStream, Data: data})
+}
+
+// streamPair 热替换时新旧服务都支持流式状态、版本相同且加密器支持流式加密时返回 true
+func streamPair(oldSvc, newSvc Service, crypter Crypter) (StreamExporter, StreamImporter, StreamCrypter, bool) {
+	exporter, ok1 := oldSvc.(StreamExporter)
+	importer, ok2 := newSvc.(StreamImporter)
+	sc, ok3 := crypter.(StreamCrypter)
+	if !ok1 || !ok2 || !ok3 || stateVersion(oldSvc) != stateVersion(newSvc) {
+		return nil, nil, nil, false
+	}
+	return exporter, importer, sc, true
+}
+
+// transferStream 通过 io.Pipe 把旧服务的状态加密后流式导入新服务，状态不会整体驻留内存
+func transferStream(exporter StreamExporter, importer StreamImporter, sc StreamCrypter, aad StateAAD) error {
+	pr, pw := io.Pipe()
+	go func() {
+		ew, err := sc.EncryptStream(pw, aad)
+		if err == nil {
+			err = exporter.ExportStateTo(ew)
+			if cerr := ew.Close(); err == nil {
+				err = cerr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+	r, err := sc.DecryptStream(pr, aad)
+	if err == nil {
+		err = importer.ImportStateFrom(r)
+	}
+	if err == nil {
+		// 确认流完整结束（最后一块通过认证）
+		_, err = io.Copy(io.Discard, r)
+	}
+	pr.CloseWithError(err)
+	return err
+}
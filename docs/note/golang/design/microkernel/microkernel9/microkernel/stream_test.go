@@ -0,0 +1,165 @@
+package microkernel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"testing"
+)
+
+// sealStream 流式加密 plaintext，返回文件头长度和密文
+func sealStream(t *testing.T, c StreamCrypter, plaintext []byte, aad StateAAD) (int, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := c.EncryptStream(&buf, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	id, ok := StreamKeyID(buf.Bytes())
+	if !ok {
+		t.Fatal("missing stream header")
+	}
+	return len(streamMagic) + 2 + len(id) + streamSaltSize, buf.Bytes()
+}
+
+func openStream(c StreamCrypter, ciphertext []byte, aad StateAAD) ([]byte, error) {
+	r, err := c.DecryptStream(bytes.NewReader(ciphertext), aad)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestStreamIntegrity(t *testing.T) {
+	c, err := NewAESCrypter(bytes.Repeat([]byte{9}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	aad := StateAAD{StoreID: "s1", Service: "big", Version: 1}
+	// 两个整块加一个不满的最后一块
+	plaintext := make([]byte, 2*streamChunkSize+100)
+	rand.Read(plaintext)
+	hdr, ct := sealStream(t, c, plaintext, aad)
+	chunk := streamChunkSize + 16
+	if want := hdr + 2*chunk + 100 + 16; len(ct) != want {
+		t.Fatalf("ciphertext is %d bytes, want %d", len(ct), want)
+	}
+
+	got, err := openStream(c, ct, aad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatal("round trip changed the plaintext")
+	}
+
+	swapped := bytes.Clone(ct)
+	copy(swapped[hdr:], ct[hdr+chunk:hdr+2*chunk])
+	copy(swapped[hdr+chunk:], ct[hdr:hdr+chunk])
+	flipped := bytes.Clone(ct)
+	flipped[hdr+chunk+10] ^= 1
+
+	tests := map[string][]byte{
+		// 在块边界截断，丢掉最后一块：前两块都能认证，必须在结尾发现缺少结束标记
+		"truncated at chunk boundary": ct[:hdr+2*chunk],
+		"truncated inside a chunk":    ct[:hdr+chunk+100],
+		"truncated inside last chunk": ct[:len(ct)-1],
+		"chunks reordered":            swapped,
+		"chunk tampered":              flipped,
+		"extra chunk appended":        append(bytes.Clone(ct), ct[hdr:hdr+chunk]...),
+	}
+	for name, bad := range tests {
+		if _, err := openStream(c, bad, aad); !errors.Is(err, ErrIntegrity) {
+			t.Errorf("%s: err = %v, want ErrIntegrity", name, err)
+		}
+	}
+	for field, other := range aadVariants(aad) {
+		if _, err := openStream(c, ct, other); !errors.Is(err, ErrIntegrity) {
+			t.Errorf("different %s: err = %v, want ErrIntegrity", field, err)
+		}
+	}
+}
+
+func TestStreamExactChunkMultiple(t *testing.T) {
+	c, err := NewAESCrypter(bytes.Repeat([]byte{9}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	aad := StateAAD{StoreID: "s1", Service: "big", Version: 1}
+	// 最后一块正好是整块，结束标记只能在读到流结尾时确定
+	plaintext := bytes.Repeat([]byte{1}, 2*streamChunkSize)
+	hdr, ct := sealStream(t, c, plaintext, aad)
+	got, err := openStream(c, ct, aad)
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("round trip: %v", err)
+	}
+	if _, err := openStream(c, ct[:hdr+streamChunkSize+16], aad); !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("dropped last chunk: err = %v, want ErrIntegrity", err)
+	}
+}
+
+// blob 流式状态的测试服务：导出定长数据和附加的索引，导入时只读定长数据，不读索引
+type blob struct {
+	data []byte
+}
+
+const blobSize = 100
+
+func (b *blob) Start() error           { return nil }
+func (b *blob) Stop() error            { return nil }
+func (b *blob) Name() string           { return "blob" }
+func (b *blob) Handle(Event) Reply     { return Reply{} }
+func (b *blob) Dependencies() []string { return nil }
+
+func (b *blob) ExportStateTo(w io.Writer) error {
+	if _, err := w.Write(b.data); err != nil {
+		return err
+	}
+	_, err := w.Write(make([]byte, 2*streamChunkSize))
+	return err
+}
+
+func (b *blob) ImportStateFrom(r io.Reader) error {
+	b.data = make([]byte, blobSize)
+	_, err := io.ReadFull(r, b.data)
+	return err
+}
+
+func TestLoadStreamTruncatedAtChunkBoundary(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewAESCrypter(bytes.Repeat([]byte{9}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	store := NewFileStateStore(dir, c)
+	src := &blob{data: bytes.Repeat([]byte{1}, blobSize)}
+	if _, err := persistStream(store, src, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewMicroKernel(store).Register(&blob{}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	// 在块边界截断，丢掉最后一块；服务读完自己的数据就停止，读不到截断的位置
+	info, err := store.Stat("blob", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(info.Path, info.Size-blobSize-16); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewMicroKernel(store).Register(&blob{}); !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("Register: err = %v, want ErrIntegrity", err)
+	}
+}
@@ -0,0 +1,186 @@
+package microkernel
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Codec 状态编解码器，编码名称记录在 StateEnvelope.Encoding 中
+type Codec interface {
+	Name() string
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                       { return EncodingJSON }
+func (jsonCodec) Marshal(v any) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v any) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// 内置编解码器
+var (
+	JSONCodec Codec = jsonCodec{}
+	GobCodec  Codec = gobCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		JSONCodec.Name(): JSONCodec,
+		GobCodec.Name():  GobCodec,
+	}
+)
+
+// RegisterCodec 注册自定义编解码器，导入状态时按信封中的编码名称查找
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+func codecByName(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported state encoding %q", name)
+	}
+	return c, nil
+}
+
+// StatefulService 类型安全的状态导入导出，Exportable/Importable 的泛型版本
+// 内核使用选定的编解码器序列化状态，导入时直接得到具体类型 T
+type StatefulService[T any] interface {
+	ExportTypedState() T
+	ImportTypedState(state T) error
+}
+
+// StateAdapter 内核访问服务状态的非泛型桥接，由 NewTypedState 创建
+type StateAdapter interface {
+	exportState() (encoding string, data []byte, err error)
+	importState(encoding string, data []byte) error
+}
+
+// TypedStateProvider 实现了 StatefulService 的服务同时实现该接口，
+// 内核通过它在 Register、定时持久化和热替换中处理类型化状态
+//
+//	func (e *EchoServiceV2) TypedState() microkernel.StateAdapter {
+//		return microkernel.NewTypedState[echoState](e, microkernel.JSONCodec)
+//	}
+type TypedStateProvider interface {
+	TypedState() StateAdapter
+}
+
+// NewTypedState 把 StatefulService 包装为 StateAdapter
+// 导出时使用 codec 编码；导入时按信封中记录的编码解码
+func NewTypedState[T any](svc StatefulService[T], codec Codec) StateAdapter {
+	return &typedState[T]{svc: svc, codec: codec}
+}
+
+type typedState[T any] struct {
+	svc   StatefulService[T]
+	codec Codec
+}
+
+func (t *typedState[T]) exportState() (string, []byte, error) {
+	data, err := t.codec.Marshal(t.svc.ExportTypedState())
+	return t.codec.Name(), data, err
+}
+
+func (t *typedState[T]) importState(encoding string, data []byte) error {
+	codec, err := codecByName(encoding)
+	if err != nil {
+		return err
+	}
+	var state T
+	if err := codec.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("state decode failed: %w", err)
+	}
+	return t.svc.ImportTypedState(state)
+}
+
+// untypedState 兼容只实现了 Exportable/Importable 的旧服务，状态以 JSON 编码
+type untypedState struct {
+	svc Service
+}
+
+func (u untypedState) exportState() (string, []byte, error) {
+	exporter, ok := u.svc.(Exportable)
+	if !ok {
+		return "", nil, fmt.Errorf("service %s is not exportable", u.svc.Name())
+	}
+	data, err := json.Marshal(exporter.ExportState())
+	return EncodingJSON, data, err
+}
+
+func (u untypedState) importState(encoding string, data []byte) error {
+	importer, ok := u.svc.(Importable)
+	if !ok {
+		return fmt.Errorf("service %s is not importable", u.svc.Name())
+	}
+	if encoding != EncodingJSON {
+		return fmt.Errorf("unsupported state encoding %q", encoding)
+	}
+	var state any
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("state decode failed: %w", err)
+	}
+	return importer.ImportState(state)
+}
+
+// stateAdapterFor 返回服务的状态桥接
+func stateAdapterFor(svc Service) StateAdapter {
+	if p, ok := svc.(TypedStateProvider); ok {
+		return p.TypedState()
+	}
+	_, exporter := svc.(StreamExporter)
+	_, importer := svc.(StreamImporter)
+	if exporter || importer {
+		return streamState{svc: svc}
+	}
+	return untypedState{svc: svc}
+}
+
+// canExport 服务是否支持状态导出
+func canExport(svc Service) bool {
+	if _, ok := svc.(TypedStateProvider); ok {
+		return true
+	}
+	if _, ok := svc.(StreamExporter); ok {
+		return true
+	}
+	_, ok := svc.(Exportable)
+	return ok
+}
+
+// canImport 服务是否支持状态导入
+func canImport(svc Service) bool {
+	if _, ok := svc.(TypedStateProvider); ok {
+		return true
+	}
+	if _, ok := svc.(StreamImporter); ok {
+		return true
+	}
+	_, ok := svc.(Importable)
+	return ok
+}
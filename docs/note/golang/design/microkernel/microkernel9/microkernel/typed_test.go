@@ -0,0 +1,106 @@
+package microkernel
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type profile struct {
+	Name  string
+	Tags  []string
+	Count int
+}
+
+// profileService 使用类型化状态的测试服务
+type profileService struct {
+	state profile
+	codec Codec
+}
+
+func (p *profileService) Start() error           { return nil }
+func (p *profileService) Stop() error            { return nil }
+func (p *profileService) Name() string           { return "profile" }
+func (p *profileService) Handle(Event) Reply     { return Reply{} }
+func (p *profileService) Dependencies() []string { return nil }
+
+func (p *profileService) ExportTypedState() profile { return p.state }
+
+func (p *profileService) ImportTypedState(state profile) error {
+	p.state = state
+	return nil
+}
+
+func (p *profileService) TypedState() StateAdapter {
+	return NewTypedState[profile](p, p.codec)
+}
+
+func TestTypedStateCodecs(t *testing.T) {
+	want := profile{Name: "a", Tags: []string{"x", "y"}, Count: 3}
+	for _, codec := range []Codec{JSONCodec, GobCodec} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			store := NewMemoryStateStore()
+			k := NewMicroKernel(store)
+			src := &profileService{state: want, codec: codec}
+			if _, err := k.persist(src); err != nil {
+				t.Fatal(err)
+			}
+			env, err := DecodeEnvelope("profile", mustLoadRaw(t, store, "profile"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env.Encoding != codec.Name() {
+				t.Fatalf("encoding = %s, want %s", env.Encoding, codec.Name())
+			}
+
+			// 导入时按信封中的编码解码，与服务自己使用的编解码器无关
+			for _, other := range []Codec{JSONCodec, GobCodec} {
+				dst := &profileService{codec: other}
+				if err := NewMicroKernel(store).Register(dst); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(dst.state, want) {
+					t.Fatalf("state = %+v, want %+v", dst.state, want)
+				}
+			}
+		})
+	}
+}
+
+func mustLoadRaw(t *testing.T, s StateStore, name string) any {
+	t.Helper()
+	raw, err := s.Load(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func TestTypedStateCodecMismatch(t *testing.T) {
+	gobData, err := GobCodec.Marshal(profile{Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		svc  Service
+		env  StateEnvelope
+		want string
+	}{
+		{"unknown encoding", &profileService{codec: JSONCodec}, StateEnvelope{Encoding: "yaml", Data: []byte("name: a")}, `unsupported state encoding "yaml"`},
+		{"gob data labelled json", &profileService{codec: JSONCodec}, StateEnvelope{Encoding: EncodingJSON, Data: gobData}, "state decode failed"},
+		{"json data labelled gob", &profileService{codec: GobCodec}, StateEnvelope{Encoding: "gob", Data: []byte(`{"Name":"a"}`)}, "state decode failed"},
+		// 只实现 Exportable/Importable 的服务只能导入 JSON
+		{"gob into untyped service", &counter{name: "profile", version: 1}, StateEnvelope{Encoding: "gob", Data: gobData}, `unsupported state encoding "gob"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := tt.env
+			env.Service, env.Version = "profile", 1
+			err := importEnvelope(tt.svc, &env)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("err = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
@@ -2,14 +2,29 @@ package service
 
 import (
 	"fmt"
+	"microkernel/logger"
 	"microkernel/microkernel"
+	"sync/atomic"
 )
 
 type EchoService struct {
-	name      string
-	echoCount int
+	name string
+	// Handle 在分发协程中修改，持久化协程读取
+	echoCount atomic.Int64
 	kernel    *microkernel.MicroKernel
 	stopCh    chan struct{}
+	log       *logger.Logger
+}
+
+func init() {
+	microkernel.RegisterServiceType(microkernel.ServiceType{
+		Name:        "echo",
+		Description: "回显服务，回复收到的事件内容",
+		Version:     "1.0.0",
+		New: func(k *microkernel.MicroKernel, _ microkernel.Params) (microkernel.Service, error) {
+			return NewEchoService(k), nil
+		},
+	})
 }
 
 func (e *EchoService) Dependencies() []string {
@@ -21,17 +36,25 @@ func NewEchoService(kernel *microkernel.MicroKernel) *EchoService {
 		name:   "echo",
 		kernel: kernel,
 		stopCh: make(chan struct{}),
+		log:    serviceLogger(kernel, "echo"),
 	}
 }
 
+// SetLogger 注册时由内核注入派生的日志器
+func (e *EchoService) SetLogger(l *logger.Logger) {
+	e.log = l
+}
+
 func (e *EchoService) Start() error {
-	fmt.Printf("[%s] starting...\n", e.name)
-	go e.run()
+	e.log.Info("starting")
+	// 每次启动使用新的停止通道，停止后可以再次启动
+	e.stopCh = make(chan struct{})
+	go e.run(e.stopCh)
 	return nil
 }
 
 func (e *EchoService) Stop() error {
-	fmt.Printf("[%s] stopping...\n", e.name)
+	e.log.Info("stopping")
 	close(e.stopCh)
 	return nil
 }
@@ -41,19 +64,19 @@ func (e *EchoService) Name() string {
 }
 
 func (e *EchoService) Handle(evt microkernel.Event) microkernel.Reply {
-	e.echoCount++
+	e.echoCount.Add(1)
 	return microkernel.Reply{Code: 0, Message: "echo service handled", Data: fmt.Sprintf("from %s: %s", evt.From, evt.Content)}
 }
 
-func (e *EchoService) run() {
+func (e *EchoService) run(stop <-chan struct{}) {
 	for {
 		select {
-		case <-e.stopCh:
+		case <-stop:
 			return
 		}
 	}
 }
 
 func (e *EchoService) ExportState() any {
-	return e.echoCount
+	return int(e.echoCount.Load())
 }
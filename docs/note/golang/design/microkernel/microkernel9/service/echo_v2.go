@@ -1,21 +1,36 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"microkernel/logger"
 	"microkernel/microkernel"
-	"os"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 type EchoServiceV2 struct {
 	name string
-	// 用来测试状态迁移
-	echoCount int
+	// 用来测试状态迁移；Handle 在分发协程中修改，持久化协程读取
+	echoCount atomic.Int64
 	kernel    *microkernel.MicroKernel
 	stopCh    chan struct{}
 	log       *logger.Logger
 }
 
+func init() {
+	microkernel.RegisterServiceType(microkernel.ServiceType{
+		Name:        "echo-v2",
+		Description: "回显服务 V2，支持流式回复和带版本的状态迁移",
+		Version:     "2.0.0",
+		New: func(k *microkernel.MicroKernel, _ microkernel.Params) (microkernel.Service, error) {
+			return NewEchoServiceV2(k), nil
+		},
+	})
+}
+
 func (e *EchoServiceV2) Dependencies() []string {
 	return nil
 }
@@ -25,19 +40,25 @@ func NewEchoServiceV2(kernel *microkernel.MicroKernel) *EchoServiceV2 {
 		name:   "echo",
 		kernel: kernel,
 		stopCh: make(chan struct{}),
-		log:    logger.NewLogger("echo", logger.INFO, os.Stdout),
+		log:    serviceLogger(kernel, "echo"),
 	}
 }
 
+// SetLogger 注册或热替换时由内核注入派生的日志器
+func (e *EchoServiceV2) SetLogger(l *logger.Logger) {
+	e.log = l.With("version", 2)
+}
+
 func (e *EchoServiceV2) Start() error {
-	//fmt.Printf("[%sv2] starting...\n", e.name)
-	e.log.Infof("[%sv2] starting...\n", e.name)
-	go e.run()
+	e.log.Info("starting")
+	// 每次启动使用新的停止通道，停止后可以再次启动
+	e.stopCh = make(chan struct{})
+	go e.run(e.stopCh)
 	return nil
 }
 
 func (e *EchoServiceV2) Stop() error {
-	fmt.Printf("[%sv2] stopping...\n", e.name)
+	e.log.Info("stopping")
 	close(e.stopCh)
 	return nil
 }
@@ -47,28 +68,75 @@ func (e *EchoServiceV2) Name() string {
 }
 
 func (e *EchoServiceV2) Handle(evt microkernel.Event) microkernel.Reply {
-	e.echoCount += 8
-	fmt.Printf("[echo] count is %d\n", e.echoCount)
+	count := e.echoCount.Add(8)
+	// 状态变化后通知内核，由内核按持久化策略保存；子进程中没有内核
+	if e.kernel != nil {
+		e.kernel.MarkDirty(e.name)
+	}
+	e.log.Debug("event handled", "count", count)
 	return microkernel.Reply{Code: 0, Message: "echo v2 service handled", Data: fmt.Sprintf("from %s: %s", evt.From, evt.Content)}
 }
 
-func (e *EchoServiceV2) run() {
+// HandleStream 按单词逐条回显
+func (e *EchoServiceV2) HandleStream(ctx context.Context, evt microkernel.Event, out chan<- microkernel.Reply) {
+	for i, word := range strings.Fields(evt.Content) {
+		select {
+		case out <- microkernel.Reply{Code: 0, Message: fmt.Sprintf("word %d", i), Data: word}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (e *EchoServiceV2) run(stop <-chan struct{}) {
 	for {
 		select {
-		case <-e.stopCh:
+		case <-stop:
 			return
 		}
 	}
 }
 
-func (e *EchoServiceV2) ImportState(state any) error {
-	if val, ok := state.(int); ok {
-		e.echoCount = val
-		return nil
+// echoStateV2 状态 schema 第 2 版
+// 第 1 版（EchoService）的状态只是一个计数
+type echoStateV2 struct {
+	Count int `json:"count"`
+}
+
+func (e *EchoServiceV2) StateVersion() int {
+	return 2
+}
+
+func (e *EchoServiceV2) Migrations() map[int]microkernel.MigrateFunc {
+	return map[int]microkernel.MigrateFunc{
+		1: migrateEchoV1,
 	}
-	if val, ok := state.(float64); ok {
-		e.echoCount = int(val)
-		return nil
+}
+
+// migrateEchoV1 第 1 版的计数迁移为第 2 版的结构
+func migrateEchoV1(data []byte) ([]byte, error) {
+	var count int
+	if err := json.Unmarshal(data, &count); err != nil {
+		return nil, err
 	}
-	return fmt.Errorf("invalid state type")
+	return json.Marshal(echoStateV2{Count: count})
+}
+
+// PersistPolicy 计数变化后防抖保存，没有变化时不重复写盘
+func (e *EchoServiceV2) PersistPolicy() microkernel.PersistPolicy {
+	return microkernel.PersistPolicy{Mode: microkernel.PersistOnChange, Debounce: 500 * time.Millisecond}
+}
+
+// TypedState 使用类型安全的状态接口，导入时直接得到 echoStateV2
+func (e *EchoServiceV2) TypedState() microkernel.StateAdapter {
+	return microkernel.NewTypedState[echoStateV2](e, microkernel.JSONCodec)
+}
+
+func (e *EchoServiceV2) ExportTypedState() echoStateV2 {
+	return echoStateV2{Count: int(e.echoCount.Load())}
+}
+
+func (e *EchoServiceV2) ImportTypedState(state echoStateV2) error {
+	e.echoCount.Store(int64(state.Count))
+	return nil
 }
@@ -1,38 +1,179 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"microkernel/logger"
+	"microkernel/logstore"
+	"microkernel/metrics"
 	"microkernel/microkernel"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// 日志服务处理的事件类型，内容均为 JSON
+const (
+	// EventLogRecord 写入一条日志，内容为 logstore.Entry，service 为空时使用事件来源
+	EventLogRecord = "log.record"
+	// EventLogQuery 查询日志，内容为 LogQuery，回复数据为 logstore.Entry 数组
+	EventLogQuery = "log.query"
+	// EventLogFollow 流式跟踪日志，内容为 LogQuery，每条回复的数据为一条 logstore.Entry
+	EventLogFollow = "log.follow"
+	// EventLogStats 存储的统计信息，回复数据为 logstore.Stats
+	EventLogStats = "log.stats"
+)
+
+// LogQuery 查询和跟踪的请求，省略 level 时返回全部级别
+type LogQuery struct {
+	logstore.Query
+	// 跟踪时先返回的已有日志条数
+	Tail int `json:"tail,omitempty"`
+}
+
+// pruneInterval 检查保留策略的间隔
+const pruneInterval = time.Minute
+
 // LogService 日志服务
+//
+// 收集其他服务经内核发送的日志，写入分段存储，支持按服务、级别、时间范围和文本查询以及跟踪新日志。
+// collect 开启时把内核日志管道转发给自己，内核和所有服务的日志都会被收集。
 type LogService struct {
 	name   string
 	kernel *microkernel.MicroKernel
 	logCh  chan string
 	stopCh chan struct{}
+	log    *logger.Logger
+	// 存储目录和选项，store 在 Start 时打开
+	dir       string
+	storeOpts logstore.Options
+	store     *logstore.Store
+	// 收集内核日志管道的最低级别，collect 为 false 时不收集
+	collect      bool
+	collectLevel logger.LogLevel
+	// 按级别统计写入的日志，RegisterMetrics 时创建
+	entries *metrics.CounterVec
+	// mu 保护 store（指标读取时）和心跳间隔；心跳间隔可以通过 Reconfigure 在运行中修改
+	mu        sync.Mutex
+	heartbeat time.Duration
+	// 通知 run 心跳间隔已变化
+	reconfigured chan struct{}
+}
+
+func init() {
+	microkernel.RegisterServiceType(microkernel.ServiceType{
+		Name:        "log",
+		Description: "日志聚合服务，收集各服务的日志写入分段存储，支持查询、跟踪和保留策略",
+		Version:     "2.0.0",
+		Params: []microkernel.ParamSpec{
+			{Name: "buffer", Kind: microkernel.ParamInt, Default: 100, Description: "日志队列长度"},
+			{Name: "heartbeat", Kind: microkernel.ParamDuration, Default: "2s", Description: "心跳日志的间隔"},
+			{Name: "dir", Kind: microkernel.ParamString, Default: "./logs", Description: "日志存储目录"},
+			{Name: "segment_size", Kind: microkernel.ParamInt, Default: logstore.DefaultSegmentBytes, Description: "段文件大小上限（字节）"},
+			{Name: "segment_age", Kind: microkernel.ParamDuration, Description: "段文件的最长写入时间"},
+			{Name: "max_age", Kind: microkernel.ParamDuration, Description: "日志的保留时间"},
+			{Name: "max_bytes", Kind: microkernel.ParamInt, Description: "存储的总大小上限（字节）"},
+			{Name: "collect", Kind: microkernel.ParamBool, Default: true, Description: "收集内核日志管道中的日志"},
+			{Name: "collect_level", Kind: microkernel.ParamString, Default: "INFO", Description: "收集的最低级别"},
+		},
+		New: func(k *microkernel.MicroKernel, p microkernel.Params) (microkernel.Service, error) {
+			if p.Int("buffer") < 0 {
+				return nil, fmt.Errorf("buffer %d must not be negative", p.Int("buffer"))
+			}
+			if p.Duration("heartbeat") <= 0 {
+				return nil, fmt.Errorf("heartbeat %s must be positive", p.Duration("heartbeat"))
+			}
+			if p.String("dir") == "" {
+				return nil, fmt.Errorf("dir must not be empty")
+			}
+			if p.Int("segment_size") <= 0 || p.Int("max_bytes") < 0 {
+				return nil, fmt.Errorf("segment_size must be positive and max_bytes must not be negative")
+			}
+			if p.Duration("segment_age") < 0 || p.Duration("max_age") < 0 {
+				return nil, fmt.Errorf("segment_age and max_age must not be negative")
+			}
+			level, err := logger.ParseLevel(p.String("collect_level"))
+			if err != nil {
+				return nil, err
+			}
+			l := NewLogService(k)
+			l.logCh = make(chan string, p.Int("buffer"))
+			l.heartbeat = p.Duration("heartbeat")
+			l.dir = p.String("dir")
+			l.storeOpts = storeOptions(p)
+			l.collect, l.collectLevel = p.Bool("collect"), level
+			return l, nil
+		},
+	})
 }
 
 func NewLogService(kernel *microkernel.MicroKernel) *LogService {
 	return &LogService{
-		name:   "logger",
-		kernel: kernel,
-		logCh:  make(chan string, 100),
-		stopCh: make(chan struct{}),
+		name:      "logger",
+		kernel:    kernel,
+		logCh:     make(chan string, 100),
+		stopCh:    make(chan struct{}),
+		log:       serviceLogger(kernel, "logger"),
+		heartbeat: 2 * time.Second,
+		dir:       "./logs",
+
+		reconfigured: make(chan struct{}, 1),
+	}
+}
+
+func storeOptions(p microkernel.Params) logstore.Options {
+	return logstore.Options{
+		SegmentBytes: int64(p.Int("segment_size")),
+		SegmentAge:   p.Duration("segment_age"),
+		MaxAge:       p.Duration("max_age"),
+		MaxBytes:     int64(p.Int("max_bytes")),
 	}
 }
 
+// SetLogger 注册时由内核注入派生的日志器
+func (l *LogService) SetLogger(lg *logger.Logger) {
+	l.log = lg
+}
+
 func (l *LogService) Start() error {
-	fmt.Printf("[%s] starting...\n", l.name)
-	go l.run()
+	l.log.Info("starting", "dir", l.dir)
+	store, err := logstore.Open(l.dir, l.storeOpts)
+	if err != nil {
+		return fmt.Errorf("open log store: %w", err)
+	}
+	l.mu.Lock()
+	l.store = store
+	l.mu.Unlock()
+	// 子进程中没有内核，无法收集内核日志
+	if l.collect && l.kernel == nil {
+		l.log.Warn("kernel logs not collected", "err", "no kernel")
+		l.collect = false
+	}
+	if l.collect {
+		sink := NewForwardSink(l.kernel, l.name)
+		if err := l.kernel.AddLogSink(l.name, sink, l.collectLevel); err != nil {
+			l.log.Warn("kernel logs not collected", "err", err)
+			l.collect = false
+		}
+	}
+	// 每次启动使用新的停止通道，停止后可以再次启动
+	l.stopCh = make(chan struct{})
+	go l.run(l.stopCh)
 	return nil
 }
 
 func (l *LogService) Stop() error {
-	fmt.Printf("[%s] stopping...\n", l.name)
+	l.log.Info("stopping")
 	close(l.stopCh)
-	return nil
+	if l.collect {
+		l.kernel.RemoveLogSink(l.name)
+	}
+	if l.store == nil {
+		return nil
+	}
+	return l.store.Close()
 }
 
 func (l *LogService) Name() string {
@@ -43,8 +184,32 @@ func (l *LogService) Dependencies() []string {
 	return []string{"echo"}
 }
 
+// EventTypes 日志服务的事件类型，其他类型的事件作为一条 INFO 日志存储
+func (l *LogService) EventTypes() []string {
+	return []string{EventLogRecord, EventLogQuery, EventLogFollow, EventLogStats}
+}
+
 func (l *LogService) Handle(evt microkernel.Event) microkernel.Reply {
-	fmt.Printf("[%s] LOG handle kernel event: %s\n", l.name, evt.Content)
+	// 日志相关的事件不再输出日志，否则收集内核日志时会循环
+	switch evt.Type {
+	case EventLogRecord:
+		return l.handleRecord(evt)
+	case EventLogQuery:
+		return l.handleQuery(evt)
+	case EventLogStats:
+		data, _ := json.Marshal(l.store.Stats())
+		return microkernel.Reply{Code: 0, Message: "OK", Data: string(data)}
+	}
+	l.log.Info("handle kernel event", "from", evt.From, "type", evt.Type, "content", evt.Content)
+	// 其他事件作为一条 INFO 日志存储
+	if _, err := l.append(logstore.Entry{
+		Level:   logger.INFO,
+		Service: evt.From,
+		Msg:     evt.Content,
+		Fields:  map[string]any{"type": evt.Type},
+	}); err != nil {
+		return microkernel.Reply{Code: 500, Message: err.Error()}
+	}
 	// return chan kernel.Reply
 	//msg := make(chan kernel.Reply, 1)
 	//msg <- kernel.Reply{Code: 0, Message: "Logged", Data: evt.Content}
@@ -61,19 +226,143 @@ func (l *LogService) Handle(evt microkernel.Event) microkernel.Reply {
 	return microkernel.Reply{Code: 0, Message: "Logged", Data: evt.Content}
 }
 
-func (l *LogService) run() {
+// append 写入存储并计数
+func (l *LogService) append(e logstore.Entry) (uint64, error) {
+	seq, err := l.store.Append(e)
+	if err == nil && l.entries != nil {
+		l.entries.With(e.Level.String()).Inc()
+	}
+	return seq, err
+}
+
+// RegisterMetrics 注册日志写入计数和存储大小
+func (l *LogService) RegisterMetrics(reg *metrics.Registry) error {
+	entries, err := reg.CounterVec("log_entries_total", "Log entries written to the store.", "level")
+	if err != nil {
+		return err
+	}
+	l.entries = entries
+	stat := func(fn func(logstore.Stats) float64) func() float64 {
+		return func() float64 {
+			l.mu.Lock()
+			store := l.store
+			l.mu.Unlock()
+			if store == nil {
+				return 0
+			}
+			return fn(store.Stats())
+		}
+	}
+	return errors.Join(
+		reg.GaugeFunc("log_store_bytes", "Size of the log store.",
+			stat(func(st logstore.Stats) float64 { return float64(st.Bytes) })),
+		reg.GaugeFunc("log_store_segments", "Segments in the log store.",
+			stat(func(st logstore.Stats) float64 { return float64(st.Segments) })),
+	)
+}
+
+func (l *LogService) handleRecord(evt microkernel.Event) microkernel.Reply {
+	var e logstore.Entry
+	if err := json.Unmarshal([]byte(evt.Content), &e); err != nil {
+		return microkernel.Reply{Code: 400, Message: fmt.Sprintf("invalid log record: %v", err)}
+	}
+	if e.Service == "" {
+		e.Service = evt.From
+	}
+	seq, err := l.append(e)
+	if err != nil {
+		return microkernel.Reply{Code: 500, Message: err.Error()}
+	}
+	return microkernel.Reply{Code: 0, Message: "Logged", Data: fmt.Sprint(seq)}
+}
+
+func (l *LogService) handleQuery(evt microkernel.Event) microkernel.Reply {
+	q, err := parseLogQuery(evt.Content)
+	if err != nil {
+		return microkernel.Reply{Code: 400, Message: err.Error()}
+	}
+	entries, err := l.store.Query(q.Query)
+	if err != nil {
+		return microkernel.Reply{Code: 500, Message: err.Error()}
+	}
+	if entries == nil {
+		entries = []logstore.Entry{}
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return microkernel.Reply{Code: 500, Message: err.Error()}
+	}
+	return microkernel.Reply{Code: 0, Message: fmt.Sprintf("%d entries", len(entries)), Data: string(data)}
+}
+
+// HandleStream 跟踪日志，直到调用方取消
+func (l *LogService) HandleStream(ctx context.Context, evt microkernel.Event, out chan<- microkernel.Reply) {
+	if evt.Type != EventLogFollow {
+		out <- l.Handle(evt)
+		return
+	}
+	q, err := parseLogQuery(evt.Content)
+	if err != nil {
+		out <- microkernel.Reply{Code: 400, Message: err.Error()}
+		return
+	}
+	entries, err := l.store.Follow(ctx, q.Query, q.Tail)
+	if err != nil {
+		out <- microkernel.Reply{Code: 500, Message: err.Error()}
+		return
+	}
+	for e := range entries {
+		data, _ := json.Marshal(e)
+		select {
+		case out <- microkernel.Reply{Code: 0, Message: "OK", Data: string(data)}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// parseLogQuery 解析查询请求，内容为空时查询全部日志
+func parseLogQuery(content string) (LogQuery, error) {
+	q := LogQuery{Query: logstore.Query{Level: logger.TRACE}}
+	if content == "" {
+		return q, nil
+	}
+	if err := json.Unmarshal([]byte(content), &q); err != nil {
+		return q, fmt.Errorf("invalid log query: %v", err)
+	}
+	return q, nil
+}
+
+// Query 查询存储的日志
+func (l *LogService) Query(q logstore.Query) ([]logstore.Entry, error) {
+	return l.store.Query(q)
+}
+
+// Follow 跟踪存储的日志，先返回已有的最新 tail 条
+func (l *LogService) Follow(ctx context.Context, q logstore.Query, tail int) (<-chan logstore.Entry, error) {
+	return l.store.Follow(ctx, q, tail)
+}
+
+func (l *LogService) run(stop <-chan struct{}) {
 	var count = 1
-	ticker := time.NewTicker(2 * time.Second)
+	ticker := time.NewTicker(l.heartbeatInterval())
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
 	for {
 		count++
 		select {
-		case <-l.stopCh:
-			fmt.Println("[log] stopping")
+		case <-stop:
 			return
+		case <-prune.C:
+			if err := l.store.Prune(); err != nil {
+				l.log.Warn("prune log store failed", "err", err)
+			}
+		case <-l.reconfigured:
+			ticker.Reset(l.heartbeatInterval())
 		case <-ticker.C:
-			fmt.Printf("[log]: heartbeat  %d\n", count)
+			l.log.Debug("heartbeat", "count", count)
 		case log := <-l.logCh:
-			fmt.Printf("[%s] LOG: %s\n", l.name, log)
+			l.log.Info(log)
 			// 模拟发送事件到内核
 			replyCh := make(chan microkernel.Reply, 1)
 			if count%2 == 0 {
@@ -96,7 +385,7 @@ func (l *LogService) run() {
 			}
 			// 等待内核回应
 			reply := <-replyCh
-			fmt.Printf("[%s] got reply from kernel: %s\n", l.Name(), reply.Message)
+			l.log.Info("got reply from kernel", "code", reply.Code, "message", reply.Message)
 		}
 	}
 }
@@ -104,3 +393,76 @@ func (l *LogService) run() {
 func (l *LogService) Log(msg string) {
 	l.logCh <- msg
 }
+
+func (l *LogService) heartbeatInterval() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.heartbeat
+}
+
+// Reconfigure 运行中修改心跳间隔；日志队列长度和存储参数不能在运行中修改，由内核热替换
+func (l *LogService) Reconfigure(p microkernel.Params) error {
+	level, err := logger.ParseLevel(p.String("collect_level"))
+	if err != nil {
+		return err
+	}
+	if p.Int("buffer") != cap(l.logCh) || p.String("dir") != l.dir || storeOptions(p) != l.storeOpts ||
+		p.Bool("collect") != l.collect || level != l.collectLevel {
+		return microkernel.ErrReconfigureUnsupported
+	}
+	if p.Duration("heartbeat") <= 0 {
+		return fmt.Errorf("heartbeat %s must be positive", p.Duration("heartbeat"))
+	}
+	l.mu.Lock()
+	l.heartbeat = p.Duration("heartbeat")
+	l.mu.Unlock()
+	select {
+	case l.reconfigured <- struct{}{}:
+	default:
+	}
+	l.log.Info("heartbeat reconfigured", "interval", p.Duration("heartbeat"))
+	return nil
+}
+
+// ForwardSink 把日志记录作为 log.record 事件经内核发送给日志服务
+//
+// 事件队列已满时丢弃记录，不阻塞写日志的调用方。
+// 日志服务自身的日志和处理 log.record 事件时内核输出的日志不转发，避免循环。
+type ForwardSink struct {
+	kernel  *microkernel.MicroKernel
+	to      string
+	dropped atomic.Int64
+}
+
+// NewForwardSink 创建转发给服务 to 的输出
+func NewForwardSink(k *microkernel.MicroKernel, to string) *ForwardSink {
+	return &ForwardSink{kernel: k, to: to}
+}
+
+func (s *ForwardSink) WriteRecord(r logger.Record) error {
+	if r.Service == s.to {
+		return nil
+	}
+	for _, f := range r.Fields {
+		if f.Key == "type" && f.Value == EventLogRecord {
+			return nil
+		}
+	}
+	data, err := json.Marshal(logstore.FromRecord(r))
+	if err != nil {
+		return err
+	}
+	if !s.kernel.TryPush(microkernel.Event{From: r.Service, To: s.to, Type: EventLogRecord, Content: string(data)}) {
+		s.dropped.Add(1)
+	}
+	return nil
+}
+
+// Dropped 因事件队列已满丢弃的记录数
+func (s *ForwardSink) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+func (s *ForwardSink) Close() error {
+	return nil
+}
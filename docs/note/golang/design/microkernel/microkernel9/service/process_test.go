@@ -0,0 +1,59 @@
+package service
+
+import (
+	"encoding/json"
+	"microkernel/microkernel"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestEchoHelperProcess 以子进程服务运行 EchoServiceV2，由 TestEchoProcess 通过描述文件启动
+func TestEchoHelperProcess(t *testing.T) {
+	if os.Getenv("MK_ECHO_HELPER") == "" {
+		t.Skip("helper process")
+	}
+	if err := microkernel.ServeProcess(NewEchoServiceV2(nil)); err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestEchoProcess(t *testing.T) {
+	t.Setenv("MK_ECHO_HELPER", "1")
+	dir := t.TempDir()
+	data, err := json.Marshal(microkernel.PluginManifest{
+		Name:         "echo",
+		Exec:         os.Args[0],
+		Args:         []string{"-test.run=^TestEchoHelperProcess$"},
+		StateVersion: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "echo.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	k := microkernel.NewMicroKernel(microkernel.NewMemoryStateStore())
+	if err := microkernel.NewPluginLoader(k, dir, nil).LoadAll(); err != nil {
+		t.Fatal(err)
+	}
+	// 子进程的日志写到 stdout 时，协议应答无法解码，启动失败
+	if err := k.StartAll(); err != nil {
+		t.Fatal(err)
+	}
+	defer k.StopAll()
+
+	svc, ok := k.Service("echo")
+	if !ok {
+		t.Fatal("echo not registered")
+	}
+	// 子进程中的服务没有内核，Handle 不能依赖内核
+	for i := 0; i < 2; i++ {
+		reply := svc.Handle(microkernel.Event{From: "test", To: "echo", Content: "hi"})
+		if reply.Code != 0 || reply.Data != "from test: hi" {
+			t.Fatalf("reply = %+v", reply)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package service
+
+import (
+	"microkernel/logger"
+	"microkernel/microkernel"
+	"os"
+)
+
+// serviceLogger 服务注册前使用的日志器，注册时由内核通过 SetLogger 替换为派生的日志器
+func serviceLogger(k *microkernel.MicroKernel, name string) *logger.Logger {
+	if k != nil {
+		return k.ServiceLogger(name)
+	}
+	// 子进程服务没有内核，stdout 被 ServeProcess 的协议占用
+	return logger.NewLogger(name, logger.INFO, os.Stderr)
+}